@@ -0,0 +1,126 @@
+package accesstoken
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+// ProviderName const
+const ProviderName = "accesstoken"
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "Access token login backend opts: providers=github;gitlab[,match_username=true|false]",
+		},
+		BackendFactory)
+}
+
+// tokenPrefixes are the prefixes of the tokens of each provider.
+// The token is only sent to the provider, which issued it.
+var tokenPrefixes = map[string][]string{
+	"github": {"ghp_", "github_pat_", "gho_"},
+	"gitlab": {"glpat-"},
+}
+
+// BackendFactory creates an access token backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	var providers []string
+	matchUsername := true
+	for key, value := range opts {
+		var err error
+		switch key {
+		case "providers":
+			providers = strings.Split(value, ";")
+		case "match_username":
+			matchUsername, err = strconv.ParseBool(value)
+		default:
+			return nil, fmt.Errorf("unknown parameter %q for accesstoken provider", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" accesstoken provider: %v`, value, key, err)
+		}
+	}
+	return NewBackend(providers, matchUsername)
+}
+
+// Backend is an authentication backend, which accepts personal access tokens of an oauth provider as password.
+// The token is verified by fetching the user info from the api of the provider.
+type Backend struct {
+	providers     []oauth2.Provider
+	matchUsername bool
+}
+
+// NewBackend creates a new Backend for the named oauth providers.
+// If matchUsername is true, the username of the login has to be the username of the token owner.
+func NewBackend(providerNames []string, matchUsername bool) (*Backend, error) {
+	if len(providerNames) == 0 {
+		return nil, errors.New(`missing parameter "providers" for accesstoken provider`)
+	}
+	b := &Backend{matchUsername: matchUsername}
+	for _, name := range providerNames {
+		p, exist := oauth2.GetProvider(name)
+		if !exist || p.GetUserInfo == nil {
+			return nil, fmt.Errorf("accesstoken provider: no oauth provider %q", name)
+		}
+		b.providers = append(b.providers, p)
+	}
+	return b, nil
+}
+
+// Authenticate the user with the access token as password.
+// The token is verified only by the provider, which issued it (see providerOf).
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if password == "" {
+		return false, model.UserInfo{}, nil
+	}
+	p, found := b.providerOf(password)
+	if !found {
+		return false, model.UserInfo{}, nil
+	}
+
+	userInfo, _, err := p.GetUserInfo(oauth2.TokenInfo{AccessToken: password})
+	if err == oauth2.ErrInvalidToken {
+		return false, model.UserInfo{}, nil
+	}
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("accesstoken provider: %v: %v", p.Name, err)
+	}
+	if b.matchUsername && !strings.EqualFold(username, userInfo.Sub) {
+		return false, model.UserInfo{}, nil
+	}
+	return true, userInfo, nil
+}
+
+// providerOf returns the configured provider, which issued the token, by the prefix of the token.
+// A token without a known prefix, e.g. an older or an oauth token, is only accepted,
+// if there is one provider. Otherwise it would be sent to the wrong providers.
+func (b *Backend) providerOf(token string) (oauth2.Provider, bool) {
+	for name, prefixes := range tokenPrefixes {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(token, prefix) {
+				return b.provider(name)
+			}
+		}
+	}
+	if len(b.providers) == 1 {
+		return b.providers[0], true
+	}
+	return oauth2.Provider{}, false
+}
+
+func (b *Backend) provider(name string) (oauth2.Provider, bool) {
+	for _, p := range b.providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return oauth2.Provider{}, false
+}
@@ -0,0 +1,153 @@
+package accesstoken
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+// sentTokens are the tokens, which were sent to the test providers
+var sentTokens = map[string][]string{}
+
+func init() {
+	oauth2.RegisterProvider(oauth2.Provider{
+		Name:        "tokentest",
+		GetUserInfo: testUserInfo("tokentest", "tt1_secret", "bob", nil),
+	})
+	oauth2.RegisterProvider(oauth2.Provider{
+		Name:        "tokentest2",
+		GetUserInfo: testUserInfo("tokentest2", "tt2_other", "alice", nil),
+	})
+	oauth2.RegisterProvider(oauth2.Provider{
+		Name:        "tokenerror",
+		GetUserInfo: testUserInfo("tokenerror", "", "", errors.New("api not reachable")),
+	})
+	tokenPrefixes["tokentest"] = []string{"tt1_"}
+	tokenPrefixes["tokentest2"] = []string{"tt2_"}
+}
+
+func testUserInfo(name, validToken, sub string, err error) func(oauth2.TokenInfo) (model.UserInfo, string, error) {
+	return func(token oauth2.TokenInfo) (model.UserInfo, string, error) {
+		sentTokens[name] = append(sentTokens[name], token.AccessToken)
+		if err != nil {
+			return model.UserInfo{}, "", err
+		}
+		if token.AccessToken != validToken {
+			return model.UserInfo{}, "", oauth2.ErrInvalidToken
+		}
+		return model.UserInfo{Sub: sub, Origin: "tokentest", Groups: []string{"example/dev"}}, "{}", nil
+	}
+}
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"providers":      "github;gitlab",
+		"match_username": "false",
+	})
+	NoError(t, err)
+	b := backend.(*Backend)
+	Equal(t, "github", b.providers[0].Name)
+	Equal(t, "gitlab", b.providers[1].Name)
+	False(t, b.matchUsername)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"providers": "unknown"},
+		{"providers": "github", "match_username": "maybe"},
+		{"providers": "github", "foo": "bar"},
+	} {
+		_, err := p(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	sentTokens = map[string][]string{}
+	backend, err := NewBackend([]string{"tokentest", "tokentest2"}, true)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("Bob", "tt1_secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, "tokentest", userInfo.Origin)
+	Equal(t, []string{"example/dev"}, userInfo.Groups)
+
+	authenticated, userInfo, err = backend.Authenticate("alice", "tt2_other")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "alice", userInfo.Sub)
+
+	authenticated, _, err = backend.Authenticate("bob", "tt1_wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// tokens of other or unknown providers are not sent anywhere
+	for _, token := range []string{"ghp_secret", "unknown-token", ""} {
+		authenticated, _, err = backend.Authenticate("bob", token)
+		NoError(t, err)
+		False(t, authenticated)
+	}
+
+	// each token was only sent to the provider, which issued it
+	Equal(t, map[string][]string{
+		"tokentest":  {"tt1_secret", "tt1_wrong"},
+		"tokentest2": {"tt2_other"},
+	}, sentTokens)
+}
+
+func TestBackend_Authenticate_SingleProvider(t *testing.T) {
+	backend, err := NewBackend([]string{"tokentest"}, true)
+	NoError(t, err)
+
+	// a token without known prefix is sent to the only provider
+	sentTokens = map[string][]string{}
+	authenticated, _, err := backend.Authenticate("bob", "unknown-token")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, map[string][]string{"tokentest": {"unknown-token"}}, sentTokens)
+
+	// but not the token of another provider
+	authenticated, _, err = backend.Authenticate("bob", "tt2_other")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, map[string][]string{"tokentest": {"unknown-token"}}, sentTokens)
+}
+
+func TestBackend_Authenticate_MatchUsername(t *testing.T) {
+	backend, err := NewBackend([]string{"tokentest"}, true)
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("alice", "tt1_secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	backend, err = NewBackend([]string{"tokentest"}, false)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("alice", "tt1_secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestBackend_Authenticate_Error(t *testing.T) {
+	backend, err := NewBackend([]string{"tokenerror"}, true)
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("bob", "some-token")
+	False(t, authenticated)
+	EqualError(t, err, "accesstoken provider: tokenerror: api not reachable")
+}
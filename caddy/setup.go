@@ -14,10 +14,16 @@ import (
 	"github.com/tarent/loginsrv/login"
 
 	// Import all backends, packaged with the caddy plugin
+	_ "github.com/tarent/loginsrv/accesstoken"
+	_ "github.com/tarent/loginsrv/exec"
 	_ "github.com/tarent/loginsrv/htpasswd"
 	_ "github.com/tarent/loginsrv/httpupstream"
+	_ "github.com/tarent/loginsrv/ldap"
 	_ "github.com/tarent/loginsrv/oauth2"
 	_ "github.com/tarent/loginsrv/osiam"
+	_ "github.com/tarent/loginsrv/radius"
+	_ "github.com/tarent/loginsrv/sql"
+	_ "github.com/tarent/loginsrv/userfile"
 )
 
 func init() {
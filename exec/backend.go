@@ -0,0 +1,231 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "exec"
+
+const (
+	// DefaultTimeout is the time, after which the command is killed.
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultMaxConcurrent is the number of commands running at the same time.
+	DefaultMaxConcurrent = 4
+)
+
+// Exit codes of the command
+const (
+	// ExitAuthenticated means, that the credentials are valid.
+	ExitAuthenticated = 0
+
+	// ExitRejected means, that the credentials are wrong. Every other exit code is an error.
+	ExitRejected = 1
+)
+
+// maxOutputSize limits the size of the output of the command, which is read
+const maxOutputSize = 1 << 20
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "Exec login backend opts: command=/path/to/command[,args=..;..,timeout=..,max_concurrent=..]",
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates an exec backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		Timeout:       DefaultTimeout,
+		MaxConcurrent: DefaultMaxConcurrent,
+	}
+	for key, value := range opts {
+		var err error
+		switch key {
+		case "command":
+			cfg.Command = value
+		case "args":
+			cfg.Args = strings.Split(value, ";")
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		case "max_concurrent":
+			cfg.MaxConcurrent, err = strconv.Atoi(value)
+		default:
+			return nil, fmt.Errorf("unknown parameter %q for exec provider", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" exec provider: %v`, value, key, err)
+		}
+	}
+	return NewBackend(cfg)
+}
+
+// Config for the exec backend
+type Config struct {
+	// Command is the path of the executable.
+	Command string
+
+	// Args are fixed arguments of the command. The credentials are never passed as arguments.
+	Args []string
+
+	// Timeout for one login, including the wait for a free slot.
+	Timeout time.Duration
+
+	// MaxConcurrent is the number of commands running at the same time.
+	MaxConcurrent int
+}
+
+// Backend is an authentication backend, which runs a command for each login.
+// The command gets the credentials as JSON object on stdin and reports the result by its exit code.
+// On success, it may write the user info with additional claims as JSON object to stdout.
+type Backend struct {
+	config Config
+	slots  chan struct{}
+}
+
+// NewBackend creates a new Backend and verifies the parameters.
+func NewBackend(cfg Config) (*Backend, error) {
+	if cfg.Command == "" {
+		return nil, errors.New(`missing parameter "command" for exec provider`)
+	}
+	if _, err := osexec.LookPath(cfg.Command); err != nil {
+		return nil, fmt.Errorf("exec provider: %v", err)
+	}
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("exec provider: timeout has to be positive, got %v", cfg.Timeout)
+	}
+	if cfg.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("exec provider: max_concurrent has to be positive, got %v", cfg.MaxConcurrent)
+	}
+	return &Backend{
+		config: cfg,
+		slots:  make(chan struct{}, cfg.MaxConcurrent),
+	}, nil
+}
+
+// input is the JSON object, which is written to stdin of the command
+type input struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	RemoteIP      string `json:"remote_ip,omitempty"`
+	UserAgent     string `json:"user_agent,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// rejection is the optional output of a command, which rejects a login for a specific reason
+type rejection struct {
+	ErrorCode string `json:"error_code"`
+	Error     string `json:"error"`
+}
+
+// Authenticate the user
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext runs the command with the credentials and information about the client.
+// The command is killed, if the context is cancelled or the timeout exceeded.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.config.Timeout)
+	defer cancel()
+
+	select {
+	case b.slots <- struct{}{}:
+		defer func() { <-b.slots }()
+	case <-ctx.Done():
+		return false, model.UserInfo{}, fmt.Errorf("exec provider: no free slot to run %v: %v", b.config.Command, ctx.Err())
+	}
+
+	in, err := json.Marshal(input{
+		Username:      req.Username,
+		Password:      req.Password,
+		RemoteIP:      req.RemoteIP,
+		UserAgent:     req.UserAgent,
+		CorrelationID: req.CorrelationID,
+	})
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	var stdout, stderr limitedBuffer
+	cmd := osexec.CommandContext(ctx, b.config.Command, b.config.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return false, model.UserInfo{}, fmt.Errorf("exec provider: %v killed: %v", b.config.Command, ctx.Err())
+	}
+	if exitErr, ok := err.(*osexec.ExitError); ok && exitErr.ExitCode() == ExitRejected {
+		return false, model.UserInfo{}, parseRejection(stdout.Bytes())
+	}
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("exec provider: %v failed: %v: %v", b.config.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	userInfo, err := parseUserInfo(stdout.Bytes())
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("exec provider: invalid output of %v: %v", b.config.Command, err)
+	}
+	if userInfo.Sub == "" {
+		userInfo.Sub = req.Username
+	}
+	userInfo.Origin = ProviderName
+	return true, userInfo, nil
+}
+
+// parseUserInfo reads the optional user info from the output.
+// The token specific fields are ignored, because they are set by loginsrv.
+func parseUserInfo(out []byte) (model.UserInfo, error) {
+	userInfo := model.UserInfo{}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return userInfo, nil
+	}
+	if err := json.Unmarshal(out, &userInfo); err != nil {
+		return userInfo, err
+	}
+	userInfo.Expiry = 0
+	userInfo.Refreshes = 0
+	userInfo.AMR = nil
+	return userInfo, nil
+}
+
+// parseRejection returns a *login.AuthError, if the output contains an error_code.
+func parseRejection(out []byte) error {
+	r := rejection{}
+	if err := json.Unmarshal(out, &r); err != nil || r.ErrorCode == "" {
+		return nil
+	}
+	return login.NewAuthError(r.ErrorCode, r.Error)
+}
+
+// limitedBuffer keeps the first maxOutputSize bytes of the output and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := maxOutputSize - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
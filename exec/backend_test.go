@@ -0,0 +1,222 @@
+package exec
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+)
+
+// testScript reads the credentials with a simple pattern match,
+// which is enough for the JSON written by the backend.
+const testScript = `#!/bin/sh
+input=$(cat)
+case "$input" in
+  *'"username":"bob","password":"secret"'*)
+    echo '{"name": "Bob Builder", "email": "bob@example.com", "groups": ["builders"], "department": "construction"}'
+    exit 0 ;;
+  *'"username":"alice","password":"secret"'*)
+    exit 0 ;;
+  *'"username":"carol"'*)
+    echo '{"error_code": "account_locked", "error": "locked by the admin"}'
+    exit 1 ;;
+  *'"username":"broken"'*)
+    echo 'no json'
+    exit 0 ;;
+  *'"username":"error"'*)
+    echo 'something went wrong' >&2
+    exit 2 ;;
+  *'"username":"slow"'*)
+    exec sleep 5 ;;
+  *'"username":"args"'*)
+    echo "{\"args\": \"$*\"}"
+    exit 0 ;;
+esac
+exit 1
+`
+
+func writeTestScript(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "loginsrv_exectest")
+	NoError(t, err)
+	filename := filepath.Join(dir, "auth.sh")
+	NoError(t, ioutil.WriteFile(filename, []byte(content), 0700))
+	return filename
+}
+
+func TestSetup(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	backend, err := p(map[string]string{
+		"command":        script,
+		"args":           "--foo;bar",
+		"timeout":        "2s",
+		"max_concurrent": "8",
+	})
+	NoError(t, err)
+	Equal(t, Config{Command: script, Args: []string{"--foo", "bar"}, Timeout: 2 * time.Second, MaxConcurrent: 8}, backend.(*Backend).config)
+
+	backend, err = p(map[string]string{"command": script})
+	NoError(t, err)
+	Equal(t, DefaultTimeout, backend.(*Backend).config.Timeout)
+	Equal(t, DefaultMaxConcurrent, backend.(*Backend).config.MaxConcurrent)
+}
+
+func TestSetup_Error(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	p, _ := login.GetProvider(ProviderName)
+	for _, opts := range []map[string]string{
+		{},
+		{"command": "/does/not/exist"},
+		{"command": script, "timeout": "soon"},
+		{"command": script, "timeout": "0s"},
+		{"command": script, "max_concurrent": "0"},
+		{"command": script, "foo": "bar"},
+	} {
+		_, err := p(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	backend, err := NewBackend(Config{Command: script, Timeout: time.Second, MaxConcurrent: 1})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{
+		Origin: "exec",
+		Sub:    "bob",
+		Name:   "Bob Builder",
+		Email:  "bob@example.com",
+		Groups: []string{"builders"},
+		Claims: map[string]interface{}{"department": "construction"},
+	}, userInfo)
+
+	// no output is fine
+	authenticated, userInfo, err = backend.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, model.UserInfo{Origin: "exec", Sub: "alice"}, userInfo)
+
+	authenticated, _, err = backend.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("carol", "secret")
+	False(t, authenticated)
+	Equal(t, login.NewAuthError(login.AuthErrorAccountLocked, "locked by the admin"), err)
+
+	_, _, err = backend.Authenticate("broken", "secret")
+	Error(t, err)
+
+	_, _, err = backend.Authenticate("error", "secret")
+	Error(t, err)
+	Contains(t, err.Error(), "something went wrong")
+}
+
+func TestBackend_Args(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	backend, err := NewBackend(Config{Command: script, Args: []string{"--realm", "example"}, Timeout: time.Second, MaxConcurrent: 1})
+	NoError(t, err)
+
+	// only the fixed arguments are passed, never the credentials
+	authenticated, userInfo, err := backend.Authenticate("args", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "--realm example", userInfo.Claims["args"])
+}
+
+func TestBackend_AuthenticateContext(t *testing.T) {
+	script := writeTestScript(t, `#!/bin/sh
+cat
+`)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	backend, err := NewBackend(Config{Command: script, Timeout: time.Second, MaxConcurrent: 1})
+	NoError(t, err)
+
+	// the script echoes the input, so the fields of the input are returned as user info and claims
+	authenticated, userInfo, err := backend.AuthenticateContext(context.Background(), login.LoginRequest{
+		Username:      "bob",
+		Password:      "secret",
+		RemoteIP:      "10.0.0.1",
+		UserAgent:     "test-agent",
+		CorrelationID: "abc123",
+	})
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, map[string]interface{}{
+		"username":       "bob",
+		"password":       "secret",
+		"remote_ip":      "10.0.0.1",
+		"user_agent":     "test-agent",
+		"correlation_id": "abc123",
+	}, userInfo.Claims)
+}
+
+func TestBackend_Timeout(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	backend, err := NewBackend(Config{Command: script, Timeout: 100 * time.Millisecond, MaxConcurrent: 1})
+	NoError(t, err)
+
+	start := time.Now()
+	authenticated, _, err := backend.Authenticate("slow", "secret")
+	Error(t, err)
+	False(t, authenticated)
+	True(t, time.Since(start) < 2*time.Second)
+
+	// cancelled by the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob", Password: "secret"})
+	Error(t, err)
+}
+
+func TestBackend_MaxConcurrent(t *testing.T) {
+	script := writeTestScript(t, testScript)
+	defer os.RemoveAll(filepath.Dir(script))
+
+	backend, err := NewBackend(Config{Command: script, Timeout: 500 * time.Millisecond, MaxConcurrent: 1})
+	NoError(t, err)
+
+	// the slow login blocks the only slot, so the second one is cancelled while waiting
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		backend.Authenticate("slow", "secret")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err = backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob", Password: "secret"})
+	Error(t, err)
+	Contains(t, err.Error(), "no free slot")
+	wg.Wait()
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
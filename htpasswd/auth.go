@@ -1,148 +1,369 @@
 package htpasswd
 
 import (
+	"bufio"
 	"bytes"
-	"crypto/sha1"
-	"crypto/subtle"
-	"encoding/base64"
+	"context"
 	"encoding/csv"
 	"fmt"
-	"github.com/abbot/go-http-auth"
+	"github.com/fsnotify/fsnotify"
 	"github.com/tarent/loginsrv/logging"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/tarent/loginsrv/pwhash"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 )
 
-// File is a struct to serve an individual modTime
-type File struct {
-	name string
-	// Used in func reloadIfChanged to reload htpasswd file if it changed
-	modTime time.Time
-}
+// reloadDelay is the time to wait for further changes of the files, before they are reloaded.
+// Editors often write a file in multiple steps, so this avoids parsing incomplete files.
+const reloadDelay = 100 * time.Millisecond
 
 // Auth is the htpassword authenticater
 type Auth struct {
-	filenames  []File
-	userHash   map[string]string
-	muUserHash sync.RWMutex
+	filenames      []string
+	groupFilenames []string
+	userHash       map[string]string
+	userGroups     map[string][]string
+	muUserHash     sync.RWMutex
+	muChange       sync.Mutex
+	watcher        *fsnotify.Watcher
+
+	// upgradeAlgorithm is the algorithm, to which weak hashes are upgraded after a successful login.
+	// The upgrade is disabled, if it is empty.
+	upgradeAlgorithm string
+
+	// pool limits the parallel hash verifications
+	pool *pwhash.Pool
 }
 
-// NewAuth creates an htpassword authenticater
+// NewAuth creates an htpassword authenticater.
+// The files are watched for changes and reloaded in the background.
 func NewAuth(filenames []string) (*Auth, error) {
-	var htpasswdFiles []File
-	for _, file := range filenames {
-		htpasswdFiles = append(htpasswdFiles, File{name: file})
-	}
+	return NewAuthWithGroups(filenames, nil)
+}
 
+// NewAuthWithGroups creates an htpassword authenticater, which also reads
+// the groups of the users from Apache style group files.
+// All files are watched for changes and reloaded in the background.
+func NewAuthWithGroups(filenames, groupFilenames []string) (*Auth, error) {
 	a := &Auth{
-		filenames: htpasswdFiles,
+		filenames:      filenames,
+		groupFilenames: groupFilenames,
+		pool:           pwhash.DefaultPool,
+	}
+	if err := a.parse(); err != nil {
+		return a, err
 	}
-	return a, a.parse()
+	return a, a.watch()
 }
 
+// Close stops watching the files for changes.
+func (a *Auth) Close() error {
+	if a.watcher == nil {
+		return nil
+	}
+	return a.watcher.Close()
+}
+
+// parse reads all files and replaces the users only, if all of them could be read successfully.
 func (a *Auth) parse() error {
 	tmpUserHash := map[string]string{}
-	tmpFilenames := a.filenames
-
-	for i, filename := range a.filenames {
-		r, err := os.Open(filename.name)
-		if err != nil {
+	for _, filename := range a.filenames {
+		if err := parseFile(filename, tmpUserHash); err != nil {
+			return err
+		}
+	}
+	tmpUserGroups := map[string][]string{}
+	for _, filename := range a.groupFilenames {
+		if err := parseGroupFile(filename, tmpUserGroups); err != nil {
 			return err
 		}
-		defer r.Close()
+	}
 
-		fileInfo, err := os.Stat(filename.name)
+	a.muUserHash.Lock()
+	a.userHash = tmpUserHash
+	a.userGroups = tmpUserGroups
+	a.muUserHash.Unlock()
+
+	return nil
+}
+
+func parseFile(filename string, userHash map[string]string) error {
+	r, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cr := csv.NewReader(r)
+	cr.Comma = ':'
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		tmpFilenames[i].modTime = fileInfo.ModTime()
+		if len(record) != 2 {
+			return fmt.Errorf("password file in wrong format (%v)", filename)
+		}
 
-		cr := csv.NewReader(r)
-		cr.Comma = ':'
-		cr.Comment = '#'
-		cr.TrimLeadingSpace = true
+		if _, exist := userHash[record[0]]; exist {
+			logging.Logger.Warnf("Found duplicate entry for user: (%v)", record[0])
+		}
+		userHash[record[0]] = record[1]
+	}
+}
 
-		for {
-			record, err := cr.Read()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return err
+func (a *Auth) allFilenames() []string {
+	return append(append([]string{}, a.filenames...), a.groupFilenames...)
+}
+
+// watch starts watching the directories of the files. The directories are watched instead of the
+// files themselves, because a file which is replaced by a rename would not be tracked otherwise.
+func (a *Auth) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("can not watch htpasswd files: %v", err)
+	}
+
+	watched := map[string]bool{}
+	for _, filename := range a.allFilenames() {
+		dir := filepath.Dir(filename)
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("can not watch htpasswd files in %v: %v", dir, err)
+		}
+		watched[dir] = true
+	}
+
+	a.watcher = watcher
+	go a.handleEvents(watcher)
+	return nil
+}
+
+// handleEvents reloads the files after any change in the watched directories.
+// Not only the events of the files themselves are used, because a file may be a symlink, whose target is replaced
+// by another symlink in the directory, e.g. the ..data link of a Kubernetes ConfigMap or Secret.
+func (a *Auth) handleEvents(watcher *fsnotify.Watcher) {
+	var reload <-chan time.Time
+	for {
+		select {
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return
 			}
-			if len(record) != 2 {
-				return fmt.Errorf("password file in wrong format (%v)", filename)
+			reload = time.After(reloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
 			}
-
-			if _, exist := tmpUserHash[record[0]]; exist {
-				logging.Logger.Warnf("Found duplicate entry for user: (%v)", record[0])
+			logging.Logger.WithError(err).Warn("error while watching htpasswd files")
+		case <-reload:
+			reload = nil
+			if err := a.parse(); err != nil {
+				logging.Logger.WithError(err).Error("could not reload htpasswd files, keeping the previous users")
+				continue
 			}
-			tmpUserHash[record[0]] = record[1]
+			logging.Logger.Info("reloaded htpasswd files")
 		}
 	}
-	a.muUserHash.Lock()
-	a.userHash = tmpUserHash
-	a.filenames = tmpFilenames
-	a.muUserHash.Unlock()
+}
 
+// UpgradeWeakHashes enables the upgrade of weak hashes ({SHA} and $apr1$) after a successful login.
+// The entry of the user is rewritten with a hash of the algorithm, which is bcrypt or argon2id.
+func (a *Auth) UpgradeWeakHashes(algorithm string) error {
+	if err := pwhash.ValidateUpgradeAlgorithm(algorithm); err != nil {
+		return err
+	}
+	a.upgradeAlgorithm = algorithm
 	return nil
 }
 
+// SetPool replaces the shared pwhash.DefaultPool by a pool for the hash verifications of this Auth.
+func (a *Auth) SetPool(pool *pwhash.Pool) {
+	a.pool = pool
+}
+
 // Authenticate the user
 func (a *Auth) Authenticate(username, password string) (bool, error) {
-	reloadIfChanged(a)
+	return a.AuthenticateContext(context.Background(), username, password)
+}
+
+// AuthenticateContext checks the password of the user, as soon as a worker of the pool is free.
+// It returns pwhash.ErrQueueTimeout, if no worker became free within the queue timeout of the pool.
+func (a *Auth) AuthenticateContext(ctx context.Context, username, password string) (bool, error) {
+	matched, hash, err := a.compare(ctx, username, password)
+	if matched && a.upgradeAlgorithm != "" && pwhash.IsWeak(hash) {
+		a.upgradeHash(ctx, username, hash, password)
+	}
+	return matched, err
+}
+
+// Exists returns true, if the user is in one of the htpasswd files.
+func (a *Auth) Exists(username string) bool {
 	a.muUserHash.RLock()
 	defer a.muUserHash.RUnlock()
-	if hash, exist := a.userHash[username]; exist {
-		h := []byte(hash)
-		p := []byte(password)
-		if strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$") {
-			matchErr := bcrypt.CompareHashAndPassword(h, p)
-			return (matchErr == nil), nil
-		}
-		if strings.HasPrefix(hash, "{SHA}") {
-			return compareSha(h, p), nil
-		}
-		if strings.HasPrefix(hash, "$apr1$") {
-			return compareMD5(h, p), nil
-		}
-		return false, fmt.Errorf("unknown algorithm for user %q", username)
+	_, exist := a.userHash[username]
+	return exist
+}
+
+// compare checks the password and returns the hash, which was used.
+func (a *Auth) compare(ctx context.Context, username, password string) (bool, string, error) {
+	a.muUserHash.RLock()
+	hash, exist := a.userHash[username]
+	a.muUserHash.RUnlock()
+	if !exist {
+		return false, "", nil
+	}
+
+	matched, err := a.pool.CompareHash(ctx, hash, password)
+	if err == pwhash.ErrUnknownAlgorithm {
+		return false, "", fmt.Errorf("unknown algorithm for user %q", username)
 	}
-	return false, nil
+	return matched, hash, err
 }
 
-// Reload htpasswd file if it changed during current run
-func reloadIfChanged(a *Auth) {
-	for _, file := range a.filenames {
-		fileInfo, err := os.Stat(file.name)
-		if err != nil {
-			//On error, retain current file
-			break
+// upgradeHash replaces the weak hash of the user by a hash of the upgrade algorithm.
+// Errors are logged only, because the login itself was successful.
+func (a *Auth) upgradeHash(ctx context.Context, username, oldHash, password string) {
+	a.muChange.Lock()
+	defer a.muChange.Unlock()
+
+	// the hash may have been changed in the meantime
+	a.muUserHash.RLock()
+	current := a.userHash[username]
+	a.muUserHash.RUnlock()
+	if current != oldHash {
+		return
+	}
+
+	log := logging.Logger.WithField("user", username)
+	if err := a.replaceHash(ctx, username, password, a.upgradeAlgorithm); err != nil {
+		log.WithError(err).Error("could not upgrade weak htpasswd hash")
+		return
+	}
+	log.Infof("upgraded htpasswd hash from %v to %v", pwhash.HashAlgorithm(oldHash), a.upgradeAlgorithm)
+}
+
+// Groups returns the groups of the user from the group files.
+func (a *Auth) Groups(username string) []string {
+	a.muUserHash.RLock()
+	defer a.muUserHash.RUnlock()
+	return append([]string(nil), a.userGroups[username]...)
+}
+
+// ChangePassword checks the current password of the user and replaces it by a hash of the new one.
+// The hash is created with the upgrade algorithm, if configured, and with bcrypt otherwise.
+// Only the entry of the user in the file, which defines the user, is rewritten.
+// The file is replaced atomically, so the watcher reloads it like any other change.
+func (a *Auth) ChangePassword(username, currentPassword, newPassword string) (bool, error) {
+	a.muChange.Lock()
+	defer a.muChange.Unlock()
+
+	matched, _, err := a.compare(context.Background(), username, currentPassword)
+	if err != nil || !matched {
+		return false, err
+	}
+
+	algorithm := a.upgradeAlgorithm
+	if algorithm == "" {
+		algorithm = pwhash.AlgorithmBcrypt
+	}
+	if err := a.replaceHash(context.Background(), username, newPassword, algorithm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replaceHash writes a new hash of the password to the file, which defines the user.
+// The hash is created by the pool. The caller has to hold muChange.
+func (a *Auth) replaceHash(ctx context.Context, username, password, algorithm string) error {
+	hash, err := a.pool.GenerateHash(ctx, algorithm, password)
+	if err != nil {
+		return err
+	}
+
+	filename, err := a.definingFile(username)
+	if err != nil {
+		return err
+	}
+	if err := replaceUserHash(filename, username, hash); err != nil {
+		return err
+	}
+
+	a.muUserHash.Lock()
+	a.userHash[username] = hash
+	a.muUserHash.Unlock()
+	return nil
+}
+
+// definingFile returns the last file containing the user, because later entries override the previous ones.
+func (a *Auth) definingFile(username string) (string, error) {
+	for i := len(a.filenames) - 1; i >= 0; i-- {
+		userHash := map[string]string{}
+		if err := parseFile(a.filenames[i], userHash); err != nil {
+			return "", err
 		}
-		currentmodTime := fileInfo.ModTime()
-		if currentmodTime != file.modTime {
-			a.parse()
-			return
+		if _, exist := userHash[username]; exist {
+			return a.filenames[i], nil
 		}
 	}
+	return "", fmt.Errorf("no htpasswd file contains user %q", username)
 }
 
-func compareSha(hashedPassword, password []byte) bool {
-	d := sha1.New()
-	d.Write(password)
-	return 1 == subtle.ConstantTimeCompare(hashedPassword[5:], []byte(base64.StdEncoding.EncodeToString(d.Sum(nil))))
-}
+// replaceUserHash rewrites the file with a new hash for the user.
+// All other lines are kept as they are.
+func replaceUserHash(filename, username, hash string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), username+":") {
+			line = username + ":" + hash
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
 
-func compareMD5(hashedPassword, password []byte) bool {
-	parts := bytes.SplitN(hashedPassword, []byte("$"), 4)
-	if len(parts) != 4 {
-		return false
+	if _, err := tmp.Write(out.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
-	magic := []byte("$" + string(parts[1]) + "$")
-	salt := parts[2]
-	return 1 == subtle.ConstantTimeCompare(hashedPassword, auth.MD5Crypt(password, salt, magic))
+	return os.Rename(tmp.Name(), filename)
 }
@@ -2,8 +2,10 @@ package htpasswd
 
 import (
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/pwhash"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -43,6 +45,7 @@ func TestAuth_ReloadFile(t *testing.T) {
 
 	auth, err := NewAuth(files)
 	NoError(t, err)
+	defer auth.Close()
 
 	authenticated, err := auth.Authenticate("bob", "secret")
 	NoError(t, err)
@@ -52,19 +55,103 @@ func TestAuth_ReloadFile(t *testing.T) {
 	NoError(t, err)
 	False(t, authenticated)
 
-	// The refresh is time based, so we have to wait a second, here
-	time.Sleep(time.Second)
-
 	err = ioutil.WriteFile(files[0], []byte(`alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`), 06644)
 	NoError(t, err)
 
+	Eventually(t, func() bool {
+		authenticated, _ := auth.Authenticate("alice", "secret")
+		return authenticated
+	}, 5*time.Second, 10*time.Millisecond)
+
 	authenticated, err = auth.Authenticate("bob", "secret")
 	NoError(t, err)
 	False(t, authenticated)
+}
 
-	authenticated, err = auth.Authenticate("alice", "secret")
+func TestAuth_ReloadFileReplacedByRename(t *testing.T) {
+	files := writeTmpfile(`bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+	defer auth.Close()
+
+	tmp := files[0] + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(`alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`), 0644)
+	NoError(t, err)
+	NoError(t, os.Rename(tmp, files[0]))
+
+	Eventually(t, func() bool {
+		authenticated, _ := auth.Authenticate("alice", "secret")
+		return authenticated
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAuth_ReloadSymlinkSwap(t *testing.T) {
+	// the layout of a Kubernetes ConfigMap or Secret volume
+	dir, err := ioutil.TempDir("", "loginsrv_htpasswdtest")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeVersion := func(version, content string) {
+		NoError(t, os.Mkdir(filepath.Join(dir, version), 0755))
+		NoError(t, ioutil.WriteFile(filepath.Join(dir, version, "htpasswd"), []byte(content), 0644))
+	}
+	writeVersion("..v1", `bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
+	NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+	NoError(t, os.Symlink("..data/htpasswd", filepath.Join(dir, "htpasswd")))
+
+	auth, err := NewAuth([]string{filepath.Join(dir, "htpasswd")})
+	NoError(t, err)
+	defer auth.Close()
+
+	authenticated, err := auth.Authenticate("bob", "secret")
 	NoError(t, err)
 	True(t, authenticated)
+
+	// the update replaces the ..data symlink, the htpasswd symlink itself is not changed
+	writeVersion("..v2", `alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
+	NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	NoError(t, os.RemoveAll(filepath.Join(dir, "..v1")))
+
+	Eventually(t, func() bool {
+		authenticated, _ := auth.Authenticate("alice", "secret")
+		return authenticated
+	}, 5*time.Second, 10*time.Millisecond)
+
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestAuth_ReloadKeepsUsersOnInvalidFile(t *testing.T) {
+	files := writeTmpfile(`bob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`)
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+	defer auth.Close()
+
+	err = ioutil.WriteFile(files[0], []byte("alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\nfoo bar bazz"), 0644)
+	NoError(t, err)
+
+	// wait until the reload has been processed
+	time.Sleep(5 * reloadDelay)
+
+	authenticated, err := auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	authenticated, err = auth.Authenticate("alice", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// a fixed file is loaded again
+	err = ioutil.WriteFile(files[0], []byte(`alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.`), 0644)
+	NoError(t, err)
+
+	Eventually(t, func() bool {
+		authenticated, _ := auth.Authenticate("alice", "secret")
+		return authenticated
+	}, 5*time.Second, 10*time.Millisecond)
 }
 
 func TestAuth_FromTwoFiles(t *testing.T) {
@@ -87,6 +174,7 @@ func TestAuth_ReloadFileDeleted(t *testing.T) {
 
 	auth, err := NewAuth(files)
 	NoError(t, err)
+	defer auth.Close()
 
 	authenticated, err := auth.Authenticate("bob", "secret")
 	NoError(t, err)
@@ -96,11 +184,9 @@ func TestAuth_ReloadFileDeleted(t *testing.T) {
 	NoError(t, err)
 	False(t, authenticated)
 
-	// The refresh is time based, so we have to wait a second, here
-	time.Sleep(time.Second)
-
-	// delete file and load auth from "cache"
+	// delete file and keep the users loaded before
 	_ = os.Remove(files[0])
+	time.Sleep(5 * reloadDelay)
 
 	authenticated, err = auth.Authenticate("bob", "secret")
 	NoError(t, err)
@@ -149,6 +235,129 @@ func TestAuth_Hashes_UnknownAlgoError(t *testing.T) {
 	False(t, authenticated)
 }
 
+func TestAuth_ChangePassword(t *testing.T) {
+	files := writeTmpfile("# users\nbob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", "alice:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\nbob-2:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n")
+	NoError(t, os.Chmod(files[1], 0640))
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+	defer auth.Close()
+
+	changed, err := auth.ChangePassword("alice", "wrong", "n3w-secret")
+	NoError(t, err)
+	False(t, changed)
+
+	changed, err = auth.ChangePassword("alice", "secret", "n3w-secret")
+	NoError(t, err)
+	True(t, changed)
+
+	authenticated, err := auth.Authenticate("alice", "n3w-secret")
+	NoError(t, err)
+	True(t, authenticated)
+	authenticated, err = auth.Authenticate("alice", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// only the line of alice in the second file was rewritten
+	content, err := ioutil.ReadFile(files[0])
+	NoError(t, err)
+	Equal(t, "# users\nbob:$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.\n", string(content))
+	content, err = ioutil.ReadFile(files[1])
+	NoError(t, err)
+	Regexp(t, `^alice:\$2a\$10\$.+\nbob-2:\$apr1\$IDZSCL/o\$N68zaFDDRivjour94OVeB.\n$`, string(content))
+
+	info, err := os.Stat(files[1])
+	NoError(t, err)
+	Equal(t, os.FileMode(0640), info.Mode())
+
+	// the change survives the reload of the file
+	time.Sleep(5 * reloadDelay)
+	authenticated, err = auth.Authenticate("alice", "n3w-secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+func TestAuth_ChangePasswordUnknownUser(t *testing.T) {
+	auth, err := NewAuth(writeTmpfile(testfile))
+	NoError(t, err)
+
+	changed, err := auth.ChangePassword("unknown", "secret", "n3w-secret")
+	NoError(t, err)
+	False(t, changed)
+}
+
+func TestAuth_UpgradeWeakHashes(t *testing.T) {
+	for _, algorithm := range []string{pwhash.AlgorithmBcrypt, pwhash.AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			files := writeTmpfile(testfile)
+
+			auth, err := NewAuth(files)
+			NoError(t, err)
+			defer auth.Close()
+			NoError(t, auth.UpgradeWeakHashes(algorithm))
+
+			for _, user := range []string{"bob-md5", "bob-sha"} {
+				authenticated, err := auth.Authenticate(user, "secret")
+				NoError(t, err)
+				True(t, authenticated)
+			}
+
+			// wrong passwords and strong hashes are kept
+			authenticated, err := auth.Authenticate("bob-bcrypt", "secret")
+			NoError(t, err)
+			True(t, authenticated)
+
+			userHash := map[string]string{}
+			NoError(t, parseFile(files[0], userHash))
+			Equal(t, algorithm, pwhash.HashAlgorithm(userHash["bob-md5"]))
+			Equal(t, algorithm, pwhash.HashAlgorithm(userHash["bob-sha"]))
+			Equal(t, "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6", userHash["bob-bcrypt"])
+
+			content, err := ioutil.ReadFile(files[0])
+			NoError(t, err)
+			Contains(t, string(content), "# a comment\n")
+
+			authenticated, err = auth.Authenticate("bob-sha", "secret")
+			NoError(t, err)
+			True(t, authenticated)
+		})
+	}
+}
+
+func TestAuth_UpgradeWeakHashesWrongPassword(t *testing.T) {
+	files := writeTmpfile(testfile)
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+	defer auth.Close()
+	NoError(t, auth.UpgradeWeakHashes(pwhash.AlgorithmBcrypt))
+
+	authenticated, err := auth.Authenticate("bob-sha", "XXXXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	userHash := map[string]string{}
+	NoError(t, parseFile(files[0], userHash))
+	Equal(t, "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", userHash["bob-sha"])
+}
+
+func TestAuth_UpgradeWeakHashesDisabled(t *testing.T) {
+	files := writeTmpfile(testfile)
+
+	auth, err := NewAuth(files)
+	NoError(t, err)
+	defer auth.Close()
+	Error(t, auth.UpgradeWeakHashes("md5"))
+
+	authenticated, err := auth.Authenticate("bob-sha", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	userHash := map[string]string{}
+	NoError(t, parseFile(files[0], userHash))
+	Equal(t, "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", userHash["bob-sha"])
+}
+
 func writeTmpfile(contents ...string) []string {
 	var names []string
 	for _, curContent := range contents {
@@ -1,10 +1,16 @@
 package htpasswd
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/tarent/loginsrv/login"
 	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ProviderName const
@@ -14,7 +20,7 @@ func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     ProviderName,
-			HelpText: "Htpasswd login backend opts: files=/path/to/pwdfile,/path/to/additionalfile",
+			HelpText: "Htpasswd login backend opts: file=/path/to/pwdfile;/path/to/additionalfile[,group=/path/to/groupfile,upgrade_hash=bcrypt|argon2id,verify_workers=..,verify_queue_timeout=..]",
 		},
 		BackendFactory)
 }
@@ -33,7 +39,43 @@ func BackendFactory(config map[string]string) (login.Backend, error) {
 		return nil, errors.New(`missing parameter "file" for htpasswd provider`)
 	}
 
-	return NewBackend(files)
+	var groupFiles []string
+	if f, exist := config["group"]; exist {
+		for _, file := range strings.Split(f, ";") {
+			groupFiles = append(groupFiles, file)
+		}
+	}
+
+	backend, err := NewBackendWithGroups(files, groupFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if algorithm, exist := config["upgrade_hash"]; exist {
+		if err := backend.auth.UpgradeWeakHashes(algorithm); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "upgrade_hash" htpasswd provider: %v`, algorithm, err)
+		}
+	}
+
+	// a dedicated pool for the hash verifications, instead of the one shared by all backends
+	_, hasWorkers := config["verify_workers"]
+	_, hasTimeout := config["verify_queue_timeout"]
+	if hasWorkers || hasTimeout {
+		workers := runtime.NumCPU()
+		queueTimeout := pwhash.DefaultQueueTimeout
+		if value, exist := config["verify_workers"]; exist {
+			if workers, err = strconv.Atoi(value); err != nil || workers < 1 {
+				return nil, fmt.Errorf(`invalid parameter value "%s" in "verify_workers" htpasswd provider: has to be a positive number`, value)
+			}
+		}
+		if value, exist := config["verify_queue_timeout"]; exist {
+			if queueTimeout, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf(`invalid parameter value "%s" in "verify_queue_timeout" htpasswd provider: %v`, value, err)
+			}
+		}
+		backend.auth.SetPool(pwhash.NewPool(workers, queueTimeout))
+	}
+	return backend, nil
 }
 
 // Backend is a htpasswd based authentication backend.
@@ -43,7 +85,12 @@ type Backend struct {
 
 // NewBackend creates a new Backend and verifies the parameters.
 func NewBackend(filenames []string) (*Backend, error) {
-	auth, err := NewAuth(filenames)
+	return NewBackendWithGroups(filenames, nil)
+}
+
+// NewBackendWithGroups creates a new Backend, which fills the groups of the users from the group files.
+func NewBackendWithGroups(filenames, groupFilenames []string) (*Backend, error) {
+	auth, err := NewAuthWithGroups(filenames, groupFilenames)
 	return &Backend{
 		auth,
 	}, err
@@ -51,12 +98,45 @@ func NewBackend(filenames []string) (*Backend, error) {
 
 // Authenticate the user
 func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
-	authenticated, err := sb.auth.Authenticate(username, password)
+	return sb.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext checks the credentials, as soon as a worker for the hash verification is free.
+// If no worker became free within the queue timeout, it returns login.ErrOverloaded.
+func (sb *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username := req.Username
+	authenticated, err := sb.auth.AuthenticateContext(ctx, username, req.Password)
+	if err == pwhash.ErrQueueTimeout {
+		return false, model.UserInfo{}, fmt.Errorf("htpasswd: %w", login.ErrOverloaded)
+	}
 	if authenticated && err == nil {
 		return authenticated, model.UserInfo{
 			Origin: ProviderName,
 			Sub:    username,
+			Groups: sb.auth.Groups(username),
 		}, err
 	}
 	return false, model.UserInfo{}, err
 }
+
+// LookupUser returns the user info of a user of the htpasswd files, without checking the password.
+func (sb *Backend) LookupUser(username string) (bool, model.UserInfo, error) {
+	if !sb.auth.Exists(username) {
+		return false, model.UserInfo{}, nil
+	}
+	return true, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    username,
+		Groups: sb.auth.Groups(username),
+	}, nil
+}
+
+// ChangePassword checks the current password of the user and writes a hash of the new one to the htpasswd file.
+// If no worker for the hash verification became free within the queue timeout, it returns login.ErrOverloaded.
+func (sb *Backend) ChangePassword(username, currentPassword, newPassword string) (bool, error) {
+	changed, err := sb.auth.ChangePassword(username, currentPassword, newPassword)
+	if err == pwhash.ErrQueueTimeout {
+		return false, fmt.Errorf("htpasswd: %w", login.ErrOverloaded)
+	}
+	return changed, err
+}
@@ -1,9 +1,18 @@
 package htpasswd
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/pwhash"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -20,9 +29,7 @@ func TestSetupOneFile(t *testing.T) {
 	})
 
 	NoError(t, err)
-	Equal(t,
-		[]File{File{files[0], modTime(files[0])}},
-		backend.(*Backend).auth.filenames)
+	Equal(t, files, backend.(*Backend).auth.filenames)
 }
 
 func TestSetupTwoFiles(t *testing.T) {
@@ -31,19 +38,93 @@ func TestSetupTwoFiles(t *testing.T) {
 	NotNil(t, p)
 
 	filenames := writeTmpfile(testfile, testfile)
-
-	var morphed []File
-	for _, curFile := range filenames {
-		morphed = append(morphed, File{curFile, modTime(curFile)})
-	}
 	backend, err := p(map[string]string{
 		"file": strings.Join(filenames, ";"),
 	})
 
 	NoError(t, err)
-	Equal(t,
-		morphed,
-		backend.(*Backend).auth.filenames)
+	Equal(t, filenames, backend.(*Backend).auth.filenames)
+}
+
+func TestSetupWithGroupFile(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	files := writeTmpfile(testfile)
+	groupFiles := writeTmpfile("admins: bob-bcrypt", "users: bob-bcrypt")
+	backend, err := p(map[string]string{
+		"file":  files[0],
+		"group": strings.Join(groupFiles, ";"),
+	})
+
+	NoError(t, err)
+	Equal(t, groupFiles, backend.(*Backend).auth.groupFilenames)
+
+	authenticated, userInfo, err := backend.Authenticate("bob-bcrypt", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins", "users"}, userInfo.Groups)
+
+	authenticated, userInfo, err = backend.Authenticate("bob-md5", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Nil(t, userInfo.Groups)
+
+	exist, lookedUp, err := backend.(*Backend).LookupUser("bob-bcrypt")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, "bob-bcrypt", lookedUp.Sub)
+	Equal(t, []string{"admins", "users"}, lookedUp.Groups)
+
+	exist, _, err = backend.(*Backend).LookupUser("alice")
+	NoError(t, err)
+	False(t, exist)
+}
+
+func TestSetupWithHashUpgrade(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	files := writeTmpfile(testfile)
+	backend, err := p(map[string]string{
+		"file":         files[0],
+		"upgrade_hash": "argon2id",
+	})
+	NoError(t, err)
+	Equal(t, "argon2id", backend.(*Backend).auth.upgradeAlgorithm)
+
+	_, err = p(map[string]string{
+		"file":         files[0],
+		"upgrade_hash": "md5",
+	})
+	Error(t, err)
+}
+
+func TestSetupWithVerifyPool(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	files := writeTmpfile(testfile)
+	backend, err := p(map[string]string{"file": files[0]})
+	NoError(t, err)
+	Equal(t, pwhash.DefaultPool, backend.(*Backend).auth.pool)
+
+	backend, err = p(map[string]string{
+		"file":                 files[0],
+		"verify_workers":       "2",
+		"verify_queue_timeout": "100ms",
+	})
+	NoError(t, err)
+	NotEqual(t, pwhash.DefaultPool, backend.(*Backend).auth.pool)
+
+	for _, opts := range []map[string]string{
+		{"file": files[0], "verify_workers": "0"},
+		{"file": files[0], "verify_workers": "many"},
+		{"file": files[0], "verify_queue_timeout": "forever"},
+	} {
+		_, err = p(opts)
+		Error(t, err, "%v", opts)
+	}
 }
 
 func TestSetup_Error(t *testing.T) {
@@ -75,10 +156,76 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	NoError(t, err)
 }
 
-func modTime(f string) time.Time {
-	fileInfo, err := os.Stat(f)
-	if err != nil {
-		panic(err)
+func TestBackend_Overloaded(t *testing.T) {
+	// bcrypt with cost 12 keeps the only worker busy for a while
+	backend, err := NewBackend(writeTmpfile("slow:$2a$12$jcqvjD2MaHmmxwW3DUZfGOWlwnajWRgxFo/F51SXPllaRWQwds/Dy\n" + testfile))
+	NoError(t, err)
+	backend.auth.SetPool(pwhash.NewPool(1, 10*time.Millisecond))
+
+	busyWorkers := expvar.Get("hash_verification").(*expvar.Map).Get("busy_workers").(*expvar.Int)
+	busy := busyWorkers.Value()
+
+	done := make(chan bool)
+	go func() {
+		authenticated, _, _ := backend.Authenticate("slow", "secret")
+		done <- authenticated
+	}()
+	Eventually(t, func() bool { return busyWorkers.Value() > busy }, time.Second, time.Millisecond)
+
+	authenticated, _, err := backend.AuthenticateContext(context.Background(), login.LoginRequest{Username: "bob-bcrypt", Password: "secret"})
+	False(t, authenticated)
+	True(t, errors.Is(err, login.ErrOverloaded))
+
+	// the password change needs a worker, too
+	changed, err := backend.ChangePassword("bob-bcrypt", "secret", "n3w-secret")
+	False(t, changed)
+	True(t, errors.Is(err, login.ErrOverloaded))
+
+	True(t, <-done)
+	authenticated, _, err = backend.Authenticate("bob-bcrypt", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+// BenchmarkLogin measures the login path with parallel requests,
+// once with a single worker for the hash verification and once with one per CPU.
+func BenchmarkLogin(b *testing.B) {
+	logging.Logger.Out = ioutil.Discard
+	defer func() { logging.Logger.Out = os.Stderr }()
+
+	files := writeTmpfile(testfile)
+	workerCounts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			config := login.DefaultConfig()
+			config.Backends = login.Options{
+				ProviderName: {
+					"file":                 files[0],
+					"verify_workers":       fmt.Sprint(workers),
+					"verify_queue_timeout": "1m",
+				},
+			}
+			h, err := login.NewHandler(config)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					r := httptest.NewRequest("POST", "/login", strings.NewReader(`{"username": "bob-bcrypt", "password": "secret"}`))
+					r.Header.Set("Content-Type", "application/json")
+					r.Header.Set("Accept", "application/jwt")
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, r)
+					if w.Code != 200 {
+						b.Fatalf("unexpected status %v: %v", w.Code, w.Body.String())
+					}
+				}
+			})
+		})
 	}
-	return fileInfo.ModTime()
 }
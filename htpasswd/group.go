@@ -0,0 +1,50 @@
+package htpasswd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseGroupFile reads an Apache style group file with lines in the form
+// 'groupname: user1 user2' and adds the groups to the users.
+// A group may be spread over multiple lines.
+func parseGroupFile(filename string, userGroups map[string][]string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		group := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || group == "" {
+			return fmt.Errorf("group file in wrong format (%v, line %v)", filename, lineNumber)
+		}
+		for _, user := range strings.Fields(parts[1]) {
+			if !contains(userGroups[user], group) {
+				userGroups[user] = append(userGroups[user], group)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package htpasswd
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+const testGroupFile = `admins: bob alice
+# a comment
+
+users:bob  carol
+admins: dave bob
+`
+
+func TestParseGroupFile(t *testing.T) {
+	userGroups := map[string][]string{}
+	NoError(t, parseGroupFile(writeTmpfile(testGroupFile)[0], userGroups))
+
+	Equal(t, map[string][]string{
+		"bob":   {"admins", "users"},
+		"alice": {"admins"},
+		"carol": {"users"},
+		"dave":  {"admins"},
+	}, userGroups)
+}
+
+func TestParseGroupFile_Errors(t *testing.T) {
+	Error(t, parseGroupFile("/tmp/foo/bar/nothing", map[string][]string{}))
+	Error(t, parseGroupFile(writeTmpfile("admins bob")[0], map[string][]string{}))
+	Error(t, parseGroupFile(writeTmpfile(": bob")[0], map[string][]string{}))
+}
+
+func TestAuth_Groups(t *testing.T) {
+	auth, err := NewAuthWithGroups(writeTmpfile(testfile), writeTmpfile(testGroupFile, "ops: bob"))
+	NoError(t, err)
+	defer auth.Close()
+
+	Equal(t, []string{"admins", "users", "ops"}, auth.Groups("bob"))
+	Equal(t, []string{"admins"}, auth.Groups("alice"))
+	Nil(t, auth.Groups("unknown"))
+
+	_, err = NewAuthWithGroups(writeTmpfile(testfile), []string{"/tmp/foo/bar/nothing"})
+	Error(t, err)
+}
+
+func TestAuth_ReloadGroupFile(t *testing.T) {
+	groupFiles := writeTmpfile("admins: bob")
+
+	auth, err := NewAuthWithGroups(writeTmpfile(testfile), groupFiles)
+	NoError(t, err)
+	defer auth.Close()
+	Equal(t, []string{"admins"}, auth.Groups("bob"))
+
+	err = ioutil.WriteFile(groupFiles[0], []byte("users: bob"), 0644)
+	NoError(t, err)
+
+	Eventually(t, func() bool {
+		groups := auth.Groups("bob")
+		return len(groups) == 1 && groups[0] == "users"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an invalid group file keeps the previous groups
+	err = ioutil.WriteFile(groupFiles[0], []byte("users bob"), 0644)
+	NoError(t, err)
+	time.Sleep(5 * reloadDelay)
+	Equal(t, []string{"users"}, auth.Groups("bob"))
+}
@@ -1,57 +1,282 @@
 package httpupstream
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"text/template"
 	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/login"
 )
 
+// maxResponseSize limits the size of the upstream response, which is read for the mapping
+const maxResponseSize = 1 << 20
+
+// Config for the httpupstream authenticater
+type Config struct {
+	Upstream   *url.URL
+	SkipVerify bool
+	Timeout    time.Duration
+
+	// Method of the upstream request, GET by default
+	Method string
+
+	// BodyTemplate is a text/template for the request body with the fields .Username and .Password.
+	// The function json can be used to quote the values, e.g. {"user": {{json .Username}}}
+	BodyTemplate string
+
+	// ContentType of the request body, application/json by default
+	ContentType string
+
+	// UsernameHeader and PasswordHeader are the names of the request headers for the credentials.
+	// If neither a header nor a body template is configured, the credentials are sent by HTTP Basic authentication.
+	UsernameHeader string
+	PasswordHeader string
+
+	// SuccessStatus are the status codes of a successful authentication, 200 by default
+	SuccessStatus []int
+
+	// SuccessField is the path of a field in the JSON response, which has to be true
+	// or equal to SuccessValue for a successful authentication (optional)
+	SuccessField string
+	SuccessValue string
+
+	// The paths of the fields in the JSON response, which are mapped to the user info.
+	// Nested fields are separated by '.', e.g. user.profile.email
+	NameField   string
+	EmailField  string
+	GroupsField string
+
+	// Claims maps the claim names of the token to the paths of the fields in the JSON response
+	Claims map[string]string
+}
+
+// Result of a successful upstream authentication
+type Result struct {
+	Name   string
+	Email  string
+	Groups []string
+	Claims map[string]interface{}
+}
+
 // Auth is the httpupstream authenticater
 type Auth struct {
-	upstream   *url.URL
-	skipverify bool
-	timeout    time.Duration
+	config       Config
+	bodyTemplate *template.Template
+	client       *http.Client
 }
 
 // NewAuth creates an httpupstream authenticater
-func NewAuth(upstream *url.URL, timeout time.Duration, skipverify bool) (*Auth, error) {
+func NewAuth(config Config) (*Auth, error) {
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	if len(config.SuccessStatus) == 0 {
+		config.SuccessStatus = []int{http.StatusOK}
+	}
+
 	a := &Auth{
-		upstream:   upstream,
-		skipverify: skipverify,
-		timeout:    timeout,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}
+
+	if config.BodyTemplate != "" {
+		if a.config.ContentType == "" {
+			a.config.ContentType = "application/json"
+		}
+		t, err := template.New("body").Funcs(template.FuncMap{"json": jsonQuote}).Parse(config.BodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %v", err)
+		}
+		a.bodyTemplate = t
 	}
 
+	if config.Upstream.Scheme == "https" && config.SkipVerify {
+		a.client.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
 	return a, nil
 }
 
 // Authenticate the user
-func (a *Auth) Authenticate(username, password string) (bool, error) {
-	c := &http.Client{
-		Timeout: a.timeout,
+func (a *Auth) Authenticate(username, password string) (bool, Result, error) {
+	return a.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext authenticates the user of the login by a request, which is cancelled with the context.
+// The correlation id of the login is forwarded to the upstream.
+func (a *Auth) AuthenticateContext(ctx context.Context, loginRequest login.LoginRequest) (bool, Result, error) {
+	req, err := a.newRequest(ctx, loginRequest)
+	if err != nil {
+		return false, Result{}, err
 	}
 
-	if a.upstream.Scheme == "https" && a.skipverify {
-		c.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return false, Result{}, err
+	}
+	defer func() {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+	}()
+
+	if !a.isSuccessStatus(resp.StatusCode) {
+		return false, Result{}, nil
+	}
+
+	if !a.needsResponseBody() {
+		return true, Result{}, nil
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&body); err != nil {
+		return false, Result{}, fmt.Errorf("can not parse response of upstream: %v", err)
+	}
+
+	if a.config.SuccessField != "" && !a.isSuccessValue(lookup(body, a.config.SuccessField)) {
+		return false, Result{}, nil
+	}
+
+	return true, a.mapResult(body), nil
+}
+
+func (a *Auth) newRequest(ctx context.Context, loginRequest login.LoginRequest) (*http.Request, error) {
+	username, password := loginRequest.Username, loginRequest.Password
+	var body io.Reader
+	if a.bodyTemplate != nil {
+		buf := &bytes.Buffer{}
+		err := a.bodyTemplate.Execute(buf, struct{ Username, Password string }{username, password})
+		if err != nil {
+			return nil, fmt.Errorf("can not render body template: %v", err)
 		}
+		body = buf
 	}
 
-	req, err := http.NewRequest("GET", a.upstream.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, a.config.Method, a.config.Upstream.String(), body)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", a.config.ContentType)
+	}
+	if a.config.UsernameHeader != "" {
+		req.Header.Set(a.config.UsernameHeader, username)
+	}
+	if a.config.PasswordHeader != "" {
+		req.Header.Set(a.config.PasswordHeader, password)
 	}
+	if a.bodyTemplate == nil && a.config.UsernameHeader == "" && a.config.PasswordHeader == "" {
+		req.SetBasicAuth(username, password)
+	}
+	if loginRequest.CorrelationID != "" {
+		req.Header.Set(logging.CorrelationIdHeader, loginRequest.CorrelationID)
+	}
+	return req, nil
+}
 
-	req.SetBasicAuth(username, password)
+func (a *Auth) isSuccessStatus(status int) bool {
+	for _, s := range a.config.SuccessStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
 
-	resp, err := c.Do(req)
-	if err != nil {
-		return false, err
+func (a *Auth) isSuccessValue(value interface{}) bool {
+	if a.config.SuccessValue == "" {
+		b, ok := value.(bool)
+		return ok && b
+	}
+	return value != nil && fmt.Sprint(value) == a.config.SuccessValue
+}
+
+func (a *Auth) needsResponseBody() bool {
+	return a.config.SuccessField != "" ||
+		a.config.NameField != "" ||
+		a.config.EmailField != "" ||
+		a.config.GroupsField != "" ||
+		len(a.config.Claims) > 0
+}
+
+func (a *Auth) mapResult(body interface{}) Result {
+	r := Result{}
+	if a.config.NameField != "" {
+		r.Name = asString(lookup(body, a.config.NameField))
+	}
+	if a.config.EmailField != "" {
+		r.Email = asString(lookup(body, a.config.EmailField))
+	}
+	if a.config.GroupsField != "" {
+		r.Groups = asStringList(lookup(body, a.config.GroupsField))
+	}
+	for claim, path := range a.config.Claims {
+		if v := lookup(body, path); v != nil {
+			if r.Claims == nil {
+				r.Claims = map[string]interface{}{}
+			}
+			r.Claims[claim] = v
+		}
+	}
+	return r
+}
+
+// lookup returns the value of the field with the '.' separated path or nil, if it does not exist.
+func lookup(value interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
 	}
+	return value
+}
 
-	if resp.StatusCode != 200 {
-		return false, nil
+func asString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
 	}
+	return fmt.Sprint(value)
+}
+
+// asStringList supports a JSON array as well as a comma separated string.
+func asStringList(value interface{}) []string {
+	var list []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, e := range v {
+			if s := asString(e); s != "" {
+				list = append(list, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
 
-	return true, nil
+func jsonQuote(s string) (string, error) {
+	b, err := json.Marshal(s)
+	return string(b), err
 }
@@ -13,10 +13,10 @@ func TestAuth_UnknownUser(t *testing.T) {
 	defer ts.Close()
 	u, _ := url.Parse(ts.URL)
 
-	auth, err := NewAuth(u, time.Second, false)
+	auth, err := NewAuth(Config{Upstream: u, Timeout: time.Second})
 	NoError(t, err)
 
-	authenticated, err := auth.Authenticate("unknown", "secret")
+	authenticated, _, err := auth.Authenticate("unknown", "secret")
 	NoError(t, err)
 	False(t, authenticated)
 }
@@ -26,10 +26,10 @@ func TestAuth_KnownUser(t *testing.T) {
 	defer ts.Close()
 	u, _ := url.Parse(ts.URL)
 
-	auth, err := NewAuth(u, time.Second, false)
+	auth, err := NewAuth(Config{Upstream: u, Timeout: time.Second})
 	NoError(t, err)
 
-	authenticated, err := auth.Authenticate("bob-bcrypt", "s3krud")
+	authenticated, _, err := auth.Authenticate("bob-bcrypt", "s3krud")
 	NoError(t, err)
 	False(t, authenticated)
 }
@@ -39,10 +39,10 @@ func TestAuth_ValidCredentials(t *testing.T) {
 	defer ts.Close()
 	u, _ := url.Parse(ts.URL)
 
-	auth, err := NewAuth(u, time.Second, false)
+	auth, err := NewAuth(Config{Upstream: u, Timeout: time.Second})
 	NoError(t, err)
 
-	authenticated, err := auth.Authenticate("bob-bcrypt", "secret")
+	authenticated, _, err := auth.Authenticate("bob-bcrypt", "secret")
 	NoError(t, err)
 	True(t, authenticated)
 }
@@ -50,19 +50,19 @@ func TestAuth_ValidCredentials(t *testing.T) {
 func TestAuth_InvalidUrl(t *testing.T) {
 	invalidUrl := &url.URL{Scheme: "\\\\"}
 
-	auth, err := NewAuth(invalidUrl, time.Second, false)
+	auth, err := NewAuth(Config{Upstream: invalidUrl, Timeout: time.Second})
 	NoError(t, err)
 
-	_, err = auth.Authenticate("foo", "bar")
+	_, _, err = auth.Authenticate("foo", "bar")
 	Error(t, err)
 }
 
 func TestAuth_InvalidHost(t *testing.T) {
 	invalidServer, _ := url.Parse("http://0.0.0.0.0")
 
-	auth, err := NewAuth(invalidServer, time.Second, false)
+	auth, err := NewAuth(Config{Upstream: invalidServer, Timeout: time.Second})
 	NoError(t, err)
 
-	_, err = auth.Authenticate("foo", "bar")
+	_, _, err = auth.Authenticate("foo", "bar")
 	Error(t, err)
 }
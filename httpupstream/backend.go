@@ -1,10 +1,13 @@
 package httpupstream
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tarent/loginsrv/login"
@@ -20,7 +23,7 @@ func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     ProviderName,
-			HelpText: "Httpupstream login backend opts: upstream=...,skipverify=...,timeout=...",
+			HelpText: "Httpupstream login backend opts: upstream=...,skipverify=...,timeout=...[,method=..,body_template=..|body_template_file=..,content_type=..,username_header=..,password_header=..,success_status=..,success_field=..,success_value=..,name_field=..,email_field=..,groups_field=..,claims=..]",
 		},
 		BackendFactory)
 }
@@ -28,36 +31,90 @@ func init() {
 // BackendFactory creates a httpupstream backend
 func BackendFactory(config map[string]string) (login.Backend, error) {
 	us, ue := config["upstream"]
-	ts, te := config["timeout"]
-	vs, ve := config["skipverify"]
-
 	if !ue {
 		return nil, errors.New(`missing parameter "upstream" for httpupstream provider`)
 	}
-
 	u, err := url.Parse(us)
 	if err != nil {
 		return nil, fmt.Errorf(`invalid parameter value "%s" in "upstream" httpupstream provider: %v`, us, err)
 	}
 
-	v := false
-	t := defaultTimeout
+	cfg := Config{
+		Upstream: u,
+		Timeout:  defaultTimeout,
+	}
 
-	if te {
-		t, err = time.ParseDuration(ts)
+	for key, value := range config {
+		switch key {
+		case "upstream":
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		case "skipverify":
+			cfg.SkipVerify, err = strconv.ParseBool(value)
+		case "method":
+			cfg.Method = strings.ToUpper(value)
+		case "body_template":
+			// the options are separated by ',', so the template uses ';' instead
+			cfg.BodyTemplate = strings.Replace(value, ";", ",", -1)
+		case "body_template_file":
+			var b []byte
+			b, err = ioutil.ReadFile(value)
+			cfg.BodyTemplate = string(b)
+		case "content_type":
+			cfg.ContentType = value
+		case "username_header":
+			cfg.UsernameHeader = value
+		case "password_header":
+			cfg.PasswordHeader = value
+		case "success_status":
+			cfg.SuccessStatus, err = parseStatusList(value)
+		case "success_field":
+			cfg.SuccessField = value
+		case "success_value":
+			cfg.SuccessValue = value
+		case "name_field":
+			cfg.NameField = value
+		case "email_field":
+			cfg.EmailField = value
+		case "groups_field":
+			cfg.GroupsField = value
+		case "claims":
+			cfg.Claims, err = parseClaimsMapping(value)
+		default:
+			return nil, fmt.Errorf("unknown parameter %q for httpupstream provider", key)
+		}
 		if err != nil {
-			return nil, fmt.Errorf(`invalid parameter value "%s" in "timeout" httpupstream provider: %v`, ts, err)
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" httpupstream provider: %v`, value, key, err)
 		}
 	}
 
-	if ve {
-		v, err = strconv.ParseBool(vs)
+	return NewBackend(cfg)
+}
+
+// parseStatusList parses a list of status codes separated by ';', e.g. 200;204
+func parseStatusList(value string) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(value, ";") {
+		status, err := strconv.Atoi(strings.TrimSpace(s))
 		if err != nil {
-			return nil, fmt.Errorf(`invalid parameter value "%s" in "skipverify" httpupstream provider: %v`, ts, err)
+			return nil, err
 		}
+		list = append(list, status)
 	}
+	return list, nil
+}
 
-	return NewBackend(u, t, v)
+// parseClaimsMapping parses a list of claim:field pairs separated by ';', e.g. department:user.department;level:level
+func parseClaimsMapping(value string) (map[string]string, error) {
+	claims := map[string]string{}
+	for _, pair := range strings.Split(value, ";") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("claim mapping %q is not in the format claim:field", pair)
+		}
+		claims[parts[0]] = parts[1]
+	}
+	return claims, nil
 }
 
 // Backend is a httpupstream based authentication backend.
@@ -66,8 +123,8 @@ type Backend struct {
 }
 
 // NewBackend creates a new Backend and verifies the parameters.
-func NewBackend(upstream *url.URL, timeout time.Duration, skipverify bool) (*Backend, error) {
-	auth, err := NewAuth(upstream, timeout, skipverify)
+func NewBackend(config Config) (*Backend, error) {
+	auth, err := NewAuth(config)
 	return &Backend{
 		auth,
 	}, err
@@ -75,11 +132,21 @@ func NewBackend(upstream *url.URL, timeout time.Duration, skipverify bool) (*Bac
 
 // Authenticate the user
 func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
-	authenticated, err := sb.auth.Authenticate(username, password)
+	return sb.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext authenticates the user at the upstream. The request is cancelled with the context.
+func (sb *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username := req.Username
+	authenticated, result, err := sb.auth.AuthenticateContext(ctx, req)
 	if authenticated && err == nil {
 		return authenticated, model.UserInfo{
 			Origin: ProviderName,
 			Sub:    username,
+			Name:   result.Name,
+			Email:  result.Email,
+			Groups: result.Groups,
+			Claims: result.Claims,
 		}, err
 	}
 	return false, model.UserInfo{}, err
@@ -1,6 +1,9 @@
 package httpupstream
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -9,6 +12,7 @@ import (
 
 	. "github.com/stretchr/testify/assert"
 	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
 )
 
 func TestSetup(t *testing.T) {
@@ -25,13 +29,13 @@ func TestSetup(t *testing.T) {
 	NoError(t, err)
 	Equal(t,
 		"https://google.com",
-		backend.(*Backend).auth.upstream.String())
+		backend.(*Backend).auth.config.Upstream.String())
 	Equal(t,
 		true,
-		backend.(*Backend).auth.skipverify)
+		backend.(*Backend).auth.config.SkipVerify)
 	Equal(t,
 		time.Second*20,
-		backend.(*Backend).auth.timeout)
+		backend.(*Backend).auth.config.Timeout)
 }
 
 func TestSetup_Default(t *testing.T) {
@@ -46,13 +50,13 @@ func TestSetup_Default(t *testing.T) {
 	NoError(t, err)
 	Equal(t,
 		"https://google.com",
-		backend.(*Backend).auth.upstream.String())
+		backend.(*Backend).auth.config.Upstream.String())
 	Equal(t,
 		false,
-		backend.(*Backend).auth.skipverify)
+		backend.(*Backend).auth.config.SkipVerify)
 	Equal(t,
 		defaultTimeout,
-		backend.(*Backend).auth.timeout)
+		backend.(*Backend).auth.config.Timeout)
 }
 
 func TestSetup_Error(t *testing.T) {
@@ -71,6 +75,157 @@ func TestSetup_Error(t *testing.T) {
 
 	_, err = p(map[string]string{"upstream": "http://example.com", "skipverify": "some-string"})
 	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "success_status": "ok"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "claims": "department"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "body_template": "{{.Username"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "body_template_file": "/tmp/foo/bar/nothing"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "foo": "bar"})
+	Error(t, err)
+}
+
+func TestSetup_JSONOptions(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	backend, err := p(map[string]string{
+		"upstream":        "https://example.com/auth",
+		"method":          "post",
+		"body_template":   `{"user": {{json .Username}}; "pass": {{json .Password}}}`,
+		"username_header": "X-User",
+		"success_status":  "200;201",
+		"success_field":   "status",
+		"success_value":   "ok",
+		"name_field":      "user.name",
+		"email_field":     "user.email",
+		"groups_field":    "user.groups",
+		"claims":          "department:user.department;level:level",
+	})
+	NoError(t, err)
+
+	cfg := backend.(*Backend).auth.config
+	Equal(t, "POST", cfg.Method)
+	Equal(t, `{"user": {{json .Username}}, "pass": {{json .Password}}}`, cfg.BodyTemplate)
+	Equal(t, "application/json", cfg.ContentType)
+	Equal(t, "X-User", cfg.UsernameHeader)
+	Equal(t, []int{200, 201}, cfg.SuccessStatus)
+	Equal(t, "status", cfg.SuccessField)
+	Equal(t, "ok", cfg.SuccessValue)
+	Equal(t, "user.name", cfg.NameField)
+	Equal(t, "user.email", cfg.EmailField)
+	Equal(t, "user.groups", cfg.GroupsField)
+	Equal(t, map[string]string{"department": "user.department", "level": "level"}, cfg.Claims)
+}
+
+func TestBackend_Authenticate_JSON(t *testing.T) {
+	ts := newJSONTestServer()
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	backend, err := NewBackend(Config{
+		Upstream:      u,
+		Timeout:       time.Second,
+		Method:        "POST",
+		BodyTemplate:  `{"user": {{json .Username}}, "pass": {{json .Password}}}`,
+		SuccessStatus: []int{200, 201},
+		SuccessField:  "status",
+		SuccessValue:  "ok",
+		NameField:     "user.name",
+		EmailField:    "user.email",
+		GroupsField:   "user.groups",
+		Claims:        map[string]string{"department": "user.department", "level": "level", "missing": "foo.bar"},
+	})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", `sec"ret`)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t,
+		model.UserInfo{
+			Origin: "httpupstream",
+			Sub:    "bob",
+			Name:   "Bob Builder",
+			Email:  "bob@example.com",
+			Groups: []string{"builders", "admins"},
+			Claims: map[string]interface{}{"department": "construction", "level": float64(3)},
+		},
+		userInfo)
+
+	authenticated, _, err = backend.Authenticate("bob", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// status code 200, but status field not ok
+	authenticated, _, err = backend.Authenticate("locked", `sec"ret`)
+	NoError(t, err)
+	False(t, authenticated)
+
+	// response is no json
+	authenticated, _, err = backend.Authenticate("broken", `sec"ret`)
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_Headers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, basicAuth := r.BasicAuth()
+		if basicAuth || r.Header.Get("X-User") != "bob" || r.Header.Get("X-Password") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"success": true, "groups": "builders, admins"}`))
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	backend, err := NewBackend(Config{
+		Upstream:       u,
+		Timeout:        time.Second,
+		UsernameHeader: "X-User",
+		PasswordHeader: "X-Password",
+		SuccessField:   "success",
+		GroupsField:    "groups",
+	})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"builders", "admins"}, userInfo.Groups)
+
+	authenticated, _, err = backend.Authenticate("bob", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_Authenticate_CorrelationID(t *testing.T) {
+	var correlationID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationID = r.Header.Get("X-Correlation-Id")
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	backend, err := NewBackend(Config{Upstream: u, Timeout: time.Second})
+	NoError(t, err)
+
+	authenticated, _, err := backend.AuthenticateContext(context.Background(),
+		login.LoginRequest{Username: "bob", Password: "secret", CorrelationID: "abc123"})
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "abc123", correlationID)
+
+	// no empty header without id
+	_, _, err = backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	Equal(t, "", correlationID)
 }
 
 func TestSimpleBackend_Authenticate(t *testing.T) {
@@ -78,7 +233,7 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	defer ts.Close()
 	u, _ := url.Parse(ts.URL)
 
-	backend, err := NewBackend(u, time.Second, false)
+	backend, err := NewBackend(Config{Upstream: u, Timeout: time.Second})
 	NoError(t, err)
 
 	authenticated, userInfo, err := backend.Authenticate("bob-bcrypt", "secret")
@@ -97,6 +252,26 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	NoError(t, err)
 }
 
+func TestBackend_AuthenticateContext(t *testing.T) {
+	// the upstream does not answer before the end of the test
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	u, _ := url.Parse(ts.URL)
+
+	backend, err := NewBackend(Config{Upstream: u, Timeout: 10 * time.Second})
+	NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	authenticated, _, err := backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob", Password: "secret"})
+	False(t, authenticated)
+	True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
 func newTestServer() *httptest.Server {
 	passwordCheck := func(w http.ResponseWriter, r *http.Request) {
 		u, p, k := r.BasicAuth()
@@ -112,3 +287,38 @@ func newTestServer() *httptest.Server {
 
 	return httptest.NewServer(http.HandlerFunc(passwordCheck))
 }
+
+func newJSONTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials := struct{ User, Pass string }{}
+		if r.Method != http.MethodPost ||
+			r.Header.Get("Content-Type") != "application/json" ||
+			json.NewDecoder(r.Body).Decode(&credentials) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if credentials.Pass != `sec"ret` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch credentials.User {
+		case "bob":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{
+				"status": "ok",
+				"level": 3,
+				"user": {
+					"name": "Bob Builder",
+					"email": "bob@example.com",
+					"groups": ["builders", "admins"],
+					"department": "construction"
+				}
+			}`))
+		case "locked":
+			w.Write([]byte(`{"status": "locked"}`))
+		default:
+			w.Write([]byte(`no json`))
+		}
+	}))
+}
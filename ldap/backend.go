@@ -0,0 +1,360 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "ldap"
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "LDAP login backend opts: url=ldap://..,base_dn=..|user_dn=..[,bind_dn=..,bind_password=..,starttls=..,ca_file=..,group_base_dn=..]",
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates an ldap backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg, err := ConfigFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewBackend(cfg)
+}
+
+// Backend is an ldap based authentication backend.
+// It supports search-then-bind and the direct bind of the user.
+type Backend struct {
+	config    Config
+	tlsConfig *tls.Config
+}
+
+// NewBackend creates a new Backend and verifies the parameters.
+func NewBackend(cfg Config) (*Backend, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{
+		config:    cfg,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+// Authenticate the user
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext authenticates the user like Authenticate.
+// The ldap library has no context support, so the connection is closed, when the context is cancelled.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username, password := req.Username, req.Password
+	// an empty password would result in an unauthenticated bind,
+	// which most servers accept as successful
+	if username == "" || password == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	conn, err := b.connect()
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	authenticated, userInfo, err := b.authenticate(conn, username, password)
+	if ctx.Err() != nil {
+		return false, model.UserInfo{}, ctx.Err()
+	}
+	return authenticated, userInfo, err
+}
+
+func (b *Backend) authenticate(conn *goldap.Conn, username, password string) (bool, model.UserInfo, error) {
+	var entry *goldap.Entry
+	var err error
+	if b.config.UserDN != "" {
+		entry, err = b.directBind(conn, username, password)
+	} else {
+		entry, err = b.searchAndBind(conn, username, password)
+	}
+	if err != nil || entry == nil {
+		return false, model.UserInfo{}, err
+	}
+
+	groups, err := b.groups(conn, entry)
+	if err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	return true, model.UserInfo{
+		Origin: ProviderName,
+		Sub:    username,
+		Name:   entry.GetAttributeValue(b.config.AttrName),
+		Email:  entry.GetAttributeValue(b.config.AttrEmail),
+		Groups: groups,
+	}, nil
+}
+
+func (b *Backend) connect() (*goldap.Conn, error) {
+	conn, err := goldap.DialURL(b.config.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: b.config.Timeout}),
+		goldap.DialWithTLSConfig(b.tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(b.config.Timeout)
+
+	if b.config.StartTLS {
+		if err := conn.StartTLS(b.tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// directBind binds with the DN built from the user_dn template and reads the user entry afterwards.
+// It returns a nil entry, if the credentials are wrong.
+func (b *Backend) directBind(conn *goldap.Conn, username, password string) (*goldap.Entry, error) {
+	userDN := fmt.Sprintf(b.config.UserDN, escapeDN(username))
+	if ok, err := bindUser(conn, userDN, password); !ok || err != nil {
+		return nil, err
+	}
+
+	// the user_dn template may be no DN, e.g. user@domain for active directory,
+	// so we have to search the entry in this case
+	var req *goldap.SearchRequest
+	if b.config.BaseDN != "" {
+		req = b.searchRequest(b.config.BaseDN, goldap.ScopeWholeSubtree, b.userFilter(username))
+	} else {
+		req = b.searchRequest(userDN, goldap.ScopeBaseObject, "(objectClass=*)")
+	}
+	entry, err := searchOne(conn, req)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		// the user is authenticated, even if we are not allowed to read the entry
+		return goldap.NewEntry(userDN, nil), nil
+	}
+	return entry, nil
+}
+
+// searchAndBind searches the user entry with the service account and binds with its DN afterwards.
+// It returns a nil entry, if the user does not exist or the credentials are wrong.
+func (b *Backend) searchAndBind(conn *goldap.Conn, username, password string) (*goldap.Entry, error) {
+	if err := b.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+
+	entry, err := searchOne(conn, b.searchRequest(b.config.BaseDN, goldap.ScopeWholeSubtree, b.userFilter(username)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if ok, err := bindUser(conn, entry.DN, password); !ok || err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// groups returns the group names from the groups attribute of the entry
+// and from the group search, if a group_base_dn is configured.
+func (b *Backend) groups(conn *goldap.Conn, entry *goldap.Entry) ([]string, error) {
+	var groups []string
+	if b.config.AttrGroups != "" {
+		for _, groupDN := range entry.GetAttributeValues(b.config.AttrGroups) {
+			groups = appendUnique(groups, groupName(groupDN))
+		}
+	}
+
+	if b.config.GroupBaseDN == "" {
+		return groups, nil
+	}
+
+	// search the groups with the service account, if there is one
+	if b.config.BindDN != "" {
+		if err := b.bindServiceAccount(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := strings.Replace(b.config.GroupFilter, "%s", goldap.EscapeFilter(entry.DN), -1)
+	req := goldap.NewSearchRequest(b.config.GroupBaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+		0, 0, false, filter, []string{b.config.AttrGroupName}, nil)
+	res, err := conn.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("ldap group search failed: %v", err)
+	}
+	for _, group := range res.Entries {
+		if name := group.GetAttributeValue(b.config.AttrGroupName); name != "" {
+			groups = appendUnique(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+func (b *Backend) bindServiceAccount(conn *goldap.Conn) error {
+	if b.config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+	if err := conn.Bind(b.config.BindDN, b.config.BindPassword); err != nil {
+		return fmt.Errorf("ldap bind with the service account failed: %v", err)
+	}
+	return nil
+}
+
+func (b *Backend) userFilter(username string) string {
+	return strings.Replace(b.config.UserFilter, "%s", goldap.EscapeFilter(username), -1)
+}
+
+func (b *Backend) searchRequest(baseDN string, scope int, filter string) *goldap.SearchRequest {
+	attributes := []string{}
+	for _, attr := range []string{b.config.AttrName, b.config.AttrEmail, b.config.AttrGroups} {
+		if attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	return goldap.NewSearchRequest(baseDN, scope, goldap.NeverDerefAliases,
+		2, 0, false, filter, attributes, nil)
+}
+
+// bindUser returns false, if the server rejected the credentials.
+// It returns a *login.AuthError, if the server reports the reason, e.g. a locked account.
+func bindUser(conn *goldap.Conn, dn, password string) (bool, error) {
+	res, err := conn.SimpleBind(&goldap.SimpleBindRequest{
+		Username: dn,
+		Password: password,
+		Controls: []goldap.Control{goldap.NewControlBeheraPasswordPolicy()},
+	})
+	if authErr := bindAuthError(res, err); authErr != nil {
+		return false, authErr
+	}
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// adDataCode matches the reason in the diagnostic message of an active directory, e.g. "data 775"
+var adDataCode = regexp.MustCompile(`data ([0-9a-fA-F]{3,4})`)
+
+// bindAuthError returns the reason of a rejected bind from the password policy control (draft-behera-ldap-password-policy)
+// or the diagnostic message of an active directory. It returns nil, if no known reason was reported.
+func bindAuthError(res *goldap.SimpleBindResult, err error) *login.AuthError {
+	if res != nil {
+		for _, control := range res.Controls {
+			policy, ok := control.(*goldap.ControlBeheraPasswordPolicy)
+			if !ok {
+				continue
+			}
+			switch policy.Error {
+			case 0:
+				return login.NewAuthError(login.AuthErrorPasswordExpired, policy.ErrorString)
+			case 1:
+				return login.NewAuthError(login.AuthErrorAccountLocked, policy.ErrorString)
+			case 2:
+				return login.NewAuthError(login.AuthErrorMustChangePassword, policy.ErrorString)
+			}
+		}
+	}
+
+	ldapErr, ok := err.(*goldap.Error)
+	if !ok || ldapErr.ResultCode != goldap.LDAPResultInvalidCredentials || ldapErr.Err == nil {
+		return nil
+	}
+	diagnostic := ldapErr.Err.Error()
+	match := adDataCode.FindStringSubmatch(diagnostic)
+	if match == nil {
+		return nil
+	}
+	switch strings.ToLower(match[1]) {
+	case "775", "533", "701":
+		// locked, disabled, expired account
+		return login.NewAuthError(login.AuthErrorAccountLocked, diagnostic)
+	case "532":
+		return login.NewAuthError(login.AuthErrorPasswordExpired, diagnostic)
+	case "773":
+		return login.NewAuthError(login.AuthErrorMustChangePassword, diagnostic)
+	}
+	return nil
+}
+
+// searchOne returns the only entry of the search or nil, if nothing was found.
+func searchOne(conn *goldap.Conn, req *goldap.SearchRequest) (*goldap.Entry, error) {
+	res, err := conn.Search(req)
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil && !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap user search failed: %v", err)
+	}
+	if res == nil || len(res.Entries) == 0 {
+		return nil, nil
+	}
+	if len(res.Entries) > 1 {
+		return nil, errors.New("ldap user search returned more than one entry")
+	}
+	return res.Entries[0], nil
+}
+
+// groupName returns the value of the first RDN, if the group is a DN (e.g. from memberOf)
+func groupName(group string) string {
+	dn, err := goldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return group
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// escapeDN escapes the special characters of a DN attribute value (RFC 4514)
+func escapeDN(value string) string {
+	var b strings.Builder
+	runes := []rune(value)
+	for i, c := range runes {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(runes)-1 && c == ' ':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,285 @@
+package ldap
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"url":           "ldap://localhost:389",
+		"base_dn":       "ou=people;dc=example;dc=com",
+		"bind_dn":       "cn=admin;dc=example;dc=com",
+		"bind_password": "adminsecret",
+		"timeout":       "5s",
+	})
+	NoError(t, err)
+	Equal(t, "ou=people,dc=example,dc=com", backend.(*Backend).config.BaseDN)
+	Equal(t, "cn=admin,dc=example,dc=com", backend.(*Backend).config.BindDN)
+	Equal(t, "(uid=%s)", backend.(*Backend).config.UserFilter)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"url": "http://localhost", "base_dn": "dc=example"},
+		{"url": "ldap://localhost"},
+		{"url": "ldap://localhost", "base_dn": "dc=example", "foo": "bar"},
+		{"url": "ldap://localhost", "base_dn": "dc=example", "starttls": "maybe"},
+		{"url": "ldaps://localhost", "base_dn": "dc=example", "starttls": "true"},
+		{"url": "ldap://localhost", "user_dn": "uid=bob;dc=example"},
+		{"url": "ldap://localhost", "base_dn": "dc=example", "ca_file": "/tmp/foo/bar/nothing"},
+	} {
+		_, err := p(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_SearchAndBind(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.BindDN = "cn=admin,dc=example,dc=com"
+	cfg.BindPassword = "adminsecret"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t,
+		model.UserInfo{
+			Origin: "ldap",
+			Sub:    "bob",
+			Name:   "Bob Builder",
+			Email:  "bob@example.com",
+			Groups: []string{"builders", "admins"},
+		},
+		userInfo)
+	Equal(t, []string{"cn=admin,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"}, server.bindDNs())
+
+	authenticated, _, err = backend.Authenticate("bob", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// no injection of filter expressions
+	authenticated, _, err = backend.Authenticate("*", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_AuthenticateContext(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	// the connection is closed for the cancelled context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	authenticated, _, err := backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob", Password: "secret"})
+	Equal(t, context.Canceled, err)
+	False(t, authenticated)
+}
+
+func TestBackend_SearchAndBind_Errors(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	// wrong service account credentials
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.BindDN = "cn=admin,dc=example,dc=com"
+	cfg.BindPassword = "XXX"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	_, _, err = backend.Authenticate("bob", "secret")
+	Error(t, err)
+
+	// filter matching more than one user
+	cfg.BindPassword = "adminsecret"
+	cfg.UserFilter = "(|(uid=%s)(objectClass=inetOrgPerson))"
+	backend, err = NewBackend(cfg)
+	NoError(t, err)
+
+	_, _, err = backend.Authenticate("bob", "secret")
+	Error(t, err)
+
+	// server not reachable
+	server.Close()
+	_, _, err = backend.Authenticate("bob", "secret")
+	Error(t, err)
+}
+
+func TestBackend_DirectBind(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.UserDN = "uid=%s,ou=people,dc=example,dc=com"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "alice", userInfo.Sub)
+	Equal(t, "Alice", userInfo.Name)
+	Equal(t, "alice@example.com", userInfo.Email)
+	Equal(t, []string{"uid=alice,ou=people,dc=example,dc=com"}, server.bindDNs())
+
+	authenticated, _, err = backend.Authenticate("alice", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("alice,ou=people", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_AuthErrors(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.BindDN = "cn=admin,dc=example,dc=com"
+	cfg.BindPassword = "adminsecret"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	tests := []struct {
+		username string
+		code     string
+	}{
+		{"carol", login.AuthErrorAccountLocked},
+		{"dave", login.AuthErrorPasswordExpired},
+		{"erin", login.AuthErrorMustChangePassword},
+	}
+	for _, test := range tests {
+		authenticated, _, err := backend.Authenticate(test.username, "secret")
+		False(t, authenticated)
+		IsType(t, &login.AuthError{}, err)
+		Equal(t, test.code, err.(*login.AuthError).Code, test.username)
+	}
+
+	// wrong passwords are no auth errors
+	authenticated, _, err := backend.Authenticate("carol", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_GroupSearch(t *testing.T) {
+	server, url := startTestServer(false)
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.URL = url
+	cfg.BaseDN = "ou=people,dc=example,dc=com"
+	cfg.GroupBaseDN = "ou=groups,dc=example,dc=com"
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"builders", "admins", "developers"}, userInfo.Groups)
+
+	authenticated, userInfo, err = backend.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"developers"}, userInfo.Groups)
+}
+
+func TestBackend_TLS(t *testing.T) {
+	caFile := writeTmpCAFile()
+	defer os.Remove(caFile)
+
+	t.Run("starttls", func(t *testing.T) {
+		server, url := startTestServer(false)
+		defer server.Close()
+
+		cfg := DefaultConfig()
+		cfg.URL = url
+		cfg.StartTLS = true
+		cfg.CAFile = caFile
+		cfg.BaseDN = "ou=people,dc=example,dc=com"
+		backend, err := NewBackend(cfg)
+		NoError(t, err)
+
+		authenticated, _, err := backend.Authenticate("bob", "secret")
+		NoError(t, err)
+		True(t, authenticated)
+	})
+
+	t.Run("ldaps", func(t *testing.T) {
+		server, url := startTestServer(true)
+		defer server.Close()
+
+		cfg := DefaultConfig()
+		cfg.URL = url
+		cfg.CAFile = caFile
+		cfg.BaseDN = "ou=people,dc=example,dc=com"
+		backend, err := NewBackend(cfg)
+		NoError(t, err)
+
+		authenticated, _, err := backend.Authenticate("bob", "secret")
+		NoError(t, err)
+		True(t, authenticated)
+	})
+
+	t.Run("ldaps with unknown ca", func(t *testing.T) {
+		server, url := startTestServer(true)
+		defer server.Close()
+
+		cfg := DefaultConfig()
+		cfg.URL = url
+		cfg.BaseDN = "ou=people,dc=example,dc=com"
+		backend, err := NewBackend(cfg)
+		NoError(t, err)
+
+		_, _, err = backend.Authenticate("bob", "secret")
+		Error(t, err)
+	})
+}
+
+func Test_escapeDN(t *testing.T) {
+	Equal(t, "bob", escapeDN("bob"))
+	Equal(t, `bob\,ou\=people`, escapeDN("bob,ou=people"))
+	Equal(t, `\#bob\ `, escapeDN("#bob "))
+}
+
+func Test_groupName(t *testing.T) {
+	Equal(t, "admins", groupName("cn=admins,ou=groups,dc=example,dc=com"))
+	Equal(t, "admins", groupName("admins"))
+}
@@ -0,0 +1,175 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// Config for the ldap backend.
+type Config struct {
+	// URL of the ldap server, e.g. ldap://localhost:389 or ldaps://localhost:636
+	URL string
+
+	// StartTLS upgrades a plain ldap:// connection to TLS
+	StartTLS bool
+
+	// CAFile is an optional PEM file with the CA certificates to verify the server certificate
+	CAFile string
+
+	// SkipVerify disables the verification of the server certificate
+	SkipVerify bool
+
+	// Timeout for connecting and for each ldap operation
+	Timeout time.Duration
+
+	// BindDN and BindPassword of the service account used for search-then-bind.
+	// If BindDN is empty, the search is done with an anonymous bind.
+	BindDN       string
+	BindPassword string
+
+	// UserDN is a template for the direct bind, e.g. uid=%s,ou=people,dc=example,dc=com or %s@example.com.
+	// If set, the user is bound directly, without a search before.
+	UserDN string
+
+	// BaseDN for the user search
+	BaseDN string
+
+	// UserFilter for the user search, %s is replaced by the escaped username
+	UserFilter string
+
+	// Attributes of the user entry, which are mapped to the UserInfo
+	AttrName   string
+	AttrEmail  string
+	AttrGroups string
+
+	// GroupBaseDN enables the group search below this DN.
+	GroupBaseDN string
+
+	// GroupFilter for the group search, %s is replaced by the escaped DN of the user
+	GroupFilter string
+
+	// AttrGroupName is the attribute of the group entries, which is used as group name
+	AttrGroupName string
+}
+
+// DefaultConfig returns a Config with the default attribute mapping and filters.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:       defaultTimeout,
+		UserFilter:    "(uid=%s)",
+		AttrName:      "cn",
+		AttrEmail:     "mail",
+		AttrGroups:    "memberOf",
+		GroupFilter:   "(member=%s)",
+		AttrGroupName: "cn",
+	}
+}
+
+// ConfigFromOptions creates a Config out of the backend options.
+// Because the options are separated by ',', the parts of a DN
+// have to be separated by ';', e.g. base_dn=ou=people;dc=example;dc=com
+func ConfigFromOptions(opts map[string]string) (Config, error) {
+	cfg := DefaultConfig()
+
+	stringOpts := map[string]*string{
+		"url":             &cfg.URL,
+		"ca_file":         &cfg.CAFile,
+		"bind_dn":         &cfg.BindDN,
+		"bind_password":   &cfg.BindPassword,
+		"user_dn":         &cfg.UserDN,
+		"base_dn":         &cfg.BaseDN,
+		"user_filter":     &cfg.UserFilter,
+		"attr_name":       &cfg.AttrName,
+		"attr_email":      &cfg.AttrEmail,
+		"attr_groups":     &cfg.AttrGroups,
+		"group_base_dn":   &cfg.GroupBaseDN,
+		"group_filter":    &cfg.GroupFilter,
+		"attr_group_name": &cfg.AttrGroupName,
+	}
+	dnOpts := []*string{&cfg.BindDN, &cfg.UserDN, &cfg.BaseDN, &cfg.GroupBaseDN}
+
+	for key, value := range opts {
+		if target, exist := stringOpts[key]; exist {
+			*target = value
+			continue
+		}
+
+		var err error
+		switch key {
+		case "starttls":
+			cfg.StartTLS, err = strconv.ParseBool(value)
+		case "skipverify":
+			cfg.SkipVerify, err = strconv.ParseBool(value)
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		default:
+			return Config{}, fmt.Errorf("unknown parameter %q for ldap provider", key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf(`invalid parameter value "%s" in "%s" ldap provider: %v`, value, key, err)
+		}
+	}
+
+	for _, dn := range dnOpts {
+		*dn = strings.Replace(*dn, ";", ",", -1)
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg Config) validate() error {
+	if cfg.URL == "" {
+		return errors.New(`missing parameter "url" for ldap provider`)
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf(`invalid parameter value "%s" in "url" ldap provider: %v`, cfg.URL, err)
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return fmt.Errorf(`unsupported scheme %q in "url" ldap provider, expected ldap or ldaps`, u.Scheme)
+	}
+	if u.Scheme == "ldaps" && cfg.StartTLS {
+		return errors.New(`"starttls" can not be used with an ldaps url`)
+	}
+	if cfg.UserDN == "" && cfg.BaseDN == "" {
+		return errors.New(`one of the parameters "user_dn" or "base_dn" is required for ldap provider`)
+	}
+	if cfg.UserDN != "" && !strings.Contains(cfg.UserDN, "%s") {
+		return errors.New(`parameter "user_dn" has to contain a %s placeholder for the username`)
+	}
+	if cfg.UserDN == "" && !strings.Contains(cfg.UserFilter, "%s") {
+		return errors.New(`parameter "user_filter" has to contain a %s placeholder for the username`)
+	}
+	return nil
+}
+
+func (cfg Config) tlsConfig() (*tls.Config, error) {
+	u, _ := url.Parse(cfg.URL)
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.SkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("can not read ldap ca_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ldap ca_file %v", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
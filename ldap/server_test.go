@@ -0,0 +1,395 @@
+package ldap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// testEntry is an entry of the in-process ldap server
+type testEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+
+	// bindResult is the response to a bind with the right password, if set
+	bindResult *testBindResult
+}
+
+// testBindResult is the response to the bind of an entry, e.g. for a locked account
+type testBindResult struct {
+	resultCode int
+	diagnostic string
+
+	// ppolicyError is the error of the password policy response control, or -1 for no control
+	ppolicyError int
+}
+
+// testServer is a minimal in-process ldap server,
+// which supports bind, search, unbind and the StartTLS extended operation.
+type testServer struct {
+	listener  net.Listener
+	entries   []testEntry
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	binds []string
+}
+
+var testEntries = []testEntry{
+	{
+		dn:       "cn=admin,dc=example,dc=com",
+		password: "adminsecret",
+	},
+	{
+		dn:       "uid=bob,ou=people,dc=example,dc=com",
+		password: "secret",
+		attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"bob"},
+			"cn":          {"Bob Builder"},
+			"mail":        {"bob@example.com"},
+			"memberOf":    {"cn=builders,ou=groups,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"},
+		},
+	},
+	{
+		dn:       "uid=alice,ou=people,dc=example,dc=com",
+		password: "secret",
+		attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"alice"},
+			"cn":          {"Alice"},
+			"mail":        {"alice@example.com"},
+		},
+	},
+	{
+		dn:       "uid=carol,ou=people,dc=example,dc=com",
+		password: "secret",
+		attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"carol"},
+		},
+		// locked account of an active directory
+		bindResult: &testBindResult{goldap.LDAPResultInvalidCredentials, "80090308: LdapErr: DSID-0C09042A, comment: AcceptSecurityContext error, data 775, v3839", -1},
+	},
+	{
+		dn:       "uid=dave,ou=people,dc=example,dc=com",
+		password: "secret",
+		attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"dave"},
+		},
+		// expired password of an openldap with ppolicy
+		bindResult: &testBindResult{goldap.LDAPResultInvalidCredentials, "", 0},
+	},
+	{
+		dn:       "uid=erin,ou=people,dc=example,dc=com",
+		password: "secret",
+		attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"erin"},
+		},
+		// successful bind, but the password was reset by an admin
+		bindResult: &testBindResult{goldap.LDAPResultSuccess, "", 2},
+	},
+	{
+		dn: "cn=developers,ou=groups,dc=example,dc=com",
+		attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"developers"},
+			"member":      {"uid=alice,ou=people,dc=example,dc=com", "uid=bob,ou=people,dc=example,dc=com"},
+		},
+	},
+	{
+		dn: "cn=builders,ou=groups,dc=example,dc=com",
+		attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"cn":          {"builders"},
+			"member":      {"uid=bob,ou=people,dc=example,dc=com"},
+		},
+	},
+}
+
+// startTestServer starts an ldap server on a random port.
+// If useTLS is true, the server speaks ldaps, otherwise StartTLS is offered.
+func startTestServer(useTLS bool) (*testServer, string) {
+	cert, _ := testCertificate()
+	s := &testServer{
+		entries:   testEntries,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+
+	var err error
+	if useTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	scheme := "ldap"
+	if useTLS {
+		scheme = "ldaps"
+	}
+	return s, scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testServer) bindDNs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+	}()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			bindResult := s.bind(op)
+			response := resultWithDiagnostic(goldap.ApplicationBindResponse, bindResult.resultCode, bindResult.diagnostic)
+			if bindResult.ppolicyError >= 0 {
+				s.write(conn, messageID, response, passwordPolicyControl(bindResult.ppolicyError))
+			} else {
+				s.write(conn, messageID, response)
+			}
+		case goldap.ApplicationSearchRequest:
+			for _, response := range s.search(op) {
+				s.write(conn, messageID, response)
+			}
+		case goldap.ApplicationExtendedRequest:
+			s.write(conn, messageID, result(goldap.ApplicationExtendedResponse, goldap.LDAPResultSuccess))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+		default:
+			return
+		}
+	}
+}
+
+func (s *testServer) write(conn net.Conn, messageID int64, response *ber.Packet, controls ...*ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(response)
+	if len(controls) > 0 {
+		controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, c := range controls {
+			controlsPacket.AppendChild(c)
+		}
+		envelope.AppendChild(controlsPacket)
+	}
+	conn.Write(envelope.Bytes())
+}
+
+// passwordPolicyControl is the password policy response control with the error
+func passwordPolicyControl(policyError int) *ber.Packet {
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "PasswordPolicyResponseValue")
+	value.AppendChild(ber.NewInteger(ber.ClassContext, ber.TypePrimitive, 1, int64(policyError), "error"))
+
+	control := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, goldap.ControlTypeBeheraPasswordPolicy, "Control Type"))
+	control.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value.Bytes()), "Control Value"))
+	return control
+}
+
+func (s *testServer) bind(op *ber.Packet) testBindResult {
+	dn := op.Children[1].Value.(string)
+	password := op.Children[2].Data.String()
+
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+
+	if dn == "" && password == "" {
+		return testBindResult{goldap.LDAPResultSuccess, "", -1}
+	}
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+			if e.bindResult != nil {
+				return *e.bindResult
+			}
+			return testBindResult{goldap.LDAPResultSuccess, "", -1}
+		}
+	}
+	return testBindResult{goldap.LDAPResultInvalidCredentials, "", -1}
+}
+
+func (s *testServer) search(op *ber.Packet) []*ber.Packet {
+	baseDN := strings.ToLower(op.Children[0].Value.(string))
+	scope := op.Children[1].Value.(int64)
+	sizeLimit := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	baseExists := false
+	var responses []*ber.Packet
+	for _, e := range s.entries {
+		dn := strings.ToLower(e.dn)
+		if dn == baseDN || strings.HasSuffix(dn, ","+baseDN) {
+			baseExists = true
+		}
+		inScope := dn == baseDN || (scope != goldap.ScopeBaseObject && strings.HasSuffix(dn, ","+baseDN))
+		if !inScope || !matchFilter(e, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) == sizeLimit {
+			return append(responses, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSizeLimitExceeded))
+		}
+		responses = append(responses, searchResultEntry(e))
+	}
+	if !baseExists {
+		return []*ber.Packet{result(goldap.ApplicationSearchResultDone, goldap.LDAPResultNoSuchObject)}
+	}
+	return append(responses, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess))
+}
+
+func matchFilter(e testEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case goldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(e, child) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(e, child) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !matchFilter(e, filter.Children[0])
+	case goldap.FilterPresent:
+		return strings.EqualFold(filter.Data.String(), "objectClass") || len(attributeValues(e, filter.Data.String())) > 0
+	case goldap.FilterEqualityMatch:
+		for _, v := range attributeValues(e, filter.Children[0].Value.(string)) {
+			if strings.EqualFold(v, filter.Children[1].Value.(string)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attributeValues(e testEntry, name string) []string {
+	for k, v := range e.attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+func searchResultEntry(e testEntry) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	p.AppendChild(attributes)
+	return p
+}
+
+func result(tag ber.Tag, resultCode int) *ber.Packet {
+	return resultWithDiagnostic(tag, resultCode, "")
+}
+
+func resultWithDiagnostic(tag ber.Tag, resultCode int, diagnostic string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, resultCode, "resultCode"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "diagnosticMessage"))
+	return p
+}
+
+var testCert tls.Certificate
+var testCertPEM []byte
+var testCertOnce sync.Once
+
+// testCertificate returns a self signed certificate for 127.0.0.1 and its PEM encoding.
+func testCertificate() (tls.Certificate, []byte) {
+	testCertOnce.Do(func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "loginsrv ldap test"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			panic(err)
+		}
+		testCertPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		testCert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	})
+	return testCert, testCertPEM
+}
+
+func writeTmpCAFile() string {
+	_, certPEM := testCertificate()
+	f, err := ioutil.TempFile("", "loginsrv_ldaptest")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	f.Write(certPEM)
+	return f.Name()
+}
@@ -0,0 +1,247 @@
+package lockout
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWindow is the time, for which the failed logins are counted.
+	DefaultWindow = 15 * time.Minute
+
+	// DefaultBaseDelay is the delay after the first failed login above the limit. It doubles with each further one.
+	DefaultBaseDelay = time.Second
+
+	// DefaultMaxDelay is the maximum delay, which is the time of the lockout.
+	DefaultMaxDelay = 15 * time.Minute
+)
+
+// Config for the Limiter
+type Config struct {
+	// Store keeps the failed attempts, default is a MemoryStore.
+	Store Store
+
+	// MaxAttempts is the number of failed logins of a user within the window, before the backoff starts.
+	// Zero disables the limit per user.
+	MaxAttempts int
+
+	// MaxAttemptsIP is the number of failed logins from one IP within the window, before the backoff starts.
+	// Zero disables the limit per IP.
+	MaxAttemptsIP int
+
+	// Window is the time, for which the failed logins are counted.
+	Window time.Duration
+
+	// BaseDelay is the delay after the first failed login above the limit. It doubles with each further one.
+	BaseDelay time.Duration
+
+	// MaxDelay is the upper bound of the delay.
+	MaxDelay time.Duration
+}
+
+// Limiter counts failed logins by username and client IP and delays
+// further attempts with an exponential backoff up to a temporary lockout.
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	// pending counts the running attempts by key, which were started by Begin
+	pending map[string]int
+	mu      sync.Mutex
+}
+
+// NewLimiter creates a Limiter. Unset durations and the store are set to their defaults.
+func NewLimiter(config Config) *Limiter {
+	if config.Store == nil {
+		config.Store = NewMemoryStore()
+	}
+	if config.Window == 0 {
+		config.Window = DefaultWindow
+	}
+	if config.BaseDelay == 0 {
+		config.BaseDelay = DefaultBaseDelay
+	}
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultMaxDelay
+	}
+	return &Limiter{
+		config:  config,
+		now:     time.Now,
+		pending: map[string]int{},
+	}
+}
+
+// Check returns the time to wait, before the user is allowed to login from the IP again.
+// It returns zero, if the login is allowed.
+func (l *Limiter) Check(username, ip string) (time.Duration, error) {
+	now := l.now()
+	since := now.Add(-l.config.Window)
+	var wait time.Duration
+	for _, k := range l.keys(username, ip) {
+		attempts, err := l.config.Store.Attempts(k.key, since)
+		if err != nil {
+			return 0, err
+		}
+		if w := l.wait(attempts, k.max, now); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// Begin checks the login of the user from the IP like Check and reserves the attempt, if it is allowed.
+// The returned Attempt has to be finished by Failure, Success or Release.
+//
+// The running attempts are counted like failures, so that parallel requests can not make more attempts
+// than the limit allows. If the limit is exceeded, only one attempt can run after each delay.
+// The running attempts are kept by the Limiter and not in the Store, so with a shared store
+// they only limit the parallel attempts on one instance.
+// It returns the time to wait and no Attempt, if the login is not allowed.
+func (l *Limiter) Begin(username, ip string) (*Attempt, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	since := now.Add(-l.config.Window)
+	keys := l.keys(username, ip)
+	var wait time.Duration
+	for _, k := range keys {
+		attempts, err := l.config.Store.Attempts(k.key, since)
+		if err != nil {
+			return nil, 0, err
+		}
+		w := l.wait(attempts, k.max, now)
+		if pending := l.pending[k.key]; w == 0 && pending > 0 && len(attempts)+pending >= k.max {
+			// the running attempts could exceed the limit
+			w = l.config.BaseDelay
+		}
+		if w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return nil, wait, nil
+	}
+
+	for _, k := range keys {
+		l.pending[k.key]++
+	}
+	return &Attempt{
+		limiter:  l,
+		username: username,
+		ip:       ip,
+		keys:     keys,
+	}, 0, nil
+}
+
+// Failure records a failed login of the user from the IP.
+// It returns the time to wait for the next attempt, or zero if the next attempt is allowed immediately.
+func (l *Limiter) Failure(username, ip string) (time.Duration, error) {
+	now := l.now()
+	since := now.Add(-l.config.Window)
+	var wait time.Duration
+	for _, k := range l.keys(username, ip) {
+		attempts, err := l.config.Store.Add(k.key, now, since)
+		if err != nil {
+			return 0, err
+		}
+		if w := l.wait(attempts, k.max, now); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+// Success resets the failed logins of the user.
+// The attempts of the IP are kept, because one valid login should not allow spraying passwords over other users.
+func (l *Limiter) Success(username string) error {
+	if l.config.MaxAttempts == 0 {
+		return nil
+	}
+	return l.config.Store.Reset(userKey(username))
+}
+
+type limitKey struct {
+	key string
+	max int
+}
+
+func (l *Limiter) keys(username, ip string) []limitKey {
+	var keys []limitKey
+	if l.config.MaxAttempts > 0 {
+		keys = append(keys, limitKey{userKey(username), l.config.MaxAttempts})
+	}
+	if l.config.MaxAttemptsIP > 0 && ip != "" {
+		keys = append(keys, limitKey{"ip:" + ip, l.config.MaxAttemptsIP})
+	}
+	return keys
+}
+
+// userKey ignores the case of the username, because most backends do so, too.
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// wait calculates the remaining delay after the last attempt.
+func (l *Limiter) wait(attempts []time.Time, max int, now time.Time) time.Duration {
+	if len(attempts) < max {
+		return 0
+	}
+	delay := l.config.MaxDelay
+	if exceeded := len(attempts) - max; exceeded < 32 {
+		if d := l.config.BaseDelay << uint(exceeded); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	wait := attempts[len(attempts)-1].Add(delay).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Attempt is a login attempt, which was reserved by Limiter.Begin.
+type Attempt struct {
+	limiter  *Limiter
+	username string
+	ip       string
+	keys     []limitKey
+	// finished is guarded by the mutex of the limiter
+	finished bool
+}
+
+// Failure records the failed login like Limiter.Failure and finishes the attempt.
+func (a *Attempt) Failure() (time.Duration, error) {
+	wait, err := a.limiter.Failure(a.username, a.ip)
+	a.Release()
+	return wait, err
+}
+
+// Success resets the failed logins like Limiter.Success and finishes the attempt.
+func (a *Attempt) Success() error {
+	err := a.limiter.Success(a.username)
+	a.Release()
+	return err
+}
+
+// Release finishes the attempt without result, e.g. after an internal error.
+// It does nothing, if the attempt is nil or already finished, so it can be deferred.
+func (a *Attempt) Release() {
+	if a == nil {
+		return
+	}
+	l := a.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if a.finished {
+		return
+	}
+	a.finished = true
+	for _, k := range a.keys {
+		if l.pending[k.key]--; l.pending[k.key] <= 0 {
+			delete(l.pending, k.key)
+		}
+	}
+}
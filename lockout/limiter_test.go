@@ -0,0 +1,199 @@
+package lockout
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func testLimiter(config Config) (*Limiter, *time.Time) {
+	l := NewLimiter(config)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiter_Backoff(t *testing.T) {
+	l, now := testLimiter(Config{MaxAttempts: 3})
+
+	for i := 0; i < 2; i++ {
+		wait, err := l.Failure("bob", "")
+		NoError(t, err)
+		Equal(t, time.Duration(0), wait)
+	}
+
+	// the backoff doubles with each further failure
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		wait, err := l.Failure("bob", "")
+		NoError(t, err)
+		Equal(t, expected, wait)
+
+		wait, err = l.Check("Bob", "")
+		NoError(t, err)
+		Equal(t, expected, wait)
+
+		*now = now.Add(expected)
+		wait, err = l.Check("bob", "")
+		NoError(t, err)
+		Equal(t, time.Duration(0), wait)
+	}
+
+	// other users are not affected
+	wait, err := l.Check("alice", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+
+	// a successful login resets the user
+	NoError(t, l.Success("bob"))
+	wait, err = l.Failure("bob", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+}
+
+func TestLimiter_MaxDelayAndWindow(t *testing.T) {
+	l, now := testLimiter(Config{MaxAttempts: 1, MaxDelay: time.Minute, Window: time.Hour})
+
+	var wait time.Duration
+	for i := 0; i < 40; i++ {
+		var err error
+		wait, err = l.Failure("bob", "")
+		NoError(t, err)
+	}
+	Equal(t, time.Minute, wait)
+
+	// the attempts expire after the window
+	*now = now.Add(time.Hour)
+	wait, err := l.Check("bob", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+	wait, err = l.Failure("bob", "")
+	NoError(t, err)
+	Equal(t, time.Second, wait)
+}
+
+func TestLimiter_IP(t *testing.T) {
+	l, _ := testLimiter(Config{MaxAttempts: 5, MaxAttemptsIP: 2})
+
+	wait, err := l.Failure("alice", "10.0.0.1")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+	wait, err = l.Failure("bob", "10.0.0.1")
+	NoError(t, err)
+	Equal(t, time.Second, wait)
+
+	wait, err = l.Check("carol", "10.0.0.1")
+	NoError(t, err)
+	Equal(t, time.Second, wait)
+
+	wait, err = l.Check("carol", "10.0.0.2")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+
+	// the success of a user does not reset the IP
+	NoError(t, l.Success("bob"))
+	wait, err = l.Check("carol", "10.0.0.1")
+	NoError(t, err)
+	Equal(t, time.Second, wait)
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l, _ := testLimiter(Config{})
+	for i := 0; i < 10; i++ {
+		wait, err := l.Failure("bob", "10.0.0.1")
+		NoError(t, err)
+		Equal(t, time.Duration(0), wait)
+	}
+}
+
+type errorStore struct{}
+
+func (errorStore) Add(key string, attempt, since time.Time) ([]time.Time, error) {
+	return nil, errors.New("test error")
+}
+
+func (errorStore) Attempts(key string, since time.Time) ([]time.Time, error) {
+	return nil, errors.New("test error")
+}
+
+func (errorStore) Reset(key string) error {
+	return errors.New("test error")
+}
+
+func TestLimiter_StoreError(t *testing.T) {
+	l, _ := testLimiter(Config{Store: errorStore{}, MaxAttempts: 5})
+	_, err := l.Check("bob", "")
+	Error(t, err)
+	_, err = l.Failure("bob", "")
+	Error(t, err)
+	Error(t, l.Success("bob"))
+	_, _, err = l.Begin("bob", "")
+	Error(t, err)
+}
+
+func TestLimiter_Begin(t *testing.T) {
+	l, now := testLimiter(Config{MaxAttempts: 2})
+
+	// the running attempts are counted like failures
+	a1, wait, err := l.Begin("bob", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+	a2, wait, err := l.Begin("Bob", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+	a3, wait, err := l.Begin("bob", "")
+	NoError(t, err)
+	Nil(t, a3)
+	Equal(t, time.Second, wait)
+
+	// other users are not affected
+	other, wait, err := l.Begin("alice", "")
+	NoError(t, err)
+	Equal(t, time.Duration(0), wait)
+	other.Release()
+
+	// a released attempt frees its place, also if released twice
+	a1.Release()
+	a1.Release()
+	a1, _, _ = l.Begin("bob", "")
+	NotNil(t, a1)
+
+	_, err = a1.Failure()
+	NoError(t, err)
+	wait, err = a2.Failure()
+	NoError(t, err)
+	Equal(t, time.Second, wait)
+	a2.Release()
+
+	// above the limit, only one attempt can run after the delay
+	*now = now.Add(time.Second)
+	a1, wait, _ = l.Begin("bob", "")
+	NotNil(t, a1)
+	Equal(t, time.Duration(0), wait)
+	a2, wait, _ = l.Begin("bob", "")
+	Nil(t, a2)
+	Equal(t, time.Second, wait)
+
+	// a success resets the user
+	NoError(t, a1.Success())
+	a1, _, _ = l.Begin("bob", "")
+	a2, _, _ = l.Begin("bob", "")
+	NotNil(t, a1)
+	NotNil(t, a2)
+
+	// nil attempts can be released, to defer it
+	var none *Attempt
+	none.Release()
+}
+
+func TestLimiter_Begin_IP(t *testing.T) {
+	l, _ := testLimiter(Config{MaxAttemptsIP: 1})
+
+	a, _, _ := l.Begin("alice", "10.0.0.1")
+	NotNil(t, a)
+	b, wait, _ := l.Begin("bob", "10.0.0.1")
+	Nil(t, b)
+	Equal(t, time.Second, wait)
+	b, _, _ = l.Begin("bob", "10.0.0.2")
+	NotNil(t, b)
+}
@@ -0,0 +1,86 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the failed login attempts.
+// The MemoryStore is used by default, a shared implementation
+// (e.g. based on redis) can be used to combine the attempts of multiple instances.
+type Store interface {
+	// Add records a failed attempt for the key and returns all attempts of the key since the given time.
+	Add(key string, attempt, since time.Time) ([]time.Time, error)
+
+	// Attempts returns the failed attempts of the key since the given time.
+	Attempts(key string, since time.Time) ([]time.Time, error)
+
+	// Reset removes all failed attempts of the key.
+	Reset(key string) error
+}
+
+// sweepInterval is the interval, in which the MemoryStore removes keys without recent attempts.
+const sweepInterval = time.Minute
+
+// MemoryStore is a Store, which keeps the attempts in memory.
+type MemoryStore struct {
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: map[string][]time.Time{},
+	}
+}
+
+// Add records a failed attempt for the key and returns all attempts of the key since the given time.
+func (s *MemoryStore) Add(key string, attempt, since time.Time) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(since)
+		s.lastSweep = attempt
+	}
+
+	attempts := append(after(s.attempts[key], since), attempt)
+	s.attempts[key] = attempts
+	return append([]time.Time(nil), attempts...), nil
+}
+
+// Attempts returns the failed attempts of the key since the given time.
+func (s *MemoryStore) Attempts(key string, since time.Time) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), after(s.attempts[key], since)...), nil
+}
+
+// Reset removes all failed attempts of the key.
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweep removes the keys without attempts since the given time, so the memory does not grow without limit.
+func (s *MemoryStore) sweep(since time.Time) {
+	for key, attempts := range s.attempts {
+		if len(after(attempts, since)) == 0 {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// after returns the attempts since the given time. The attempts are sorted, so the older ones are cut off.
+func after(attempts []time.Time, since time.Time) []time.Time {
+	for i, t := range attempts {
+		if t.After(since) {
+			return attempts[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package lockout
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	t0 := time.Now()
+
+	attempts, err := s.Add("bob", t0, t0.Add(-time.Minute))
+	NoError(t, err)
+	Equal(t, []time.Time{t0}, attempts)
+
+	attempts, err = s.Add("bob", t0.Add(10*time.Second), t0.Add(-time.Minute))
+	NoError(t, err)
+	Equal(t, []time.Time{t0, t0.Add(10 * time.Second)}, attempts)
+
+	// old attempts are dropped
+	attempts, err = s.Attempts("bob", t0.Add(5*time.Second))
+	NoError(t, err)
+	Equal(t, []time.Time{t0.Add(10 * time.Second)}, attempts)
+
+	attempts, err = s.Attempts("alice", t0)
+	NoError(t, err)
+	Empty(t, attempts)
+
+	NoError(t, s.Reset("bob"))
+	attempts, err = s.Attempts("bob", t0.Add(-time.Minute))
+	NoError(t, err)
+	Empty(t, attempts)
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	s := NewMemoryStore()
+	t0 := time.Now()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, err := s.Add(key, t0, t0.Add(-time.Minute))
+		NoError(t, err)
+	}
+	Len(t, s.attempts, 3)
+
+	_, err := s.Add("d", t0.Add(2*sweepInterval), t0.Add(sweepInterval))
+	NoError(t, err)
+	Len(t, s.attempts, 1)
+}
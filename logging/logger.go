@@ -73,7 +73,7 @@ func access(r *http.Request, start time.Time, statusCode int, err error) *logrus
 	fields := logrus.Fields{
 		"type":       "access",
 		"@timestamp": start,
-		"remote_ip":  getRemoteIp(r),
+		"remote_ip":  GetRemoteIp(r),
 		"host":       r.Host,
 		"url":        url,
 		"method":     r.Method,
@@ -235,7 +235,8 @@ func ServerClosed(appName string) {
 	Logger.WithFields(fields).Infof("http server was closed: %v", appName)
 }
 
-func getRemoteIp(r *http.Request) string {
+// GetRemoteIp returns the IP of the client, as reported by the proxy headers or the remote address of the connection.
+func GetRemoteIp(r *http.Request) string {
 	if r.Header.Get("X-Cluster-Client-Ip") != "" {
 		return r.Header.Get("X-Cluster-Client-Ip")
 	}
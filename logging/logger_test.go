@@ -349,7 +349,7 @@ func Test_Logger_GetRemoteIp1(t *testing.T) {
 	a := assert.New(t)
 	req, _ := http.NewRequest("GET", "test.com", nil)
 	req.Header["X-Cluster-Client-Ip"] = []string{"1234"}
-	ret := getRemoteIp(req)
+	ret := GetRemoteIp(req)
 	a.Equal("1234", ret)
 }
 
@@ -357,7 +357,7 @@ func Test_Logger_GetRemoteIp2(t *testing.T) {
 	a := assert.New(t)
 	req, _ := http.NewRequest("GET", "test.com", nil)
 	req.Header["X-Real-Ip"] = []string{"1234"}
-	ret := getRemoteIp(req)
+	ret := GetRemoteIp(req)
 	a.Equal("1234", ret)
 }
 
@@ -365,7 +365,7 @@ func Test_Logger_GetRemoteIp3(t *testing.T) {
 	a := assert.New(t)
 	req, _ := http.NewRequest("GET", "test.com", nil)
 	req.RemoteAddr = "1234:80"
-	ret := getRemoteIp(req)
+	ret := GetRemoteIp(req)
 	a.Equal("1234", ret)
 }
 
@@ -0,0 +1,62 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Machine readable reasons, why a backend rejects the login of a user with otherwise valid credentials.
+const (
+	// AuthErrorAccountLocked is used, if the account is locked or disabled.
+	AuthErrorAccountLocked = "account_locked"
+
+	// AuthErrorPasswordExpired is used, if the password of the user has expired.
+	AuthErrorPasswordExpired = "password_expired"
+
+	// AuthErrorMustChangePassword is used, if the user has to change the password, e.g. after a reset by an admin.
+	AuthErrorMustChangePassword = "must_change_password"
+
+	// AuthErrorMFARequired is used, if the backend needs a second factor, which was not provided.
+	AuthErrorMFARequired = "mfa_required"
+)
+
+// AuthError can be returned by a Backend, which rejects a login for a specific reason.
+// Other than errors of the backend, it is no internal error, but reported to the user.
+type AuthError struct {
+	// Code is the machine readable reason, e.g. AuthErrorAccountLocked
+	Code string
+
+	// Description are details about the reason from the backend. They are logged, but not shown to the user.
+	Description string
+}
+
+// NewAuthError creates an AuthError for the reason.
+func NewAuthError(code, description string) *AuthError {
+	return &AuthError{
+		Code:        code,
+		Description: description,
+	}
+}
+
+func (e *AuthError) Error() string {
+	if e.Description == "" {
+		return "login rejected: " + e.Code
+	}
+	return fmt.Sprintf("login rejected: %v: %v", e.Code, e.Description)
+}
+
+// authErrorMessages are the messages for the JSON and plain text responses on rejected logins.
+// The HTML messages are part of the login form template.
+var authErrorMessages = map[string]string{
+	AuthErrorAccountLocked:      "Account locked",
+	AuthErrorPasswordExpired:    "Password expired",
+	AuthErrorMustChangePassword: "Password change required",
+	AuthErrorMFARequired:        "Second factor required",
+}
+
+// asAuthError returns the AuthError, if the backend rejected the login for a specific reason.
+func asAuthError(err error) (*AuthError, bool) {
+	var authErr *AuthError
+	ok := errors.As(err, &authErr)
+	return authErr, ok
+}
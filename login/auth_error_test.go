@@ -0,0 +1,91 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"testing"
+)
+
+type authErrorTestBackend string
+
+func (b authErrorTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, NewAuthError(string(b), "details of the backend")
+}
+
+func TestAuthError(t *testing.T) {
+	Equal(t, "login rejected: account_locked", NewAuthError(AuthErrorAccountLocked, "").Error())
+	Equal(t, "login rejected: account_locked: too many failures", NewAuthError(AuthErrorAccountLocked, "too many failures").Error())
+
+	authErr, ok := asAuthError(fmt.Errorf("wrapped: %w", NewAuthError(AuthErrorMFARequired, "")))
+	True(t, ok)
+	Equal(t, AuthErrorMFARequired, authErr.Code)
+
+	_, ok = asAuthError(fmt.Errorf("other error"))
+	False(t, ok)
+}
+
+func TestHandler_AuthError(t *testing.T) {
+	for _, code := range []string{AuthErrorAccountLocked, AuthErrorPasswordExpired, AuthErrorMustChangePassword, AuthErrorMFARequired} {
+		t.Run(code, func(t *testing.T) {
+			h := testHandler()
+			h.config.Backends = Options{"simple": {"bob": "secret"}}
+			h.backends = append(h.backends, configuredBackend{"rejecting", authErrorTestBackend(code)})
+
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+			Equal(t, 403, recorder.Code)
+			JSONEq(t, fmt.Sprintf(`{"error": %q, "error_code": %q}`, authErrorMessages[code], code), recorder.Body.String())
+
+			recorder = httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", "username=alice&password=secret", TypeForm, AcceptHTML))
+			Equal(t, 403, recorder.Code)
+			Contains(t, recorder.Body.String(), "<strong>"+authErrorMessages[code]+". </strong>")
+			NotContains(t, recorder.Body.String(), "details of the backend")
+
+			recorder = httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", "username=alice&password=secret", TypeForm))
+			Equal(t, 403, recorder.Code)
+			Equal(t, authErrorMessages[code], recorder.Body.String())
+
+			// other backends may still authenticate the user
+			recorder = httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+			Equal(t, 200, recorder.Code)
+		})
+	}
+}
+
+func TestHandler_AuthError_BackendPolicies(t *testing.T) {
+	rejecting := configuredBackend{"rejecting", authErrorTestBackend(AuthErrorAccountLocked)}
+	simple := configuredBackend{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})}
+	failing := configuredBackend{"failing", errorTestBackend("test error")}
+
+	for _, policy := range []string{BackendPolicyFailFast, BackendPolicySkipOnError, BackendPolicyFirstSuccess} {
+		t.Run(policy, func(t *testing.T) {
+			h := testHandler()
+			h.config.BackendPolicy = policy
+
+			h.backends = []configuredBackend{rejecting, simple}
+			authenticated, _, backend, err := h.authenticate(context.Background(), LoginRequest{Username: "bob", Password: "secret"})
+			NoError(t, err)
+			True(t, authenticated)
+			Equal(t, "simple", backend)
+
+			authenticated, _, backend, err = h.authenticate(context.Background(), LoginRequest{Username: "alice", Password: "secret"})
+			False(t, authenticated)
+			Equal(t, "rejecting", backend)
+			Equal(t, NewAuthError(AuthErrorAccountLocked, "details of the backend"), err)
+
+			// the rejection is reported instead of the errors of other backends
+			if policy != BackendPolicyFailFast {
+				h.backends = []configuredBackend{failing, rejecting}
+				_, _, backend, err = h.authenticate(context.Background(), LoginRequest{Username: "alice", Password: "secret"})
+				Equal(t, "rejecting", backend)
+				IsType(t, &AuthError{}, err)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package login
 
 import (
+	"context"
+	"errors"
+	"net/url"
+
 	"github.com/tarent/loginsrv/model"
 )
 
@@ -12,3 +16,77 @@ type Backend interface {
 	// The error parameter is nil, unless a communication error with the backend occurred.
 	Authenticate(username, password string) (bool, model.UserInfo, error)
 }
+
+// ErrOverloaded can be returned (or wrapped) by a Backend, which can not check the credentials at the moment,
+// because it is overloaded. The login or password change is answered with 503 Service Unavailable instead of an internal error.
+var ErrOverloaded = errors.New("backend overloaded")
+
+// LoginRequest contains the credentials and the information about the client of a login.
+type LoginRequest struct {
+	Username string
+	Password string
+
+	// RemoteIP is the IP of the client, as reported by the headers of a trusted proxy or the connection.
+	RemoteIP string
+
+	// UserAgent is the User-Agent header of the client.
+	UserAgent string
+
+	// CorrelationID is the id of the request, which is also logged by loginsrv.
+	CorrelationID string
+
+	// Form contains all parameters of the login, from the form or the json body.
+	Form url.Values
+}
+
+// ContextBackend can be implemented by a Backend, which needs the context of the request or information about the client.
+// The handler calls AuthenticateContext instead of Authenticate, if it is implemented.
+type ContextBackend interface {
+	// AuthenticateContext checks the credentials of the request like Backend.Authenticate.
+	// The context is cancelled, when the client goes away or the login is decided by another backend.
+	AuthenticateContext(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error)
+}
+
+// ContextAdapter returns a ContextBackend for the backend.
+// Backends, which do not implement ContextBackend, are called by Authenticate,
+// unless the context was cancelled before.
+func ContextAdapter(b Backend) ContextBackend {
+	if cb, ok := b.(ContextBackend); ok {
+		return cb
+	}
+	return contextAdapter{b}
+}
+
+type contextAdapter struct {
+	Backend
+}
+
+func (a contextAdapter) AuthenticateContext(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return false, model.UserInfo{}, err
+	}
+	return a.Authenticate(req.Username, req.Password)
+}
+
+// RefreshBackend can be implemented by a Backend, which has to confirm the refresh of a token.
+type RefreshBackend interface {
+	// Refresh checks, if the token of the user may be refreshed.
+	// It returns false, if the backend rejects the refresh, and may return an updated UserInfo.
+	// Tokens which were not issued by the backend are confirmed unchanged.
+	Refresh(userInfo model.UserInfo) (bool, model.UserInfo, error)
+}
+
+// UserLookupBackend can be implemented by a Backend, which can resolve a user without the password,
+// e.g. for the login with a passkey.
+type UserLookupBackend interface {
+	// LookupUser returns the user info of the user like a successful Authenticate, without checking a password.
+	// It returns false, if the user does not exist or can not login, e.g. because the account is disabled.
+	LookupUser(username string) (bool, model.UserInfo, error)
+}
+
+// PasswordChangeBackend can be implemented by a Backend, which allows the users to change their password.
+type PasswordChangeBackend interface {
+	// ChangePassword checks the current password of the user and replaces it by the new one.
+	// It returns false, if the current password does not match.
+	ChangePassword(username, currentPassword, newPassword string) (bool, error)
+}
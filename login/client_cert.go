@@ -0,0 +1,283 @@
+package login
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// clientCertOrigin is the origin of the user info for client certificate logins
+const clientCertOrigin = "client-cert"
+
+// The sources of the client certificate, which can be used in the mapping rules
+const (
+	certSourceCN     = "cn"
+	certSourceDN     = "dn"
+	certSourceSerial = "serial"
+	certSourceOU     = "ou"
+	certSourceO      = "o"
+	certSourceEmail  = "email"
+	certSourceDNS    = "dns"
+	certSourceURI    = "uri"
+)
+
+var certSources = []string{certSourceCN, certSourceDN, certSourceSerial, certSourceOU, certSourceO, certSourceEmail, certSourceDNS, certSourceURI}
+
+// defaultClientCertMapping maps the common name to the sub and the email of the certificate to the email
+const defaultClientCertMapping = "sub=cn,email=email"
+
+// clientCertMapping holds the certificate sources for each of the user info fields.
+type clientCertMapping struct {
+	sub    []string
+	name   []string
+	email  []string
+	groups []string
+}
+
+// parseClientCertMapping parses rules in the form field=source;source,..
+// e.g. sub=email;cn,groups=ou. For sub, name and email the first source with a value is used,
+// the groups are collected from all sources.
+func parseClientCertMapping(rules string) (clientCertMapping, error) {
+	mapping := clientCertMapping{}
+	if rules == "" {
+		rules = defaultClientCertMapping
+	}
+	opts, err := parseOptions(rules)
+	if err != nil {
+		return mapping, err
+	}
+	for field, value := range opts {
+		var sources []string
+		for _, s := range strings.Split(value, ";") {
+			s = strings.ToLower(strings.TrimSpace(s))
+			if !contains(certSources, s) {
+				return mapping, fmt.Errorf("unknown certificate source %q for %q, supported are %v", s, field, strings.Join(certSources, ", "))
+			}
+			sources = append(sources, s)
+		}
+		switch field {
+		case "sub":
+			mapping.sub = sources
+		case "name":
+			mapping.name = sources
+		case "email":
+			mapping.email = sources
+		case "groups":
+			mapping.groups = sources
+		default:
+			return mapping, fmt.Errorf("unknown field %q in client certificate mapping", field)
+		}
+	}
+	if len(mapping.sub) == 0 {
+		return mapping, errors.New("client certificate mapping needs a source for the sub")
+	}
+	return mapping, nil
+}
+
+// userInfo maps the certificate to a user info.
+// It returns false, if the certificate has no value for the sub.
+func (m clientCertMapping) userInfo(cert *x509.Certificate) (model.UserInfo, bool) {
+	userInfo := model.UserInfo{
+		Origin: clientCertOrigin,
+		Sub:    firstCertValue(cert, m.sub),
+		Name:   firstCertValue(cert, m.name),
+		Email:  firstCertValue(cert, m.email),
+	}
+	for _, source := range m.groups {
+		for _, g := range certValues(cert, source) {
+			if !contains(userInfo.Groups, g) {
+				userInfo.Groups = append(userInfo.Groups, g)
+			}
+		}
+	}
+	return userInfo, userInfo.Sub != ""
+}
+
+func firstCertValue(cert *x509.Certificate, sources []string) string {
+	for _, source := range sources {
+		if values := certValues(cert, source); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func certValues(cert *x509.Certificate, source string) []string {
+	var values []string
+	switch source {
+	case certSourceCN:
+		values = []string{cert.Subject.CommonName}
+	case certSourceDN:
+		values = []string{cert.Subject.String()}
+	case certSourceSerial:
+		values = []string{cert.SerialNumber.String()}
+	case certSourceOU:
+		values = cert.Subject.OrganizationalUnit
+	case certSourceO:
+		values = cert.Subject.Organization
+	case certSourceEmail:
+		values = cert.EmailAddresses
+	case certSourceDNS:
+		values = cert.DNSNames
+	case certSourceURI:
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+	}
+
+	var nonEmpty []string
+	for _, v := range values {
+		if v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	return nonEmpty
+}
+
+// clientCertAuth authenticates users by their verified client certificate.
+type clientCertAuth struct {
+	roots          *x509.CertPool
+	header         string
+	trustedProxies ipNets
+	mapping        clientCertMapping
+}
+
+func newClientCertAuth(config *Config) (*clientCertAuth, error) {
+	if config.TLSClientCA == "" {
+		return nil, errors.New("the client certificate login needs the certificate authorities by -tls-client-ca")
+	}
+	roots, err := loadCertPool(config.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := parseClientCertMapping(config.ClientCertMapping)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseIPNets(config.ClientCertTrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if config.ClientCertHeader != "" && len(trustedProxies) == 0 {
+		return nil, errors.New("the client certificate header needs the trusted proxies by -client-cert-trusted-proxies")
+	}
+
+	return &clientCertAuth{
+		roots:          roots,
+		header:         config.ClientCertHeader,
+		trustedProxies: trustedProxies,
+		mapping:        mapping,
+	}, nil
+}
+
+// authenticate returns the user info for the client certificate of the request.
+// It returns false, if the request has no client certificate, and an error, if the certificate is not valid.
+func (a *clientCertAuth) authenticate(r *http.Request) (bool, model.UserInfo, error) {
+	cert, intermediates, err := a.certificate(r)
+	if err != nil || cert == nil {
+		return false, model.UserInfo{}, err
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return false, model.UserInfo{}, fmt.Errorf("client certificate %q is not valid: %v", cert.Subject, err)
+	}
+
+	userInfo, ok := a.mapping.userInfo(cert)
+	if !ok {
+		return false, model.UserInfo{}, fmt.Errorf("client certificate %q has no value for the sub", cert.Subject)
+	}
+	return true, userInfo, nil
+}
+
+// certificate returns the client certificate of the tls connection,
+// or the one of the header, if the request comes from a trusted proxy.
+func (a *clientCertAuth) certificate(r *http.Request) (*x509.Certificate, *x509.CertPool, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		intermediates := x509.NewCertPool()
+		for _, c := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+		return r.TLS.PeerCertificates[0], intermediates, nil
+	}
+
+	if a.header == "" {
+		return nil, nil, nil
+	}
+	value := r.Header.Get(a.header)
+	if value == "" {
+		return nil, nil, nil
+	}
+	if !a.trustedProxies.contains(r.RemoteAddr) {
+		return nil, nil, fmt.Errorf("client certificate header from untrusted address %v", r.RemoteAddr)
+	}
+	cert, err := parseCertHeader(value)
+	return cert, nil, err
+}
+
+// parseCertHeader parses a certificate as url encoded PEM, like the $ssl_client_escaped_cert of nginx,
+// or as base64 encoded DER.
+func parseCertHeader(value string) (*x509.Certificate, error) {
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+
+	if strings.Contains(value, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, errors.New("client certificate header contains no valid PEM block")
+		}
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("client certificate header is neither PEM nor base64: %v", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %v", file)
+	}
+	return pool, nil
+}
+
+// TLSConfig returns the tls configuration for serving loginsrv by https.
+// If client certificate authorities are configured, the clients may present a certificate signed by them.
+// Requests without a certificate are still accepted, so that the other logins keep working.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLSClientCA != "" {
+		pool, err := loadCertPool(c.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
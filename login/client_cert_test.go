@@ -0,0 +1,282 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+
+	f, err := ioutil.TempFile("", "loginsrv_ca")
+	NoError(t, err)
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	f.Close()
+
+	return &testCA{cert: cert, key: key, file: f.Name()}
+}
+
+func (ca *testCA) Close() {
+	os.Remove(ca.file)
+}
+
+func (ca *testCA) clientCert(t *testing.T, subject pkix.Name, emails ...string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		Subject:        subject,
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	NoError(t, err)
+	return cert
+}
+
+func bobSubject() pkix.Name {
+	return pkix.Name{CommonName: "bob", OrganizationalUnit: []string{"admins", "builders"}, Organization: []string{"Example"}}
+}
+
+func testClientCertConfig(ca *testCA) *Config {
+	config := testConfig()
+	config.ClientCertLogin = true
+	config.TLSClientCA = ca.file
+	config.ClientCertHeader = "X-Client-Cert"
+	config.ClientCertTrustedProxies = "10.0.0.0/8, 127.0.0.1"
+	config.ClientCertMapping = "sub=cn,email=email,groups=ou;o"
+	return config
+}
+
+func TestClientCert_ParseMapping(t *testing.T) {
+	m, err := parseClientCertMapping("")
+	NoError(t, err)
+	Equal(t, clientCertMapping{sub: []string{"cn"}, email: []string{"email"}}, m)
+
+	m, err = parseClientCertMapping("sub=email;CN,name=dn,groups=ou")
+	NoError(t, err)
+	Equal(t, clientCertMapping{sub: []string{"email", "cn"}, name: []string{"dn"}, groups: []string{"ou"}}, m)
+
+	for _, rules := range []string{"sub=foo", "foo=cn", "email=email", "sub"} {
+		_, err := parseClientCertMapping(rules)
+		Error(t, err, rules)
+	}
+}
+
+func TestClientCert_UserInfo(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	cert := ca.clientCert(t, bobSubject(), "bob@example.com")
+
+	m, _ := parseClientCertMapping("sub=email,name=cn,email=email,groups=ou;o;ou")
+	userInfo, ok := m.userInfo(cert)
+	True(t, ok)
+	Equal(t, model.UserInfo{
+		Origin: "client-cert",
+		Sub:    "bob@example.com",
+		Name:   "bob",
+		Email:  "bob@example.com",
+		Groups: []string{"admins", "builders", "Example"},
+	}, userInfo)
+
+	// the next source is used, if the first has no value
+	m, _ = parseClientCertMapping("sub=dns;serial")
+	userInfo, ok = m.userInfo(cert)
+	True(t, ok)
+	Equal(t, "42", userInfo.Sub)
+
+	m, _ = parseClientCertMapping("sub=uri")
+	_, ok = m.userInfo(cert)
+	False(t, ok)
+}
+
+func TestClientCert_NewAuth_Errors(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+
+	for _, change := range []func(c *Config){
+		func(c *Config) { c.TLSClientCA = "" },
+		func(c *Config) { c.TLSClientCA = "/tmp/foo/bar/nothing" },
+		func(c *Config) { c.ClientCertMapping = "sub=foo" },
+		func(c *Config) { c.ClientCertTrustedProxies = "foo" },
+		func(c *Config) { c.ClientCertTrustedProxies = "" },
+	} {
+		config := testClientCertConfig(ca)
+		change(config)
+		_, err := newClientCertAuth(config)
+		Error(t, err)
+	}
+}
+
+func TestClientCert_Authenticate(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	otherCA := newTestCA(t)
+	defer otherCA.Close()
+
+	auth, err := newClientCertAuth(testClientCertConfig(ca))
+	NoError(t, err)
+
+	bob := ca.clientCert(t, bobSubject(), "bob@example.com")
+	pemHeader := url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: bob.Raw})))
+	derHeader := base64.StdEncoding.EncodeToString(bob.Raw)
+
+	tlsRequest := func(cert *x509.Certificate) *http.Request {
+		r := req("POST", "/context/login", "")
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return r
+	}
+	headerRequest := func(remoteAddr, value string) *http.Request {
+		r := req("POST", "/context/login", "", "X-Client-Cert: "+value)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	tests := []struct {
+		name          string
+		request       *http.Request
+		authenticated bool
+		err           bool
+	}{
+		{"tls", tlsRequest(bob), true, false},
+		{"pem header", headerRequest("10.1.2.3:4711", pemHeader), true, false},
+		{"der header", headerRequest("127.0.0.1:4711", derHeader), true, false},
+		{"no certificate", req("POST", "/context/login", ""), false, false},
+		{"untrusted proxy", headerRequest("192.168.1.1:4711", pemHeader), false, true},
+		{"invalid header", headerRequest("10.1.2.3:4711", "foo"), false, true},
+		{"other ca", tlsRequest(otherCA.clientCert(t, bobSubject())), false, true},
+		{"no sub", tlsRequest(ca.clientCert(t, pkix.Name{Organization: []string{"Example"}})), false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			authenticated, userInfo, err := auth.authenticate(test.request)
+			Equal(t, test.authenticated, authenticated)
+			Equal(t, test.err, err != nil, "%v", err)
+			if test.authenticated {
+				Equal(t, "bob", userInfo.Sub)
+				Equal(t, "bob@example.com", userInfo.Email)
+				Equal(t, []string{"admins", "builders", "Example"}, userInfo.Groups)
+			}
+		})
+	}
+}
+
+func TestHandler_ClientCertLogin(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	bob := ca.clientCert(t, bobSubject(), "bob@example.com")
+
+	config := testClientCertConfig(ca)
+	config.Backends = Options{"simple": {"alice": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	// login by the certificate
+	r := req("POST", "/context/login", "", AcceptJwt)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bob}}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "client-cert", claims["origin"])
+
+	// the html login sets the cookie
+	r = req("POST", "/context/login", "", TypeForm, AcceptHTML)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bob}}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 303, recorder.Code)
+	Contains(t, recorder.Header().Get("Set-Cookie"), config.CookieName+"=")
+
+	// the password login still works
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	// no certificate
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// the form offers the certificate login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), "Sign in with certificate")
+}
+
+func TestHandler_ClientCertLogin_Only(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+
+	config := testClientCertConfig(ca)
+	_, err := NewHandler(config)
+	NoError(t, err)
+
+	config.TLSClientCA = ""
+	_, err = NewHandler(config)
+	Error(t, err)
+}
+
+func TestConfig_TLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+
+	config := DefaultConfig()
+	tlsConfig, err := config.TLSConfig()
+	NoError(t, err)
+	Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+	Nil(t, tlsConfig.ClientCAs)
+
+	config.TLSClientCA = ca.file
+	tlsConfig, err = config.TLSConfig()
+	NoError(t, err)
+	Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	NotNil(t, tlsConfig.ClientCAs)
+
+	config.TLSClientCA = "/tmp/foo/bar/nothing"
+	_, err = config.TLSConfig()
+	Error(t, err)
+}
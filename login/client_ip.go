@@ -0,0 +1,80 @@
+package login
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipNets is a list of trusted proxy addresses and networks.
+type ipNets []*net.IPNet
+
+// parseIPNets parses a ',' separated list of addresses and networks in CIDR notation.
+func parseIPNets(list string) (ipNets, error) {
+	var nets ipNets
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if strings.Contains(p, ":") {
+				p += "/128"
+			} else {
+				p += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", p, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// contains returns true, if the address (with or without port) is in one of the networks.
+func (nets ipNets) contains(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client for the lockout and the backends.
+// The X-Real-Ip and X-Forwarded-For headers are only used, if the request comes from a trusted proxy,
+// because every client can set them. In X-Forwarded-For, the last address not of a trusted proxy is the client.
+func (h *Handler) clientIP(r *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteIP = r.RemoteAddr
+	}
+	if !h.trustedProxies.contains(remoteIP) {
+		return remoteIP
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !h.trustedProxies.contains(ip) {
+			return ip
+		}
+	}
+	return remoteIP
+}
@@ -0,0 +1,69 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/lockout"
+)
+
+func TestHandler_ClientIP(t *testing.T) {
+	h := testHandler()
+	var err error
+	h.trustedProxies, err = parseIPNets("10.0.0.0/8, fd00::1")
+	NoError(t, err)
+
+	for _, test := range []struct {
+		remoteAddr string
+		headers    []string
+		expected   string
+	}{
+		{"192.168.1.1:1234", nil, "192.168.1.1"},
+		{"[2001:db8::1]:1234", nil, "2001:db8::1"},
+		// the headers of untrusted clients are ignored
+		{"192.168.1.1:1234", []string{"X-Real-Ip: 1.2.3.4", "X-Forwarded-For: 1.2.3.4"}, "192.168.1.1"},
+		{"10.0.0.1:1234", []string{"X-Real-Ip: 1.2.3.4"}, "1.2.3.4"},
+		{"[fd00::1]:1234", []string{"X-Real-Ip: 2001:db8::2"}, "2001:db8::2"},
+		{"10.0.0.1:1234", []string{"X-Real-Ip: invalid"}, "10.0.0.1"},
+		// the client can prepend addresses to the X-Forwarded-For
+		{"10.0.0.1:1234", []string{"X-Forwarded-For: 5.6.7.8, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"X-Forwarded-For: 5.6.7.8", "X-Forwarded-For: 1.2.3.4"}, "1.2.3.4"},
+		{"10.0.0.1:1234", []string{"X-Forwarded-For: invalid, 10.0.0.2"}, "10.0.0.1"},
+	} {
+		r := req("POST", "/context/login", "", test.headers...)
+		r.RemoteAddr = test.remoteAddr
+		Equal(t, test.expected, h.clientIP(r), "%v %v", test.remoteAddr, test.headers)
+	}
+}
+
+func TestHandler_Lockout_IPHeader(t *testing.T) {
+	h := testHandler()
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttemptsIP: 2})
+
+	// a changing header of an untrusted client does not reset the limit of its IP
+	for _, test := range []struct {
+		ip   string
+		code int
+	}{{"1.1.1.1", 403}, {"2.2.2.2", 429}} {
+		r := req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt, "X-Real-Ip: "+test.ip)
+		r.RemoteAddr = "192.168.1.1:1234"
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		Equal(t, test.code, recorder.Code)
+	}
+}
+
+func TestHandler_NewFromConfig_TrustedProxies(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.LockoutTrustedProxies = "10.0.0.0/8,127.0.0.1"
+	h, err := NewHandler(config)
+	NoError(t, err)
+	True(t, h.trustedProxies.contains("127.0.0.1:80"))
+	False(t, h.trustedProxies.contains("127.0.0.2:80"))
+
+	config.LockoutTrustedProxies = "foo"
+	_, err = NewHandler(config)
+	Error(t, err)
+}
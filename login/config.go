@@ -8,11 +8,16 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/tarent/loginsrv/lockout"
 	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/magiclink"
 	"github.com/tarent/loginsrv/oauth2"
+	"github.com/tarent/loginsrv/totp"
+	"github.com/tarent/loginsrv/webauthn"
 )
 
 var jwtDefaultSecret string
@@ -46,48 +51,111 @@ func DefaultConfig() *Config {
 		CookieHTTPOnly:         true,
 		CookieSecure:           true,
 		Backends:               Options{},
+		BackendPolicy:          BackendPolicyFailFast,
 		Oauth:                  Options{},
 		GracePeriod:            5 * time.Second,
 		UserFile:               "",
 		UserEndpoint:           "",
 		UserEndpointToken:      "",
 		UserEndpointTimeout:    5 * time.Second,
+		ClientCertMapping:      defaultClientCertMapping,
+		WebauthnRPName:         "loginsrv",
+		TOTPIssuer:             "loginsrv",
+		MagicLinkExpiry:        magiclink.DefaultExpiry,
+		MagicLinkMaxMails:      magiclink.DefaultMaxMails,
+		PasswordPolicy:         defaultPasswordPolicy,
+		LockoutWindow:          lockout.DefaultWindow,
+		LockoutMaxDelay:        lockout.DefaultMaxDelay,
 	}
 }
 
 const envPrefix = "LOGINSRV_"
 
+// The policies for the handling of backend errors
+const (
+	// BackendPolicyFailFast stops the login at the first backend returning an error.
+	BackendPolicyFailFast = "fail-fast"
+
+	// BackendPolicySkipOnError logs the error of a backend and continues with the next one.
+	BackendPolicySkipOnError = "skip-on-error"
+
+	// BackendPolicyFirstSuccess asks all backends in parallel and uses the first one accepting the credentials.
+	BackendPolicyFirstSuccess = "first-success"
+)
+
 // Config for the loginsrv handler
 type Config struct {
-	Host                   string
-	Port                   string
-	LogLevel               string
-	TextLogging            bool
-	JwtSecret              string
-	JwtSecretFile          string
-	JwtAlgo                string
-	JwtExpiry              time.Duration
-	JwtRefreshes           int
-	SuccessURL             string
-	Redirect               bool
-	RedirectQueryParameter string
-	RedirectCheckReferer   bool
-	RedirectHostFile       string
-	LogoutURL              string
-	Template               string
-	LoginPath              string
-	CookieName             string
-	CookieExpiry           time.Duration
-	CookieDomain           string
-	CookieHTTPOnly         bool
-	CookieSecure           bool
-	Backends               Options
-	Oauth                  Options
-	GracePeriod            time.Duration
-	UserFile               string
-	UserEndpoint           string
-	UserEndpointToken      string
-	UserEndpointTimeout    time.Duration
+	Host                     string
+	Port                     string
+	LogLevel                 string
+	TextLogging              bool
+	JwtSecret                string
+	JwtSecretFile            string
+	JwtAlgo                  string
+	JwtExpiry                time.Duration
+	JwtRefreshes             int
+	SuccessURL               string
+	Redirect                 bool
+	RedirectQueryParameter   string
+	RedirectCheckReferer     bool
+	RedirectHostFile         string
+	LogoutURL                string
+	Template                 string
+	LoginPath                string
+	CookieName               string
+	CookieExpiry             time.Duration
+	CookieDomain             string
+	CookieHTTPOnly           bool
+	CookieSecure             bool
+	Backends                 Options
+	BackendOrder             []string
+	BackendPolicy            string
+	Oauth                    Options
+	GracePeriod              time.Duration
+	UserFile                 string
+	UserEndpoint             string
+	UserEndpointToken        string
+	UserEndpointTimeout      time.Duration
+	TLSCert                  string
+	TLSKey                   string
+	TLSClientCA              string
+	ClientCertLogin          bool
+	ClientCertHeader         string
+	ClientCertTrustedProxies string
+	ClientCertMapping        string
+	WebauthnRPID             string
+	WebauthnRPName           string
+	WebauthnOrigins          string
+	WebauthnCredentialFile   string
+	TOTPSecretFile           string
+	TOTPIssuer               string
+	MagicLinkSMTP            string
+	MagicLinkSMTPUser        string
+	MagicLinkSMTPPassword    string
+	MagicLinkFrom            string
+	MagicLinkAllow           string
+	MagicLinkExpiry          time.Duration
+	MagicLinkURL             string
+	MagicLinkMaxMails        int
+	PasswordPolicy           string
+	LockoutMaxAttempts       int
+	LockoutMaxAttemptsIP     int
+	LockoutWindow            time.Duration
+	LockoutMaxDelay          time.Duration
+	LockoutTrustedProxies    string
+	MetricsAddr              string
+
+	// WebauthnStore is an alternative store for the passkeys, instead of the WebauthnCredentialFile
+	WebauthnStore webauthn.CredentialStore
+
+	// TOTPStore is an alternative store for the TOTP secrets, instead of the TOTPSecretFile
+	TOTPStore totp.SecretStore
+
+	// MagicLinkMailer is an alternative sender of the login links, instead of the MagicLinkSMTP server
+	MagicLinkMailer magiclink.Mailer
+
+	// LockoutStore is an alternative store for the failed login attempts, e.g. shared by multiple instances
+	LockoutStore lockout.Store
 }
 
 // Options is the configuration structure for oauth and backend provider
@@ -112,10 +180,53 @@ func (c *Config) addBackendOpts(providerName, optsKvList string) error {
 		return err
 	}
 
+	c.setBackendOpts(providerName, opts)
+	return nil
+}
+
+// setBackendOpts sets the options for a provider and keeps the order, in which the providers were configured
+func (c *Config) setBackendOpts(providerName string, opts map[string]string) {
+	if _, exist := c.Backends[providerName]; !exist {
+		c.BackendOrder = append(c.BackendOrder, providerName)
+	}
 	c.Backends[providerName] = opts
+}
+
+// setBackendOrder puts the providers of the comma separated list in front of the backend order.
+// This is needed for the environment settings, which are read in alphabetical order and not in the order they were given.
+func (c *Config) setBackendOrder(list string) error {
+	var order []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, exist := GetProviderDescription(name); !exist {
+			return fmt.Errorf("unknown backend provider %q in backend order", name)
+		}
+		order = append(order, name)
+	}
+	c.BackendOrder = append(order, c.BackendOrder...)
 	return nil
 }
 
+// backendNames returns the names of the configured backend providers in the order of the configuration.
+// Backends, which were not added by the configuration, e.g. when the Backends are set directly, follow in alphabetical order.
+func (c *Config) backendNames() []string {
+	names := make([]string, 0, len(c.Backends))
+	for _, name := range c.BackendOrder {
+		if _, exist := c.Backends[name]; exist && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	var rest []string
+	for name := range c.Backends {
+		if !contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
 // ResolveFileReferences resolves configuration values, which are dynamically referenced via files
 func (c *Config) ResolveFileReferences() error {
 	// Try to load the secret from a file, if set
@@ -157,10 +268,39 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 	f.StringVar(&c.Template, "template", c.Template, "An alternative template for the login form")
 	f.StringVar(&c.LoginPath, "login-path", c.LoginPath, "The path of the login resource")
 	f.DurationVar(&c.GracePeriod, "grace-period", c.GracePeriod, "Graceful shutdown grace period")
+	f.StringVar(&c.BackendPolicy, "backend-policy", c.BackendPolicy, "The handling of backend errors: fail-fast, skip-on-error or first-success")
 	f.StringVar(&c.UserFile, "user-file", c.UserFile, "A YAML file with user specific data for the tokens")
 	f.StringVar(&c.UserEndpoint, "user-endpoint", c.UserEndpoint, "URL of an endpoint providing user specific data for the tokens")
 	f.StringVar(&c.UserEndpointToken, "user-endpoint-token", c.UserEndpointToken, "Authentication token used when communicating with the user endpoint")
 	f.DurationVar(&c.UserEndpointTimeout, "user-endpoint-timeout", c.UserEndpointTimeout, "Timeout used when communicating with the user endpoint")
+	f.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "Certificate file for serving https")
+	f.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "Private key file for serving https")
+	f.StringVar(&c.TLSClientCA, "tls-client-ca", c.TLSClientCA, "File with the certificate authorities for the verification of client certificates")
+	f.BoolVar(&c.ClientCertLogin, "client-cert-login", c.ClientCertLogin, "Allow the login by a verified client certificate")
+	f.StringVar(&c.ClientCertHeader, "client-cert-header", c.ClientCertHeader, "Header, in which a trusted proxy passes the client certificate")
+	f.StringVar(&c.ClientCertTrustedProxies, "client-cert-trusted-proxies", c.ClientCertTrustedProxies, "Addresses or networks of the proxies, which are trusted to pass the client certificate, separated by ','")
+	f.StringVar(&c.ClientCertMapping, "client-cert-mapping", c.ClientCertMapping, "Mapping of the client certificate to the token, e.g. sub=cn,email=email,groups=ou")
+	f.StringVar(&c.WebauthnRPID, "webauthn-rp-id", c.WebauthnRPID, "Domain of the WebAuthn relying party. Enables the login by passkeys")
+	f.StringVar(&c.WebauthnRPName, "webauthn-rp-name", c.WebauthnRPName, "Name of the WebAuthn relying party, shown by the authenticators")
+	f.StringVar(&c.WebauthnOrigins, "webauthn-origins", c.WebauthnOrigins, "Allowed origins of the passkey logins, separated by ','. Default is https://<webauthn-rp-id>")
+	f.StringVar(&c.WebauthnCredentialFile, "webauthn-credential-file", c.WebauthnCredentialFile, "JSON file to store the registered passkeys")
+	f.StringVar(&c.TOTPSecretFile, "totp-secret-file", c.TOTPSecretFile, "JSON file with the TOTP secrets of the users. Enables the second login step for enrolled users")
+	f.StringVar(&c.TOTPIssuer, "totp-issuer", c.TOTPIssuer, "Issuer of the TOTP secrets, shown in the authenticator apps")
+	f.StringVar(&c.MagicLinkSMTP, "magiclink-smtp", c.MagicLinkSMTP, "SMTP server (host:port) to send login links by email. Enables the magic link login")
+	f.StringVar(&c.MagicLinkSMTPUser, "magiclink-smtp-user", c.MagicLinkSMTPUser, "Username for the SMTP server")
+	f.StringVar(&c.MagicLinkSMTPPassword, "magiclink-smtp-password", c.MagicLinkSMTPPassword, "Password for the SMTP server")
+	f.StringVar(&c.MagicLinkFrom, "magiclink-from", c.MagicLinkFrom, "Sender address of the login link mails")
+	f.StringVar(&c.MagicLinkAllow, "magiclink-allow", c.MagicLinkAllow, "Email addresses or @domains allowed to login by link, separated by ','. Addresses of the user-file are also allowed")
+	f.DurationVar(&c.MagicLinkExpiry, "magiclink-expiry", c.MagicLinkExpiry, "Lifetime of the login links")
+	f.StringVar(&c.MagicLinkURL, "magiclink-url", c.MagicLinkURL, "External URL of the login link endpoint, required for the magic link login")
+	f.IntVar(&c.MagicLinkMaxMails, "magiclink-max-mails", c.MagicLinkMaxMails, "Maximum number of login links sent to one address within the magiclink-expiry (0 disables the limit)")
+	f.IntVar(&c.LockoutMaxAttempts, "lockout-attempts", c.LockoutMaxAttempts, "Failed logins of a user within the lockout window, before further logins are delayed, e.g. 5. Disabled by default")
+	f.IntVar(&c.LockoutMaxAttemptsIP, "lockout-attempts-ip", c.LockoutMaxAttemptsIP, "Failed logins from one IP within the lockout window, before further logins are delayed, e.g. 20. Disabled by default")
+	f.DurationVar(&c.LockoutWindow, "lockout-window", c.LockoutWindow, "Time, for which failed logins are counted")
+	f.DurationVar(&c.LockoutMaxDelay, "lockout-max-delay", c.LockoutMaxDelay, "Maximum delay of the logins after failed attempts, the delay doubles with each failure")
+	f.StringVar(&c.LockoutTrustedProxies, "lockout-trusted-proxies", c.LockoutTrustedProxies, "Addresses or networks of the reverse proxies, whose X-Real-Ip or X-Forwarded-For header is trusted for the client IP of the lockout, separated by ','")
+	f.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "Address to serve the metrics at /debug/vars, e.g. 127.0.0.1:9090. Disabled by default")
+	f.StringVar(&c.PasswordPolicy, "password-policy", c.PasswordPolicy, "Policy for the self-service password change: min_length=..[,max_length=..][,require=upper;lower;digit;special]")
 
 	// the -backends is deprecated, but we support it for backwards compatibility
 	deprecatedBackends := setFunc(func(optsKvList string) error {
@@ -174,10 +314,11 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 			return errors.New("missing provider name provider=...")
 		}
 		delete(opts, "provider")
-		c.Backends[pName] = opts
+		c.setBackendOpts(pName, opts)
 		return nil
 	})
 	f.Var(deprecatedBackends, "backend", "Deprecated, please use the explicit flags")
+	f.Var(setFunc(c.setBackendOrder), "backend-order", "The order of the backends as comma separated list of provider names, e.g. htpasswd,ldap")
 
 	// One option for each oauth provider
 	for _, pName := range oauth2.ProviderList() {
@@ -261,6 +402,15 @@ func parseOptions(b string) (map[string]string, error) {
 	return opts, nil
 }
 
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper type to wrap a function closure with the Value interface
 type setFunc func(optsKvList string) error
 
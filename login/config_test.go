@@ -46,12 +46,41 @@ func TestConfig_ReadConfig(t *testing.T) {
 		"--cookie-secure=false",
 		"--backend=provider=simple",
 		"--backend=provider=foo",
+		"--backend-policy=skip-on-error",
 		"--github=client_id=foo,client_secret=bar",
 		"--grace-period=4s",
 		"--user-file=users.yml",
 		"--user-endpoint=http://test.io/claims",
 		"--user-endpoint-token=token",
 		"--user-endpoint-timeout=1s",
+		"--tls-cert=server.crt",
+		"--tls-key=server.key",
+		"--tls-client-ca=ca.crt",
+		"--client-cert-login=true",
+		"--client-cert-header=X-Client-Cert",
+		"--client-cert-trusted-proxies=10.0.0.1",
+		"--client-cert-mapping=sub=email",
+		"--webauthn-rp-id=example.com",
+		"--webauthn-rp-name=Example",
+		"--webauthn-origins=https://login.example.com",
+		"--webauthn-credential-file=passkeys.json",
+		"--totp-secret-file=totp.json",
+		"--totp-issuer=Example",
+		"--magiclink-smtp=mail.example.com:587",
+		"--magiclink-smtp-user=loginsrv",
+		"--magiclink-smtp-password=secret",
+		"--magiclink-from=loginsrv@example.com",
+		"--magiclink-allow=@example.com",
+		"--magiclink-expiry=5m",
+		"--magiclink-url=https://login.example.com/login/magiclink",
+		"--magiclink-max-mails=5",
+		"--password-policy=min_length=12",
+		"--lockout-attempts=3",
+		"--lockout-attempts-ip=10",
+		"--lockout-window=1h",
+		"--lockout-max-delay=30m",
+		"--lockout-trusted-proxies=10.0.0.0/8",
+		"--metrics-addr=127.0.0.1:9090",
 	}
 
 	expected := &Config{
@@ -79,17 +108,47 @@ func TestConfig_ReadConfig(t *testing.T) {
 			"simple": map[string]string{},
 			"foo":    map[string]string{},
 		},
+		BackendOrder:  []string{"simple", "foo"},
+		BackendPolicy: "skip-on-error",
 		Oauth: Options{
 			"github": map[string]string{
 				"client_id":     "foo",
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod:         4 * time.Second,
-		UserFile:            "users.yml",
-		UserEndpoint:        "http://test.io/claims",
-		UserEndpointToken:   "token",
-		UserEndpointTimeout: time.Second,
+		GracePeriod:              4 * time.Second,
+		UserFile:                 "users.yml",
+		UserEndpoint:             "http://test.io/claims",
+		UserEndpointToken:        "token",
+		UserEndpointTimeout:      time.Second,
+		TLSCert:                  "server.crt",
+		TLSKey:                   "server.key",
+		TLSClientCA:              "ca.crt",
+		ClientCertLogin:          true,
+		ClientCertHeader:         "X-Client-Cert",
+		ClientCertTrustedProxies: "10.0.0.1",
+		ClientCertMapping:        "sub=email",
+		WebauthnRPID:             "example.com",
+		WebauthnRPName:           "Example",
+		WebauthnOrigins:          "https://login.example.com",
+		WebauthnCredentialFile:   "passkeys.json",
+		TOTPSecretFile:           "totp.json",
+		TOTPIssuer:               "Example",
+		MagicLinkSMTP:            "mail.example.com:587",
+		MagicLinkSMTPUser:        "loginsrv",
+		MagicLinkSMTPPassword:    "secret",
+		MagicLinkFrom:            "loginsrv@example.com",
+		MagicLinkAllow:           "@example.com",
+		MagicLinkExpiry:          5 * time.Minute,
+		MagicLinkURL:             "https://login.example.com/login/magiclink",
+		MagicLinkMaxMails:        5,
+		PasswordPolicy:           "min_length=12",
+		LockoutMaxAttempts:       3,
+		LockoutMaxAttemptsIP:     10,
+		LockoutWindow:            time.Hour,
+		LockoutMaxDelay:          30 * time.Minute,
+		LockoutTrustedProxies:    "10.0.0.0/8",
+		MetricsAddr:              "127.0.0.1:9090",
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), input)
@@ -173,6 +232,8 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 	NoError(t, os.Setenv("LOGINSRV_COOKIE_HTTP_ONLY", "false"))
 	NoError(t, os.Setenv("LOGINSRV_COOKIE_SECURE", "false"))
 	NoError(t, os.Setenv("LOGINSRV_SIMPLE", "foo=bar"))
+	NoError(t, os.Setenv("LOGINSRV_BACKEND_POLICY", "first-success"))
+	defer os.Unsetenv("LOGINSRV_BACKEND_POLICY")
 	NoError(t, os.Setenv("LOGINSRV_GITHUB", "client_id=foo,client_secret=bar"))
 	NoError(t, os.Setenv("LOGINSRV_GRACE_PERIOD", "4s"))
 	NoError(t, os.Setenv("LOGINSRV_USER_FILE", "users.yml"))
@@ -206,6 +267,8 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 				"foo": "bar",
 			},
 		},
+		BackendOrder:  []string{"simple"},
+		BackendPolicy: "first-success",
 		Oauth: Options{
 			"github": map[string]string{
 				"client_id":     "foo",
@@ -217,6 +280,14 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 		UserEndpoint:        "http://test.io/claims",
 		UserEndpointToken:   "token",
 		UserEndpointTimeout: time.Second,
+		ClientCertMapping:   "sub=cn,email=email",
+		WebauthnRPName:      "loginsrv",
+		TOTPIssuer:          "loginsrv",
+		MagicLinkExpiry:     15 * time.Minute,
+		MagicLinkMaxMails:   3,
+		PasswordPolicy:      "min_length=8",
+		LockoutWindow:       15 * time.Minute,
+		LockoutMaxDelay:     15 * time.Minute,
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
@@ -244,9 +315,50 @@ func TestConfig_ReadConfigFromEnv_SecretFile(t *testing.T) {
 
 	NoError(t, os.Setenv("LOGINSRV_JWT_SECRET", "discardedSecret"))
 	NoError(t, os.Setenv("LOGINSRV_JWT_SECRET_FILE", file.Name()))
+	defer os.Unsetenv("LOGINSRV_JWT_SECRET")
+	defer os.Unsetenv("LOGINSRV_JWT_SECRET_FILE")
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
 	NoError(t, err)
 
 	Equal(t, testSecret, cfg.JwtSecret)
 }
+
+func TestConfig_BackendNames(t *testing.T) {
+	cfg := DefaultConfig()
+	NoError(t, cfg.addBackendOpts("simple", "bob=secret"))
+	NoError(t, cfg.addBackendOpts("htpasswd", "file=users"))
+	NoError(t, cfg.addBackendOpts("simple", "alice=secret"))
+	cfg.Backends["osiam"] = map[string]string{}
+	cfg.Backends["ldap"] = map[string]string{}
+
+	Equal(t, []string{"simple", "htpasswd", "ldap", "osiam"}, cfg.backendNames())
+	Equal(t, map[string]string{"alice": "secret"}, cfg.Backends["simple"])
+}
+
+func TestConfig_ReadConfig_BackendOrder(t *testing.T) {
+	RegisterProvider(&ProviderDescription{Name: "fake"}, func(map[string]string) (Backend, error) {
+		return errorTestBackend("test error"), nil
+	})
+	defer delete(provider, "fake")
+	defer delete(providerDescription, "fake")
+
+	// the environment settings are read in alphabetical order
+	NoError(t, os.Setenv("LOGINSRV_SIMPLE", "bob=secret"))
+	NoError(t, os.Setenv("LOGINSRV_FAKE", "foo=bar"))
+	NoError(t, os.Setenv("LOGINSRV_BACKEND_ORDER", "simple, fake"))
+	defer os.Unsetenv("LOGINSRV_SIMPLE")
+	defer os.Unsetenv("LOGINSRV_FAKE")
+	defer os.Unsetenv("LOGINSRV_BACKEND_ORDER")
+
+	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
+	NoError(t, err)
+	Equal(t, []string{"simple", "fake"}, cfg.backendNames())
+
+	cfg, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--backend-order=fake"})
+	NoError(t, err)
+	Equal(t, []string{"fake", "simple"}, cfg.backendNames())
+
+	_, err = readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--backend-order=simple,foo"})
+	Error(t, err)
+}
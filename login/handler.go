@@ -1,18 +1,24 @@
 package login
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"github.com/tarent/loginsrv/lockout"
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/model"
 	"github.com/tarent/loginsrv/oauth2"
+	"github.com/tarent/loginsrv/totp"
+	"github.com/tarent/loginsrv/webauthn"
 )
 
 const contentTypeHTML = "text/html; charset=utf-8"
@@ -22,35 +28,55 @@ const contentTypePlain = "text/plain"
 
 type userClaimsFunc func(userInfo model.UserInfo) (jwt.Claims, error)
 
+// configuredBackend is a backend together with the name of its provider
+type configuredBackend struct {
+	name string
+	Backend
+}
+
 // Handler is the mail login handler.
 // It serves the login ressource and does the authentication against the backends or oauth provider.
 type Handler struct {
-	backends         []Backend
+	backends         []configuredBackend
 	oauth            oauthManager
 	config           *Config
 	signingMethod    jwt.SigningMethod
 	signingKey       interface{}
 	signingVerifyKey interface{}
 	userClaims       userClaimsFunc
+	clientCert       *clientCertAuth
+	webauthn         webauthnManager
+	totp             *totp.Manager
+	magicLink        *magicLinkLogin
+	passwordPolicy   passwordPolicy
+	lockout          *lockout.Limiter
+	trustedProxies   ipNets
 }
 
 // NewHandler creates a login handler based on the supplied configuration.
 func NewHandler(config *Config) (*Handler, error) {
-	if len(config.Backends) == 0 && len(config.Oauth) == 0 {
+	magicLinkEnabled := config.MagicLinkSMTP != "" || config.MagicLinkMailer != nil
+	if len(config.Backends) == 0 && len(config.Oauth) == 0 && !config.ClientCertLogin && !magicLinkEnabled {
 		return nil, errors.New("No login backends or oauth provider configured")
 	}
 
-	backends := []Backend{}
-	for pName, opts := range config.Backends {
+	switch config.BackendPolicy {
+	case "", BackendPolicyFailFast, BackendPolicySkipOnError, BackendPolicyFirstSuccess:
+	default:
+		return nil, fmt.Errorf("No such backend policy: %v", config.BackendPolicy)
+	}
+
+	backends := []configuredBackend{}
+	for _, pName := range config.backendNames() {
 		p, exist := GetProvider(pName)
 		if !exist {
 			return nil, fmt.Errorf("No such provider: %v", pName)
 		}
-		b, err := p(opts)
+		b, err := p(config.Backends[pName])
 		if err != nil {
 			return nil, err
 		}
-		backends = append(backends, b)
+		backends = append(backends, configuredBackend{name: pName, Backend: b})
 	}
 
 	oauth := oauth2.NewManager()
@@ -61,16 +87,69 @@ func NewHandler(config *Config) (*Handler, error) {
 		}
 	}
 
+	var clientCert *clientCertAuth
+	if config.ClientCertLogin {
+		var err error
+		clientCert, err = newClientCertAuth(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var webauthnMgr webauthnManager
+	if config.WebauthnRPID != "" {
+		var err error
+		webauthnMgr, err = newWebauthnManager(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var totpMgr *totp.Manager
+	if config.TOTPSecretFile != "" || config.TOTPStore != nil {
+		var err error
+		totpMgr, err = newTOTPManager(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var magicLink *magicLinkLogin
+	if magicLinkEnabled {
+		var err error
+		magicLink, err = newMagicLinkLogin(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policy, err := parsePasswordPolicy(config.PasswordPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseIPNets(config.LockoutTrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	userClaims, err := NewUserClaims(config)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Handler{
-		backends:   backends,
-		config:     config,
-		oauth:      oauth,
-		userClaims: userClaims.Claims,
+		backends:       backends,
+		config:         config,
+		oauth:          oauth,
+		userClaims:     userClaims.Claims,
+		clientCert:     clientCert,
+		webauthn:       webauthnMgr,
+		totp:           totpMgr,
+		magicLink:      magicLink,
+		passwordPolicy: policy,
+		lockout:        newLockout(config),
+		trustedProxies: trustedProxies,
 	}, nil
 }
 
@@ -82,6 +161,31 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	h.setRedirectCookie(w, r)
 
+	if h.webauthn != nil && strings.HasPrefix(r.URL.Path, h.webauthnPath()+"/") {
+		h.handleWebauthn(w, r)
+		return
+	}
+
+	if h.totp != nil && r.URL.Path == h.totpPath() {
+		h.handleTOTP(w, r)
+		return
+	}
+
+	if h.totp != nil && r.URL.Path == h.totpPath()+"/enroll" {
+		h.handleTOTPEnroll(w, r)
+		return
+	}
+
+	if h.magicLink != nil && r.URL.Path == h.magicLinkPath() {
+		h.handleMagicLink(w, r)
+		return
+	}
+
+	if r.URL.Path == h.passwordPath() && h.supportsPasswordChange() {
+		h.handlePasswordChange(w, r)
+		return
+	}
+
 	_, err := h.oauth.GetConfigFromRequest(r)
 	if err == nil {
 		h.handleOauth(w, r)
@@ -99,6 +203,13 @@ func (h *Handler) handleOauth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if oauthErr, ok := err.(*oauth2.OauthError); ok {
+		logging.Application(r.Header).
+			WithField("error_code", oauthErr.Code).WithError(err).Info("failed oauth authentication")
+		h.respondOauthError(w, r, oauthErr)
+		return
+	}
+
 	if err != nil {
 		logging.Application(r.Header).WithError(err).Error()
 		h.respondError(w, r)
@@ -117,6 +228,93 @@ func (h *Handler) handleOauth(w http.ResponseWriter, r *http.Request) {
 	h.respondAuthFailure(w, r)
 }
 
+func (h *Handler) handleWebauthn(w http.ResponseWriter, r *http.Request) {
+	var session *model.UserInfo
+	if userInfo, valid := h.GetToken(r); valid {
+		session = &userInfo
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, h.webauthnPath())
+	isRegistration := path == webauthn.PathRegisterBegin || path == webauthn.PathRegisterFinish
+	if isRegistration && session != nil && h.userLookupBackend(*session) == nil {
+		// the user of a later passkey login could not be confirmed
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(403)
+		fmt.Fprintf(w, `{"error": "passkeys can not be registered for a login by %v"}`, session.Origin)
+		return
+	}
+
+	responded, authenticated, userInfo, err := h.webauthn.Handle(w, r, path, session)
+	if responded {
+		return
+	}
+
+	if verr, ok := err.(*webauthn.VerificationError); ok {
+		logging.Application(r.Header).WithError(verr).Info("failed passkey authentication")
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	if authenticated {
+		userInfo, exist, err := h.resolvePasskeyUser(userInfo)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		if !exist {
+			logging.Application(r.Header).
+				WithField("username", userInfo.Sub).Info("passkey of an unknown or disabled user rejected")
+			h.respondAuthFailure(w, r)
+			return
+		}
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).
+			WithField("backend", webauthn.Origin).Info("successfully authenticated")
+		h.respondAuthenticated(w, r, userInfo)
+		return
+	}
+	h.respondAuthFailure(w, r)
+}
+
+// resolvePasskeyUser resolves the current user info of a passkey login by the backend, which authenticated the user
+// at the registration.
+// It returns false, if the backend does not know the user any more, or if it can not confirm the user,
+// because it is not configured any more or does not implement UserLookupBackend.
+func (h *Handler) resolvePasskeyUser(credentialUser model.UserInfo) (model.UserInfo, bool, error) {
+	lb := h.userLookupBackend(credentialUser)
+	if lb == nil {
+		return model.UserInfo{Sub: credentialUser.Sub}, false, nil
+	}
+	exist, userInfo, err := lb.LookupUser(credentialUser.Sub)
+	if err != nil || !exist {
+		return model.UserInfo{Sub: credentialUser.Sub}, false, err
+	}
+	userInfo.Origin = webauthn.Origin
+	return userInfo, true, nil
+}
+
+// userLookupBackend returns the backend of the user info, if it can resolve users without password.
+func (h *Handler) userLookupBackend(userInfo model.UserInfo) UserLookupBackend {
+	for _, b := range h.backends {
+		if lb, ok := b.Backend.(UserLookupBackend); ok && b.name == userInfo.Origin {
+			return lb
+		}
+	}
+	return nil
+}
+
+// webauthnPath is the path of the webauthn endpoints below the login path
+func (h *Handler) webauthnPath() string {
+	return strings.TrimRight(h.config.LoginPath, "/") + "/webauthn"
+}
+
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	contentType := r.Header.Get("Content-Type")
 	if !(r.Method == "GET" || r.Method == "DELETE" ||
@@ -158,22 +356,25 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		}
 		writeLoginForm(w,
 			loginFormData{
-				Config:        h.config,
-				Authenticated: valid,
-				UserInfo:      userInfo,
+				Config:             h.config,
+				Authenticated:      valid,
+				UserInfo:           userInfo,
+				CanChangePassword:  valid && h.passwordChangeBackend(userInfo) != nil,
+				CanRegisterPasskey: valid && h.userLookupBackend(userInfo) != nil,
 			})
 		return
 	}
 
 	if r.Method == "POST" {
-		username, password, err := getCredentials(r)
+		form, err := getLoginForm(r)
 		if err != nil {
 			h.respondBadRequest(w, r)
 			return
 		}
+		username := form.Get("username")
 		if username != "" {
 			// No token found or credentials found, assuming new authentication
-			h.handleAuthentication(w, r, username, password)
+			h.handleAuthentication(w, r, h.newLoginRequest(r, form))
 			return
 		}
 		userInfo, valid := h.GetToken(r)
@@ -181,6 +382,10 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 			h.handleRefresh(w, r, userInfo)
 			return
 		}
+		if h.clientCert != nil {
+			h.handleClientCertLogin(w, r)
+			return
+		}
 		if username == "" {
 			h.respondAuthFailure(w, r)
 			return
@@ -191,34 +396,124 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) handleAuthentication(w http.ResponseWriter, r *http.Request, username string, password string) {
-	authenticated, userInfo, err := h.authenticate(username, password)
+func (h *Handler) handleAuthentication(w http.ResponseWriter, r *http.Request, loginRequest LoginRequest) {
+	username := loginRequest.Username
+	attempt, answered := h.beginLogin(w, r, username)
+	if answered {
+		return
+	}
+	// the attempt is released without result on errors and, if the totp code is required
+	defer attempt.Release()
+
+	authenticated, userInfo, backend, err := h.authenticate(r.Context(), loginRequest)
+	if authErr, ok := asAuthError(err); ok {
+		logging.Application(r.Header).
+			WithField("username", username).
+			WithField("backend", backend).
+			WithError(authErr).Info("rejected authentication")
+		// the rejection is counted like a wrong password, but the reason is shown until the lockout starts
+		if h.recordFailedLogin(w, r, attempt, username) {
+			return
+		}
+		h.respondAuthError(w, r, authErr)
+		return
+	}
+	if errors.Is(err, ErrOverloaded) {
+		logging.Application(r.Header).WithError(err).WithField("backend", backend).Warn("rejected login, backend overloaded")
+		h.respondOverloaded(w, r)
+		return
+	}
 	if err != nil {
-		logging.Application(r.Header).WithError(err).Error()
+		logging.Application(r.Header).WithError(err).WithField("backend", backend).Error()
 		h.respondError(w, r)
 		return
 	}
 
 	if authenticated {
+		// the failed logins are only reset after the second step, so that wrong codes count as failures, too
+		required, err := h.requireTOTP(w, r, userInfo)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		if required {
+			return
+		}
+
+		h.resetLockout(r, attempt)
+
 		logging.Application(r.Header).
-			WithField("username", username).Info("successfully authenticated")
+			WithField("username", username).
+			WithField("backend", backend).Info("successfully authenticated")
 		h.respondAuthenticated(w, r, userInfo)
 		return
 	}
 	logging.Application(r.Header).
 		WithField("username", username).Info("failed authentication")
 
+	if h.recordFailedLogin(w, r, attempt, username) {
+		return
+	}
 	h.respondAuthFailure(w, r)
 }
 
+func (h *Handler) handleClientCertLogin(w http.ResponseWriter, r *http.Request) {
+	authenticated, userInfo, err := h.clientCert.authenticate(r)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Warn("failed client certificate authentication")
+		h.respondAuthFailure(w, r)
+		return
+	}
+	if !authenticated {
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).
+		WithField("backend", clientCertOrigin).Info("successfully authenticated")
+	h.respondAuthenticated(w, r, userInfo)
+}
+
 func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
 	if userInfo.Refreshes >= h.config.JwtRefreshes {
 		h.respondMaxRefreshesReached(w, r)
-	} else {
-		userInfo.Refreshes++
-		h.respondAuthenticated(w, r, userInfo)
-		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refreshed jwt")
+		return
+	}
+
+	confirmed, userInfo, err := h.confirmRefresh(userInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
 	}
+	if !confirmed {
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refresh of jwt rejected by backend")
+		h.deleteToken(w)
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	userInfo.Refreshes++
+	h.respondAuthenticated(w, r, userInfo)
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refreshed jwt")
+}
+
+// confirmRefresh asks all backends, which implement RefreshBackend, to confirm the refresh of the token.
+func (h *Handler) confirmRefresh(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+	for _, b := range h.backends {
+		rb, ok := b.Backend.(RefreshBackend)
+		if !ok {
+			continue
+		}
+		confirmed, updated, err := rb.Refresh(userInfo)
+		if err != nil || !confirmed {
+			return false, userInfo, err
+		}
+		userInfo = updated
+	}
+	return true, userInfo, nil
 }
 
 func (h *Handler) deleteToken(w http.ResponseWriter) {
@@ -369,6 +664,36 @@ func (h *Handler) respondError(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Internal Server Error")
 }
 
+// overloadedRetryAfter is the Retry-After of the 503 response, if a backend is overloaded.
+const overloadedRetryAfter = 1
+
+func (h *Handler) respondOverloaded(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(overloadedRetryAfter))
+
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(503)
+		username, _, _ := getCredentials(r)
+		writeLoginForm(w,
+			loginFormData{
+				Overloaded: true,
+				Config:     h.config,
+				UserInfo:   model.UserInfo{Sub: username},
+			})
+		return
+	}
+
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(503)
+		fmt.Fprintf(w, `{"error": "Service Unavailable"}`)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(503)
+	fmt.Fprintf(w, "Service Unavailable, please retry later")
+}
+
 func (h *Handler) respondBadRequest(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(400)
 	fmt.Fprintf(w, "Bad Request: Method or content-type not supported")
@@ -410,6 +735,84 @@ func (h *Handler) respondAuthFailure(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (h *Handler) respondAuthError(w http.ResponseWriter, r *http.Request, authErr *AuthError) {
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		username, _, _ := getCredentials(r)
+		writeLoginForm(w,
+			loginFormData{
+				AuthError: authErr,
+				Config:    h.config,
+				UserInfo:  model.UserInfo{Sub: username},
+			})
+		return
+	}
+
+	message, exist := authErrorMessages[authErr.Code]
+	if !exist {
+		message = "Login rejected"
+	}
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(403)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":      message,
+			"error_code": authErr.Code,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(403)
+	fmt.Fprint(w, message)
+}
+
+// oauthErrorMessages are the messages for the JSON and plain text responses on oauth errors.
+// The HTML messages are part of the login form template.
+var oauthErrorMessages = map[string]string{
+	oauth2.ErrorCodeAccessDenied:  "Login cancelled",
+	oauth2.ErrorCodeNotAuthorized: "Not authorized by the login provider",
+	oauth2.ErrorCodeStateMismatch: "Login request could not be verified",
+	oauth2.ErrorCodeMissingCode:   "Login request could not be verified",
+	oauth2.ErrorCodeProviderError: "Login provider reported an error",
+}
+
+// respondOauthError shows a fixed message for the error code. The description comes from the
+// callback query, which can be forged, so it is only logged and never shown to the user.
+func (h *Handler) respondOauthError(w http.ResponseWriter, r *http.Request, oauthErr *oauth2.OauthError) {
+	status := 400
+	if oauthErr.Code == oauth2.ErrorCodeAccessDenied || oauthErr.Code == oauth2.ErrorCodeNotAuthorized {
+		status = 403
+	}
+
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(status)
+		writeLoginForm(w,
+			loginFormData{
+				OauthError: oauthErr,
+				Config:     h.config,
+			})
+		return
+	}
+
+	message := oauthErrorMessages[oauthErr.Code]
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":      message,
+			"error_code": oauthErr.Code,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(status)
+	fmt.Fprint(w, message)
+}
+
 func wantHTML(r *http.Request) bool {
 	return strings.Contains(r.Header.Get("Accept"), "text/html")
 }
@@ -419,32 +822,193 @@ func wantJSON(r *http.Request) bool {
 }
 
 func getCredentials(r *http.Request) (string, string, error) {
+	form, err := getLoginForm(r)
+	if err != nil {
+		return "", "", err
+	}
+	return form.Get("username"), form.Get("password"), nil
+}
+
+// getLoginForm returns the parameters of the login from the json body or the posted form.
+func getLoginForm(r *http.Request) (url.Values, error) {
 	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
 		m := map[string]string{}
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
 		err = json.Unmarshal(body, &m)
 		if err != nil {
-			return "", "", err
+			return nil, err
+		}
+		form := url.Values{}
+		for k, v := range m {
+			form.Set(k, v)
 		}
-		return m["username"], m["password"], nil
+		return form, nil
+	}
+	form := url.Values{}
+	for k, v := range r.PostForm {
+		form[k] = append([]string(nil), v...)
+	}
+	return form, nil
+}
+
+// newLoginRequest collects the credentials and the information about the client for the backends.
+// The client IP is determined like for the lockout, so that the backends can not be fooled by forged proxy headers.
+func (h *Handler) newLoginRequest(r *http.Request, form url.Values) LoginRequest {
+	return LoginRequest{
+		Username:      form.Get("username"),
+		Password:      form.Get("password"),
+		RemoteIP:      h.clientIP(r),
+		UserAgent:     r.UserAgent(),
+		CorrelationID: logging.GetCorrelationId(r.Header),
+		Form:          form,
 	}
-	return r.PostForm.Get("username"), r.PostForm.Get("password"), nil
 }
 
-func (h *Handler) authenticate(username, password string) (bool, model.UserInfo, error) {
+// authenticate asks the backends in the configured order and handles their errors by the backend policy.
+// It returns the name of the backend, which authenticated the user or caused the error.
+func (h *Handler) authenticate(ctx context.Context, req LoginRequest) (authenticated bool, userInfo model.UserInfo, backend string, err error) {
+	switch h.config.BackendPolicy {
+	case BackendPolicySkipOnError:
+		return h.authenticateSkipOnError(ctx, req)
+	case BackendPolicyFirstSuccess:
+		return h.authenticateFirstSuccess(ctx, req)
+	}
+	return h.authenticateFailFast(ctx, req)
+}
+
+func (h *Handler) authenticateFailFast(ctx context.Context, req LoginRequest) (bool, model.UserInfo, string, error) {
+	var rejection rejection
 	for _, b := range h.backends {
-		authenticated, userInfo, err := b.Authenticate(username, password)
+		authenticated, userInfo, err := ContextAdapter(b.Backend).AuthenticateContext(ctx, req)
+		if rejection.add(err, b.name) {
+			continue
+		}
 		if err != nil {
-			return false, model.UserInfo{}, err
+			return false, model.UserInfo{}, b.name, err
 		}
 		if authenticated {
-			return authenticated, userInfo, nil
+			return authenticated, userInfo, b.name, nil
+		}
+	}
+	return rejection.result()
+}
+
+func (h *Handler) authenticateSkipOnError(ctx context.Context, req LoginRequest) (bool, model.UserInfo, string, error) {
+	var rejection rejection
+	errorCount := 0
+	var firstErr error
+	var firstErrBackend string
+	for _, b := range h.backends {
+		authenticated, userInfo, err := ContextAdapter(b.Backend).AuthenticateContext(ctx, req)
+		if rejection.add(err, b.name) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, model.UserInfo{}, b.name, err
+			}
+			logging.Logger.WithError(err).WithField("backend", b.name).Warn("backend failed, trying the next one")
+			if errorCount == 0 {
+				firstErr, firstErrBackend = err, b.name
+			}
+			errorCount++
+			continue
+		}
+		if authenticated {
+			return authenticated, userInfo, b.name, nil
+		}
+	}
+	if rejection.err != nil {
+		return rejection.result()
+	}
+	return h.noBackendAuthenticated(errorCount, firstErr, firstErrBackend)
+}
+
+func (h *Handler) authenticateFirstSuccess(ctx context.Context, req LoginRequest) (bool, model.UserInfo, string, error) {
+	type result struct {
+		index         int
+		authenticated bool
+		userInfo      model.UserInfo
+		err           error
+	}
+
+	// the slower backends are cancelled after the first success, if they implement ContextBackend.
+	// The others run to their end in the background, but their results are ignored.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered, so that the slower backends do not block after the first success
+	results := make(chan result, len(h.backends))
+	for i, b := range h.backends {
+		go func(i int, b configuredBackend) {
+			authenticated, userInfo, err := ContextAdapter(b.Backend).AuthenticateContext(ctx, req)
+			results <- result{i, authenticated, userInfo, err}
+		}(i, b)
+	}
+
+	var rejection rejection
+	errs := make([]error, len(h.backends))
+	errorCount := 0
+	for range h.backends {
+		r := <-results
+		name := h.backends[r.index].name
+		if rejection.add(r.err, name) {
+			continue
+		}
+		if r.err != nil {
+			logging.Logger.WithError(r.err).WithField("backend", name).Warn("backend failed")
+			errs[r.index] = r.err
+			errorCount++
+			continue
 		}
+		if r.authenticated {
+			return true, r.userInfo, name, nil
+		}
+	}
+
+	if rejection.err != nil {
+		return rejection.result()
+	}
+	for i, err := range errs {
+		if err != nil {
+			return h.noBackendAuthenticated(errorCount, err, h.backends[i].name)
+		}
+	}
+	return false, model.UserInfo{}, "", nil
+}
+
+// rejection keeps the first AuthError of the backends. It is returned,
+// if no other backend authenticates the user, instead of the failed authentication.
+type rejection struct {
+	err     *AuthError
+	backend string
+}
+
+// add returns true, if the error is an AuthError.
+func (r *rejection) add(err error, backend string) bool {
+	authErr, ok := asAuthError(err)
+	if ok && r.err == nil {
+		r.err, r.backend = authErr, backend
+	}
+	return ok
+}
+
+func (r *rejection) result() (bool, model.UserInfo, string, error) {
+	if r.err == nil {
+		return false, model.UserInfo{}, "", nil
 	}
-	return false, model.UserInfo{}, nil
+	return false, model.UserInfo{}, r.backend, r.err
+}
+
+// noBackendAuthenticated returns the first error, if all backends failed. Otherwise the credentials were rejected.
+func (h *Handler) noBackendAuthenticated(errorCount int, firstErr error, backend string) (bool, model.UserInfo, string, error) {
+	if errorCount > 0 && errorCount == len(h.backends) {
+		return false, model.UserInfo{}, backend, firstErr
+	}
+	return false, model.UserInfo{}, "", nil
 }
 
 type oauthManager interface {
@@ -456,3 +1020,39 @@ type oauthManager interface {
 	AddConfig(providerName string, opts map[string]string) error
 	GetConfigFromRequest(r *http.Request) (oauth2.Config, error)
 }
+
+type webauthnManager interface {
+	Handle(w http.ResponseWriter, r *http.Request, path string, session *model.UserInfo) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error)
+}
+
+func newWebauthnManager(config *Config) (webauthnManager, error) {
+	store := config.WebauthnStore
+	if store == nil {
+		if config.WebauthnCredentialFile == "" {
+			return nil, errors.New("the passkey login needs a credential file by -webauthn-credential-file")
+		}
+		var err error
+		store, err = webauthn.NewFileStore(config.WebauthnCredentialFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var origins []string
+	for _, o := range strings.Split(config.WebauthnOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, strings.TrimRight(o, "/"))
+		}
+	}
+
+	return webauthn.NewManager(webauthn.Config{
+		RPID:    config.WebauthnRPID,
+		RPName:  config.WebauthnRPName,
+		Origins: origins,
+		Store:   store,
+	})
+}
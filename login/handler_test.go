@@ -1,6 +1,7 @@
 package login
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -11,6 +12,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -20,6 +23,7 @@ import (
 	. "github.com/stretchr/testify/assert"
 	"github.com/tarent/loginsrv/model"
 	"github.com/tarent/loginsrv/oauth2"
+	"github.com/tarent/loginsrv/webauthn"
 )
 
 const TypeJSON = "Content-Type: application/json"
@@ -217,6 +221,64 @@ func TestHandler_HandleOauth(t *testing.T) {
 	Equal(t, 403, recorder.Code)
 }
 
+func TestHandler_HandleOauth_ProviderErrors(t *testing.T) {
+	managerMock := &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{}, nil
+		},
+	}
+	handler := &Handler{
+		oauth:  managerMock,
+		config: DefaultConfig(),
+	}
+
+	tests := []struct {
+		err             *oauth2.OauthError
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{oauth2.NewProviderError("access_denied", "The user denied the access"), 403, "Login cancelled"},
+		{oauth2.NewProviderError("unauthorized_client", ""), 403, "Not authorized"},
+		{&oauth2.OauthError{Code: oauth2.ErrorCodeStateMismatch}, 400, "Login expired"},
+		{&oauth2.OauthError{Code: oauth2.ErrorCodeMissingCode}, 400, "Login expired"},
+		{oauth2.NewProviderError("server_error", ""), 400, "Login failed"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.err.Code, func(t *testing.T) {
+			managerMock._Handle = func(w http.ResponseWriter, r *http.Request) (
+				startedFlow bool,
+				authenticated bool,
+				userInfo model.UserInfo,
+				err error) {
+				return false, false, model.UserInfo{}, test.err
+			}
+
+			// html
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req("GET", "/login/github", "", AcceptHTML))
+			Equal(t, test.expectedStatus, recorder.Code)
+			Contains(t, recorder.Header().Get("Content-Type"), "text/html")
+			Contains(t, recorder.Body.String(), test.expectedMessage)
+			if test.err.Description != "" {
+				NotContains(t, recorder.Body.String(), test.err.Description)
+			}
+
+			// json
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req("GET", "/login/github", "", "Accept: application/json"))
+			Equal(t, test.expectedStatus, recorder.Code)
+			Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+			output := map[string]string{}
+			NoError(t, json.Unmarshal(recorder.Body.Bytes(), &output))
+			Equal(t, test.err.Code, output["error_code"])
+			_, hasDescription := output["error_description"]
+			False(t, hasDescription)
+			NotEmpty(t, output["error"])
+		})
+	}
+}
+
 func TestHandler_LoginWeb(t *testing.T) {
 	// redirectSuccess
 	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
@@ -366,6 +428,67 @@ func TestHandler_Refresh_Max_Refreshes_Reached(t *testing.T) {
 	Equal(t, 0, len(setCookieList))
 }
 
+func TestHandler_Refresh_ConfirmedByBackend(t *testing.T) {
+	h := testHandler()
+	h.backends = append(h.backends, configuredBackend{"refresh", refreshTestBackend(func(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+		if userInfo.Claims["sid"] != "session-1" {
+			return false, userInfo, nil
+		}
+		userInfo.Name = "Bob Builder"
+		return true, userInfo, nil
+	})})
+
+	input := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix(), Claims: map[string]interface{}{"sid": "session-1"}}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+	Equal(t, 200, recorder.Code)
+
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "Bob Builder", claims["name"])
+	Equal(t, "session-1", claims["sid"])
+	Equal(t, float64(1), claims["refs"])
+}
+
+func TestHandler_Refresh_RejectedByBackend(t *testing.T) {
+	h := testHandler()
+	h.backends = append(h.backends, configuredBackend{"refresh", refreshTestBackend(func(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+		return false, userInfo, nil
+	})})
+
+	input := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+	Equal(t, 403, recorder.Code)
+
+	// the cookie is deleted
+	setCookieList := readSetCookies(recorder.Header())
+	Equal(t, 1, len(setCookieList))
+	Equal(t, "delete", setCookieList[0].Value)
+}
+
+func TestHandler_Refresh_BackendError(t *testing.T) {
+	h := testHandler()
+	h.backends = append(h.backends, configuredBackend{"refresh", refreshTestBackend(func(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+		return false, userInfo, errors.New("test error")
+	})})
+
+	input := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix()}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+	Equal(t, 500, recorder.Code)
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+}
+
 func TestHandler_Logout(t *testing.T) {
 	// DELETE
 	recorder := call(req("DELETE", "/context/login", ""))
@@ -434,6 +557,32 @@ func TestHandler_LoginError(t *testing.T) {
 	Contains(t, recorder.Body.String(), "Internal Error")
 }
 
+func TestHandler_LoginOverloaded(t *testing.T) {
+	h := testHandler()
+	h.config.Backends = Options{"simple": {"bob": "secret"}}
+	h.backends = []configuredBackend{
+		{"busy", contextTestBackend(func(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+			return false, model.UserInfo{}, fmt.Errorf("busy: %w", ErrOverloaded)
+		})},
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 503, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	JSONEq(t, `{"error": "Service Unavailable"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 503, recorder.Code)
+	Contains(t, recorder.Body.String(), "Too many logins at the moment")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm))
+	Equal(t, 503, recorder.Code)
+	Equal(t, "Service Unavailable, please retry later", recorder.Body.String())
+}
+
 func TestHandler_LoginWithEmptyUsername(t *testing.T) {
 	h := testHandler()
 
@@ -527,6 +676,7 @@ func TestHandler_signAndVerify_RSA(t *testing.T) {
 		512,
 	}
 	for _, bits := range tt {
+		bits := bits
 		jwtAlgo := fmt.Sprintf("RS%d", bits)
 		t.Run(jwtAlgo, func(t *testing.T) {
 			t.Parallel()
@@ -643,10 +793,298 @@ func TestHandler_getToken_WithUserClaims(t *testing.T) {
 	Equal(t, "fake", userInfo.Origin)
 }
 
+func TestHandler_NewFromConfig_BackendOrder(t *testing.T) {
+	cfg := testConfig()
+	NoError(t, cfg.addBackendOpts("simple", "bob=secret"))
+	cfg.Backends["fake"] = map[string]string{}
+	RegisterProvider(&ProviderDescription{Name: "fake"}, func(map[string]string) (Backend, error) {
+		return errorTestBackend("test error"), nil
+	})
+	defer delete(provider, "fake")
+
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	Equal(t, 2, len(h.backends))
+	Equal(t, "simple", h.backends[0].name)
+	Equal(t, "fake", h.backends[1].name)
+
+	cfg.BackendPolicy = "foo"
+	_, err = NewHandler(cfg)
+	Error(t, err)
+}
+
+func TestHandler_BackendPolicies(t *testing.T) {
+	simple := configuredBackend{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})}
+	alice := configuredBackend{"alice", NewSimpleBackend(map[string]string{"alice": "secret"})}
+	failing := configuredBackend{"failing", errorTestBackend("test error")}
+
+	tests := []struct {
+		policy        string
+		backends      []configuredBackend
+		username      string
+		authenticated bool
+		backend       string
+		err           bool
+	}{
+		{BackendPolicyFailFast, []configuredBackend{failing, simple}, "bob", false, "failing", true},
+		{BackendPolicyFailFast, []configuredBackend{simple, failing}, "bob", true, "simple", false},
+		{BackendPolicyFailFast, []configuredBackend{alice, simple}, "bob", true, "simple", false},
+		{BackendPolicySkipOnError, []configuredBackend{failing, simple}, "bob", true, "simple", false},
+		{BackendPolicySkipOnError, []configuredBackend{failing, simple}, "unknown", false, "", false},
+		{BackendPolicySkipOnError, []configuredBackend{failing, failing}, "bob", false, "failing", true},
+		{BackendPolicyFirstSuccess, []configuredBackend{failing, simple}, "bob", true, "simple", false},
+		{BackendPolicyFirstSuccess, []configuredBackend{failing, alice, simple}, "alice", true, "alice", false},
+		{BackendPolicyFirstSuccess, []configuredBackend{failing, simple}, "unknown", false, "", false},
+		{BackendPolicyFirstSuccess, []configuredBackend{failing}, "bob", false, "failing", true},
+	}
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			h := testHandler()
+			h.config.BackendPolicy = test.policy
+			h.backends = test.backends
+
+			authenticated, userInfo, backend, err := h.authenticate(context.Background(), LoginRequest{Username: test.username, Password: "secret"})
+			Equal(t, test.authenticated, authenticated)
+			Equal(t, test.backend, backend)
+			Equal(t, test.err, err != nil)
+			if authenticated {
+				Equal(t, test.username, userInfo.Sub)
+			}
+		})
+	}
+}
+
+func TestHandler_AuthenticateContext(t *testing.T) {
+	var given LoginRequest
+	h := testHandler()
+	h.backends = []configuredBackend{
+		{"context", contextTestBackend(func(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+			given = req
+			return req.Password == "secret", model.UserInfo{Sub: req.Username}, nil
+		})},
+	}
+	trustedProxies, err := parseIPNets("192.0.2.1")
+	NoError(t, err)
+	h.trustedProxies = trustedProxies
+
+	// the client IP is taken from the headers of a trusted proxy
+	r := req("POST", "/context/login", "username=bob&password=secret&otp=42", TypeForm, AcceptJwt,
+		"User-Agent: test-agent", "X-Real-Ip: 10.0.0.1", "X-Correlation-Id: abc123")
+	r.RemoteAddr = "192.0.2.1:1234"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Equal(t, LoginRequest{
+		Username:      "bob",
+		Password:      "secret",
+		RemoteIP:      "10.0.0.1",
+		UserAgent:     "test-agent",
+		CorrelationID: "abc123",
+		Form:          url.Values{"username": {"bob"}, "password": {"secret"}, "otp": {"42"}},
+	}, given)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret", "otp": "42"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, url.Values{"username": {"bob"}, "password": {"secret"}, "otp": {"42"}}, given.Form)
+
+	// the headers of other clients are ignored
+	r = req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt,
+		"X-Real-Ip: 10.0.0.1", "X-Forwarded-For: 10.0.0.2")
+	r.RemoteAddr = "192.0.2.2:1234"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Equal(t, "192.0.2.2", given.RemoteIP)
+}
+
+func TestHandler_AuthenticateContext_CancelSlowBackends(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	h := testHandler()
+	h.config.BackendPolicy = BackendPolicyFirstSuccess
+	h.backends = append(h.backends, configuredBackend{"slow", contextTestBackend(func(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+		<-ctx.Done()
+		cancelled <- true
+		return false, model.UserInfo{}, ctx.Err()
+	})})
+
+	authenticated, _, backend, err := h.authenticate(context.Background(), LoginRequest{Username: "bob", Password: "secret"})
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "simple", backend)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("slow backend was not cancelled")
+	}
+}
+
+func TestContextAdapter(t *testing.T) {
+	b := ContextAdapter(passwordTestBackend{"bob": "secret"})
+
+	authenticated, userInfo, err := b.AuthenticateContext(context.Background(), LoginRequest{Username: "bob", Password: "secret"})
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+
+	// a cancelled login does not reach the backend
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	authenticated, _, err = b.AuthenticateContext(ctx, LoginRequest{Username: "bob", Password: "secret"})
+	Equal(t, context.Canceled, err)
+	False(t, authenticated)
+
+	// context backends are used as they are
+	cb := contextTestBackend(nil)
+	IsType(t, cb, ContextAdapter(cb))
+}
+
+func TestHandler_NewFromConfig_Webauthn(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.WebauthnRPID = "example.com"
+
+	_, err := NewHandler(config)
+	Error(t, err)
+
+	config.WebauthnCredentialFile = filepath.Join(os.TempDir(), "loginsrv_handler_passkeys.json")
+	h, err := NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.webauthn)
+
+	config.WebauthnCredentialFile = ""
+	config.WebauthnStore = &webauthn.FileStore{}
+	h, err = NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.webauthn)
+}
+
+func TestHandler_Webauthn(t *testing.T) {
+	mock := &webauthnManagerMock{}
+	h := testHandler()
+	h.config.WebauthnRPID = "example.com"
+	h.webauthn = mock
+
+	// the ceremony steps are answered by the manager
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		w.WriteHeader(201)
+		return true, false, model.UserInfo{}, nil
+	}
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/register/finish", "{}", TypeJSON, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 201, recorder.Code)
+	Equal(t, "/register/finish", mock.path)
+	Equal(t, "bob", mock.session.Sub)
+
+	// no registration for a login by a backend, which can not confirm the user later, e.g. an oauth login
+	mock.path = ""
+	token, err = h.createToken(model.UserInfo{Sub: "carol", Origin: "github", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/register/begin", "{}", TypeJSON, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "github")
+	Equal(t, "", mock.path)
+
+	// no session without a valid token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/register/begin", ""))
+	Nil(t, mock.session)
+
+	// a successful login issues the token for the user resolved by the backend
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, true, model.UserInfo{Sub: "bob", Origin: "simple"}, nil
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "webauthn", claims["origin"])
+
+	// a user, who was removed from the backend, is rejected
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, true, model.UserInfo{Sub: "alice", Origin: "simple"}, nil
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// a user of a backend without lookup, e.g. of an oauth login, can not be confirmed
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, true, model.UserInfo{Sub: "carol", Origin: "github"}, nil
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// as well as a user of a backend, which is not configured any more
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, true, model.UserInfo{Sub: "bob", Origin: "htpasswd"}, nil
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, true, model.UserInfo{Sub: "bob", Origin: "simple"}, nil
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "credential=foo", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+
+	// a rejected login
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, false, model.UserInfo{}, &webauthn.VerificationError{Reason: "invalid signature"}
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// an internal error
+	mock._Handle = func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+		return false, false, model.UserInfo{}, errors.New("store not available")
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/webauthn/login/finish", "{}", TypeJSON, AcceptJwt))
+	Equal(t, 500, recorder.Code)
+}
+
+func TestHandler_Webauthn_LoginForm(t *testing.T) {
+	h := testHandler()
+	h.config.WebauthnRPID = "example.com"
+	h.webauthn = &webauthnManagerMock{}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), "Sign in with passkey")
+	Contains(t, recorder.Body.String(), `action="/context/login/webauthn/login/finish"`)
+	NotContains(t, recorder.Body.String(), "Register passkey")
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	Contains(t, recorder.Body.String(), "Register passkey")
+	Contains(t, recorder.Body.String(), `data-path="/context/login/webauthn"`)
+
+	token, err = h.createToken(model.UserInfo{Sub: "carol", Origin: "github", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	NotContains(t, recorder.Body.String(), "Register passkey")
+}
+
 func testHandler() *Handler {
 	return &Handler{
-		backends: []Backend{
-			NewSimpleBackend(map[string]string{"bob": "secret"}),
+		backends: []configuredBackend{
+			{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})},
 		},
 		oauth:  oauth2.NewManager(),
 		config: testConfig(),
@@ -655,8 +1093,8 @@ func testHandler() *Handler {
 
 func testHandlerWithError() *Handler {
 	return &Handler{
-		backends: []Backend{
-			errorTestBackend("test error"),
+		backends: []configuredBackend{
+			{"error", errorTestBackend("test error")},
 		},
 		oauth:  oauth2.NewManager(),
 		config: testConfig(),
@@ -703,6 +1141,26 @@ func (h errorTestBackend) Authenticate(username, password string) (bool, model.U
 	return false, model.UserInfo{}, errors.New(string(h))
 }
 
+type contextTestBackend func(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error)
+
+func (b contextTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, errors.New("AuthenticateContext has to be used")
+}
+
+func (b contextTestBackend) AuthenticateContext(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+	return b(ctx, req)
+}
+
+type refreshTestBackend func(userInfo model.UserInfo) (bool, model.UserInfo, error)
+
+func (b refreshTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b refreshTestBackend) Refresh(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+	return b(userInfo)
+}
+
 type oauth2ManagerMock struct {
 	_Handle func(w http.ResponseWriter, r *http.Request) (
 		startedFlow bool,
@@ -727,6 +1185,25 @@ func (m *oauth2ManagerMock) GetConfigFromRequest(r *http.Request) (oauth2.Config
 	return m._GetConfigFromRequest(r)
 }
 
+type webauthnManagerMock struct {
+	path    string
+	session *model.UserInfo
+	_Handle func(w http.ResponseWriter, r *http.Request) (
+		responded bool,
+		authenticated bool,
+		userInfo model.UserInfo,
+		err error)
+}
+
+func (m *webauthnManagerMock) Handle(w http.ResponseWriter, r *http.Request, path string, session *model.UserInfo) (
+	responded bool,
+	authenticated bool,
+	userInfo model.UserInfo,
+	err error) {
+	m.path, m.session = path, session
+	return m._Handle(w, r)
+}
+
 // copied from golang: net/http/cookie.go
 // with some simplifications for edge cases
 // readSetCookies parses all "Set-Cookie" values from
@@ -0,0 +1,114 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tarent/loginsrv/lockout"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// newLockout creates the limiter for failed logins, or nil if both limits are disabled.
+func newLockout(config *Config) *lockout.Limiter {
+	if config.LockoutMaxAttempts <= 0 && config.LockoutMaxAttemptsIP <= 0 {
+		return nil
+	}
+	return lockout.NewLimiter(lockout.Config{
+		Store:         config.LockoutStore,
+		MaxAttempts:   config.LockoutMaxAttempts,
+		MaxAttemptsIP: config.LockoutMaxAttemptsIP,
+		Window:        config.LockoutWindow,
+		MaxDelay:      config.LockoutMaxDelay,
+	})
+}
+
+// beginLogin reserves the login attempt of the user, or responds with 429,
+// if the login is delayed because of previous failures or too many parallel attempts.
+// It returns true, if the request was answered. The returned attempt is nil, if the lockout is disabled,
+// and has to be released, if it is neither recorded as failed nor successful.
+func (h *Handler) beginLogin(w http.ResponseWriter, r *http.Request, username string) (*lockout.Attempt, bool) {
+	if h.lockout == nil {
+		return nil, false
+	}
+	attempt, wait, err := h.lockout.Begin(username, h.clientIP(r))
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not read the failed logins")
+		h.respondError(w, r)
+		return nil, true
+	}
+	if wait > 0 {
+		logging.Application(r.Header).
+			WithField("username", username).
+			WithField("retry_after", wait.String()).Warn("rejected login during lockout")
+		h.respondTooManyAttempts(w, r, wait)
+		return nil, true
+	}
+	return attempt, false
+}
+
+// recordFailedLogin counts the failed login and responds with 429, if the next login of the user is delayed.
+// It returns true, if the request was answered.
+func (h *Handler) recordFailedLogin(w http.ResponseWriter, r *http.Request, attempt *lockout.Attempt, username string) bool {
+	if attempt == nil {
+		return false
+	}
+	wait, err := attempt.Failure()
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not record the failed login")
+		h.respondError(w, r)
+		return true
+	}
+	if wait > 0 {
+		logging.Application(r.Header).
+			WithField("username", username).
+			WithField("retry_after", wait.String()).Warn("locked login after failed attempts")
+		h.respondTooManyAttempts(w, r, wait)
+		return true
+	}
+	return false
+}
+
+// resetLockout forgets the failed logins of the user after a successful login.
+func (h *Handler) resetLockout(r *http.Request, attempt *lockout.Attempt) {
+	if attempt == nil {
+		return
+	}
+	if err := attempt.Success(); err != nil {
+		logging.Application(r.Header).WithError(err).Warn("could not reset the failed logins")
+	}
+}
+
+func (h *Handler) respondTooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(429)
+		username, _, _ := getCredentials(r)
+		writeLoginForm(w,
+			loginFormData{
+				RetryAfter: seconds,
+				Config:     h.config,
+				UserInfo:   model.UserInfo{Sub: username},
+			})
+		return
+	}
+
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(429)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":       "Too many failed logins",
+			"retry_after": seconds,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(429)
+	fmt.Fprintf(w, "Too many failed logins, retry after %v seconds", seconds)
+}
@@ -0,0 +1,157 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/lockout"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHandler_Lockout(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	logging.Logger.Out = b
+	defer func() { logging.Logger.Out = os.Stderr }()
+
+	h := testHandler()
+	h.config.Backends = Options{"simple": {"bob": "secret"}}
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttempts: 2})
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// the second failure starts the backoff
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	JSONEq(t, `{"error": "Too many failed logins", "retry_after": 1}`, recorder.Body.String())
+	Contains(t, b.String(), "locked login after failed attempts")
+
+	// even the right password is rejected during the backoff
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 429, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	Contains(t, recorder.Body.String(), "Too many failed logins, please retry in 1 seconds")
+	Contains(t, b.String(), "rejected login during lockout")
+
+	// other users can still login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "alice", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_NewFromConfig_Lockout(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(config)
+	NoError(t, err)
+	Nil(t, h.lockout)
+
+	config.LockoutMaxAttempts = 5
+	h, err = NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.lockout)
+
+	config.LockoutMaxAttempts = 0
+	config.LockoutMaxAttemptsIP = 20
+	h, err = NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.lockout)
+}
+
+func TestHandler_Lockout_TOTP(t *testing.T) {
+	h := testTOTPHandler(t, totpTestStore{"bob": testTOTPSecret})
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttempts: 2})
+
+	// a wrong password is not reset by the right password, before the code was entered
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	token := between(recorder.Body.String(), `"totp_token":"`, `"`)
+	NotEmpty(t, token)
+
+	// a wrong code counts as failed login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", `{"totp_token": "`+token+`", "code": "000000"}`, TypeJSON, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+
+	// no further codes during the backoff
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", `{"totp_token": "`+token+`", "code": "`+totpCode(testTOTPSecret, 0)+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+}
+
+func TestHandler_Lockout_Parallel(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	h := testHandler()
+	h.backends = []configuredBackend{{"test", contextTestBackend(func(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return false, model.UserInfo{}, nil
+	})}}
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttempts: 3})
+
+	// the running attempts are counted, so no more than the allowed wrong passwords can be tried in parallel
+	codes := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+			codes <- recorder.Code
+		}()
+	}
+	for i := 0; i < 7; i++ {
+		Equal(t, 429, <-codes)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-codes
+	}
+	Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 429, recorder.Code)
+	Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestHandler_Lockout_AuthError(t *testing.T) {
+	h := testHandler()
+	h.backends = []configuredBackend{{"test", authErrorTestBackend(AuthErrorAccountLocked)}}
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttempts: 2})
+
+	// the rejections of the backend are counted as failed logins
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), AuthErrorAccountLocked)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 429, recorder.Code)
+}
+
+func TestHandler_Lockout_ReleasedOnError(t *testing.T) {
+	h := testHandlerWithError()
+	h.lockout = lockout.NewLimiter(lockout.Config{MaxAttempts: 1})
+
+	// internal errors are no failed logins, but do not keep the attempt running
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+		Equal(t, 500, recorder.Code)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
 )
 
 const partials = `
@@ -51,6 +52,139 @@ const partials = `
     </style>
 {{end}}
 
+{{define "oauthError"}}
+              {{with .OauthError}}
+                <div class="alert alert-warning" role="alert">
+                  {{if eq .Code "access_denied"}}
+                    <strong>Login cancelled. </strong> The access was not granted at the login provider.
+                  {{else if eq .Code "not_authorized"}}
+                    <strong>Not authorized. </strong> The login provider refused the authorization.
+                  {{else if or (eq .Code "state_mismatch") (eq .Code "missing_code")}}
+                    <strong>Login expired. </strong> The login request could not be verified, please try again.
+                  {{else}}
+                    <strong>Login failed. </strong> The login provider reported an error.
+                  {{end}}
+                </div>
+              {{end}}
+{{end}}
+
+{{define "authError"}}
+                      {{with .AuthError}}
+                        <div class="alert alert-warning" role="alert">
+                          {{if eq .Code "account_locked"}}
+                            <strong>Account locked. </strong> Please contact your administrator.
+                          {{else if eq .Code "password_expired"}}
+                            <strong>Password expired. </strong> Please renew your password.
+                          {{else if eq .Code "must_change_password"}}
+                            <strong>Password change required. </strong> Please change your password, before you login.
+                          {{else if eq .Code "mfa_required"}}
+                            <strong>Second factor required. </strong> The login needs a second factor.
+                          {{else}}
+                            <strong>Login rejected. </strong>
+                          {{end}}
+                        </div>
+                      {{end}}
+{{end}}
+
+{{define "webauthnScript"}}
+    <script>
+      (function() {
+        if (!window.PublicKeyCredential || !window.fetch) {
+          return;
+        }
+        function encode(buffer) {
+          return btoa(String.fromCharCode.apply(null, new Uint8Array(buffer))).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+        }
+        function decode(value) {
+          value = value.replace(/-/g, '+').replace(/_/g, '/');
+          while (value.length % 4) {
+            value += '=';
+          }
+          return Uint8Array.from(atob(value), function(c) { return c.charCodeAt(0); });
+        }
+        function post(url, body) {
+          return fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: {'Content-Type': 'application/json', 'Accept': 'application/json'},
+            body: body ? JSON.stringify(body) : ''
+          }).then(function(res) {
+            return res.json().then(function(json) {
+              if (!res.ok) {
+                throw new Error(json.error || res.statusText);
+              }
+              return json;
+            });
+          });
+        }
+        function showStatus(message, success) {
+          var status = document.getElementById('webauthn-status');
+          status.className = 'alert ' + (success ? 'alert-success' : 'alert-warning');
+          status.textContent = message;
+        }
+
+        var login = document.getElementById('webauthn-login');
+        if (login) {
+          login.style.display = '';
+          login.addEventListener('click', function() {
+            var path = login.getAttribute('data-path');
+            post(path + '/login/begin').then(function(options) {
+              var publicKey = options.publicKey;
+              publicKey.challenge = decode(publicKey.challenge);
+              publicKey.allowCredentials.forEach(function(c) { c.id = decode(c.id); });
+              return navigator.credentials.get({publicKey: publicKey});
+            }).then(function(credential) {
+              var form = document.getElementById('webauthn-login-form');
+              form.credential.value = JSON.stringify({
+                id: credential.id,
+                rawId: encode(credential.rawId),
+                type: credential.type,
+                response: {
+                  clientDataJSON: encode(credential.response.clientDataJSON),
+                  authenticatorData: encode(credential.response.authenticatorData),
+                  signature: encode(credential.response.signature),
+                  userHandle: credential.response.userHandle ? encode(credential.response.userHandle) : ''
+                }
+              });
+              form.submit();
+            }).catch(function(err) {
+              showStatus('Passkey login failed: ' + err.message, false);
+            });
+          });
+        }
+
+        var register = document.getElementById('webauthn-register');
+        if (register) {
+          register.style.display = '';
+          register.addEventListener('click', function() {
+            var path = register.getAttribute('data-path');
+            post(path + '/register/begin').then(function(options) {
+              var publicKey = options.publicKey;
+              publicKey.challenge = decode(publicKey.challenge);
+              publicKey.user.id = decode(publicKey.user.id);
+              publicKey.excludeCredentials.forEach(function(c) { c.id = decode(c.id); });
+              return navigator.credentials.create({publicKey: publicKey});
+            }).then(function(credential) {
+              return post(path + '/register/finish', {
+                id: credential.id,
+                rawId: encode(credential.rawId),
+                type: credential.type,
+                response: {
+                  clientDataJSON: encode(credential.response.clientDataJSON),
+                  attestationObject: encode(credential.response.attestationObject)
+                }
+              });
+            }).then(function() {
+              showStatus('Passkey registered', true);
+            }).catch(function(err) {
+              showStatus('Passkey registration failed: ' + err.message, false);
+            });
+          });
+        }
+      })();
+    </script>
+{{end}}
+
 {{define "userInfo"}}
               {{with .UserInfo}}
                 <h1>Welcome {{.Sub}}!</h1>
@@ -60,6 +194,129 @@ const partials = `
               {{end}}
               <br/>
               <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true">Logout</a>
+              {{if and .Config.WebauthnRPID .CanRegisterPasskey}}
+                <button id="webauthn-register" class="btn btn-md btn-default" type="button" data-path="{{ trimRight .Config.LoginPath "/" }}/webauthn" style="display: none">
+                  <span class="fa fa-key"></span> Register passkey
+                </button>
+                <div id="webauthn-status"></div>
+              {{end}}
+              {{if .CanChangePassword}}
+                <a class="btn btn-md btn-default" href="{{ trimRight .Config.LoginPath "/" }}/password">
+                  <span class="fa fa-lock"></span> Change password
+                </a>
+              {{end}}
+              {{if or .Config.TOTPSecretFile .Config.TOTPStore}}
+                <a class="btn btn-md btn-default" href="{{ trimRight .Config.LoginPath "/" }}/totp/enroll">
+                  <span class="fa fa-mobile"></span> Set up authenticator app
+                </a>
+              {{end}}
+{{end}}
+
+{{define "passwordChange"}}
+              <div class="panel panel-default">
+                <div class="panel-heading">
+                  <div class="panel-title">
+                    <h4>Change password of {{.UserInfo.Sub}}</h4>
+                    {{with .PasswordChange}}
+                      {{if .Changed}}<div class="alert alert-success" role="alert">Your password has been changed.</div>{{end}}
+                      {{if .WrongPassword}}<div class="alert alert-warning" role="alert">The current password is wrong.</div>{{end}}
+                      {{range .Violations}}<div class="alert alert-warning" role="alert">{{.}}</div>{{end}}
+                      {{if .Overloaded}}<div class="alert alert-warning" role="alert">Too many requests at the moment, please try again</div>{{end}}
+                    {{end}}
+                  </div>
+                </div>
+                <div class="panel-body">
+                  {{if .PasswordChange.Changed}}
+                    <a class="btn btn-lg btn-primary btn-block" href="{{.Config.LoginPath}}">Back</a>
+                  {{else}}
+                    <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/password">
+                      <fieldset>
+                        <div class="form-group">
+                          <input class="form-control" placeholder="Current password" name="current_password" type="password" autocomplete="current-password" value="">
+                        </div>
+                        <div class="form-group">
+                          <input class="form-control" placeholder="New password" name="new_password" type="password" autocomplete="new-password" value="">
+                        </div>
+                        <div class="form-group">
+                          <input class="form-control" placeholder="Repeat new password" name="new_password_confirm" type="password" autocomplete="new-password" value="">
+                        </div>
+                        <input class="btn btn-lg btn-success btn-block" type="submit" value="Change password">
+                      </fieldset>
+                    </form>
+                  {{end}}
+                </div>
+              </div>
+{{end}}
+
+{{define "totpCode"}}
+              <div class="panel panel-default">
+                <div class="panel-heading">
+                  <div class="panel-title">
+                    <h4>Verification code</h4>
+                    {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid code</div>{{end}}
+                  </div>
+                </div>
+                <div class="panel-body">
+                  <p>Enter the code of your authenticator app for {{.UserInfo.Sub}}.</p>
+                  <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/totp">
+                    <fieldset>
+                      <input type="hidden" name="totp_token" value="{{.TOTPToken}}">
+                      <div class="form-group">
+                        <input class="form-control" placeholder="Code" name="code" type="text" inputmode="numeric" autocomplete="one-time-code" autofocus value="">
+                      </div>
+                      <input class="btn btn-lg btn-success btn-block" type="submit" value="Verify">
+                    </fieldset>
+                  </form>
+                </div>
+              </div>
+{{end}}
+
+{{define "totpEnroll"}}
+              <div class="panel panel-default">
+                <div class="panel-heading">
+                  <div class="panel-title">
+                    <h4>Set up authenticator app</h4>
+                    {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid code</div>{{end}}
+                  </div>
+                </div>
+                <div class="panel-body">
+                  {{if .TOTPEnrollment.Enrolled}}
+                    <p>An authenticator app is already set up for {{.UserInfo.Sub}}. To replace it, ask your administrator to reset it.</p>
+                    <a class="btn btn-lg btn-primary btn-block" href="{{.Config.LoginPath}}">Back</a>
+                  {{else}}
+                    <p>Scan the QR code with your authenticator app and enter the code shown by the app.</p>
+                    <img src="{{.TOTPEnrollment.QRCode}}" alt="QR code" width="200" height="200">
+                    <p><small>Secret: <code>{{.TOTPEnrollment.Secret}}</code></small></p>
+                    <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/totp/enroll">
+                      <fieldset>
+                        <input type="hidden" name="secret" value="{{.TOTPEnrollment.Secret}}">
+                        <div class="form-group">
+                          <input class="form-control" placeholder="Code" name="code" type="text" inputmode="numeric" autocomplete="one-time-code" value="">
+                        </div>
+                        <input class="btn btn-lg btn-success btn-block" type="submit" value="Enable">
+                      </fieldset>
+                    </form>
+                  {{end}}
+                </div>
+              </div>
+{{end}}
+
+{{define "magicLinkConfirm"}}
+              <div class="panel panel-default">
+                <div class="panel-heading">
+                  <div class="panel-title">
+                    <h4>Sign in by login link</h4>
+                  </div>
+                </div>
+                <div class="panel-body">
+                  <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/magiclink">
+                    <fieldset>
+                      <input type="hidden" name="token" value="{{.MagicLinkToken}}">
+                      <input class="btn btn-lg btn-success btn-block" type="submit" value="Sign in">
+                    </fieldset>
+                  </form>
+                </div>
+              </div>
 {{end}}
 
 {{define "login"}}
@@ -69,7 +326,46 @@ const partials = `
                 </a>
               {{end}}
 
-              {{if and (not (eq (len .Config.Backends) 0)) (not (eq (len .Config.Oauth) 0))}}
+              {{if .Config.WebauthnRPID}}
+                <form id="webauthn-login-form" accept-charset="UTF-8" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/webauthn/login/finish">
+                  <input type="hidden" name="credential" value="">
+                  <button id="webauthn-login" class="btn btn-block btn-lg btn-default" type="button" data-path="{{ trimRight .Config.LoginPath "/" }}/webauthn" style="display: none">
+                    <span class="fa fa-key"></span> Sign in with passkey
+                  </button>
+                </form>
+                <div id="webauthn-status"></div>
+              {{end}}
+
+              {{if or .Config.MagicLinkSMTP .Config.MagicLinkMailer}}
+                {{if .MagicLinkSent}}
+                  <div class="alert alert-success" role="alert">If the address may login, a login link has been sent to it.</div>
+                {{end}}
+                {{if .MagicLinkInvalid}}
+                  <div class="alert alert-warning" role="alert">The login link is invalid or expired, please request a new one.</div>
+                {{end}}
+                <form accept-charset="UTF-8" role="form" method="POST" action="{{ trimRight .Config.LoginPath "/" }}/magiclink">
+                  <div class="input-group">
+                    <input class="form-control input-lg" placeholder="Email" name="email" type="email" value="">
+                    <span class="input-group-btn">
+                      <button class="btn btn-lg btn-default" type="submit">
+                        <span class="fa fa-envelope"></span> Email me a login link
+                      </button>
+                    </span>
+                  </div>
+                </form>
+                <br/>
+              {{end}}
+
+              {{if .Config.ClientCertLogin}}
+                {{if and .Failure (eq (len .Config.Backends) 0)}}<div class="alert alert-warning" role="alert">No valid certificate</div>{{end}}
+                <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}">
+                  <button class="btn btn-block btn-lg btn-default" type="submit">
+                    <span class="fa fa-id-card"></span> Sign in with certificate
+                  </button>
+                </form>
+              {{end}}
+
+              {{if and (not (eq (len .Config.Backends) 0)) (or (not (eq (len .Config.Oauth) 0)) .Config.ClientCertLogin .Config.WebauthnRPID .Config.MagicLinkSMTP .Config.MagicLinkMailer)}}
                 <div class="login-or-container">
                   <hr class="login-or-hr">
                   <div class="login-or lead">or</div>
@@ -81,7 +377,10 @@ const partials = `
   	          <div class="panel-heading">  
   		    <div class="panel-title">
   		      <h4>Sign in</h4>
-                      {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid credentials</div>{{end}} 
+                      {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid credentials</div>{{end}}
+                      {{template "authError" .}}
+                      {{ if .RetryAfter}}<div class="alert alert-warning" role="alert">Too many failed logins, please retry in {{.RetryAfter}} seconds</div>{{end}}
+                      {{ if .Overloaded}}<div class="alert alert-warning" role="alert">Too many logins at the moment, please try again</div>{{end}}
 		    </div>
 	          </div>
 	          <div class="panel-body">
@@ -119,7 +418,25 @@ var layout = `<!DOCTYPE html>
               </div>
             {{end}}
 
-            {{if .Authenticated}}
+            {{template "oauthError" . }}
+
+            {{if .PasswordChange}}
+
+              {{template "passwordChange" . }}
+
+            {{else if .TOTPEnrollment}}
+
+              {{template "totpEnroll" . }}
+
+            {{else if .TOTPToken}}
+
+              {{template "totpCode" . }}
+
+            {{else if .MagicLinkToken}}
+
+              {{template "magicLinkConfirm" . }}
+
+            {{else if .Authenticated}}
 
               {{template "userInfo" . }}
 
@@ -132,15 +449,37 @@ var layout = `<!DOCTYPE html>
 	</div>
       </div>
     </uic-fragment>
+    {{if .Config.WebauthnRPID}}{{template "webauthnScript" . }}{{end}}
   </body>
 </html>`
 
 type loginFormData struct {
-	Error         bool
-	Failure       bool
-	Config        *Config
-	Authenticated bool
-	UserInfo      model.UserInfo
+	Error              bool
+	Failure            bool
+	OauthError         *oauth2.OauthError
+	Config             *Config
+	Authenticated      bool
+	UserInfo           model.UserInfo
+	TOTPToken          string
+	TOTPEnrollment     *totpEnrollment
+	MagicLinkToken     string
+	MagicLinkSent      bool
+	MagicLinkInvalid   bool
+	CanChangePassword  bool
+	CanRegisterPasskey bool
+	RetryAfter         int
+	Overloaded         bool
+	AuthError          *AuthError
+	PasswordChange     *passwordChangeData
+}
+
+// totpEnrollment is the new secret of a user on the enrolment page
+type totpEnrollment struct {
+	Secret string
+	QRCode template.URL
+
+	// Enrolled is set, if the user already has a secret, which can not be replaced
+	Enrolled bool
 }
 
 func writeLoginForm(w http.ResponseWriter, params loginFormData) {
@@ -0,0 +1,278 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/lockout"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/magiclink"
+	"github.com/tarent/loginsrv/model"
+)
+
+// magicLinkQueueSize is the number of login links, which can wait to be sent.
+// Further requests are answered like the others, but no mail is sent.
+const magicLinkQueueSize = 100
+
+// magicLinkMail is a login link, which waits to be sent.
+type magicLinkMail struct {
+	email  string
+	header http.Header
+}
+
+// magicLinkLogin sends login links to the allowed email addresses.
+type magicLinkLogin struct {
+	manager  *magiclink.Manager
+	allow    []string
+	userFile *userClaimsFile
+	linkURL  string
+
+	// sent holds the times of the mails to each address, to limit them to maxMails within the window
+	sent     *lockout.MemoryStore
+	maxMails int
+	window   time.Duration
+
+	// queue holds the mails for the sender, so that the response does not depend on the mail server
+	queue chan magicLinkMail
+}
+
+func newMagicLinkLogin(config *Config) (*magicLinkLogin, error) {
+	mailer := config.MagicLinkMailer
+	if mailer == nil {
+		if config.MagicLinkFrom == "" {
+			return nil, errors.New("the magic link login needs a sender address by -magiclink-from")
+		}
+		mailer = &magiclink.SMTPMailer{
+			Addr:     config.MagicLinkSMTP,
+			Username: config.MagicLinkSMTPUser,
+			Password: config.MagicLinkSMTPPassword,
+			From:     config.MagicLinkFrom,
+		}
+	}
+
+	// the link is not built from the request, because the Host and X-Forwarded-Host headers
+	// are set by the client, who could direct the link with a valid token to a host of their own
+	u, err := url.Parse(config.MagicLinkURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.New("the magic link login needs the external url of the links by -magiclink-url")
+	}
+
+	l := &magicLinkLogin{
+		linkURL:  config.MagicLinkURL,
+		sent:     lockout.NewMemoryStore(),
+		maxMails: config.MagicLinkMaxMails,
+		window:   config.MagicLinkExpiry,
+		queue:    make(chan magicLinkMail, magicLinkQueueSize),
+	}
+	if l.window == 0 {
+		l.window = magiclink.DefaultExpiry
+	}
+	for _, a := range strings.Split(config.MagicLinkAllow, ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			l.allow = append(l.allow, a)
+		}
+	}
+	if config.UserFile != "" {
+		l.userFile, err = newUserClaimsFile(config.UserFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(l.allow) == 0 && l.userFile == nil {
+		return nil, errors.New("the magic link login needs allowed addresses by -magiclink-allow or -user-file")
+	}
+
+	// the links are signed with a key derived from the jwt secret,
+	// so that they can not be used as jwt and vice versa
+	mac := hmac.New(sha256.New, []byte(config.JwtSecret))
+	mac.Write([]byte("loginsrv magic link"))
+
+	l.manager, err = magiclink.NewManager(magiclink.Config{
+		Key:    mac.Sum(nil),
+		Expiry: config.MagicLinkExpiry,
+		Mailer: mailer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go l.sendMails()
+	return l, nil
+}
+
+// enqueue queues the login link for the address and returns false, if the queue is full.
+func (l *magicLinkLogin) enqueue(email string, header http.Header) bool {
+	select {
+	case l.queue <- magicLinkMail{email: email, header: header.Clone()}:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendMails sends the queued login links.
+func (l *magicLinkLogin) sendMails() {
+	for m := range l.queue {
+		if err := l.manager.Send(m.email, l.linkURL); err != nil {
+			logging.Application(m.header).WithError(err).WithField("username", m.email).Error("failed to send login link")
+			continue
+		}
+		logging.Application(m.header).WithField("username", m.email).Info("sent login link")
+	}
+}
+
+// allowed returns true, if the address is in the allow list, its domain is allowed by an @domain entry
+// or the user file has an entry with the address as email or sub.
+func (l *magicLinkLogin) allowed(email string) bool {
+	email = strings.ToLower(email)
+	for _, a := range l.allow {
+		if a == email || (strings.HasPrefix(a, "@") && strings.HasSuffix(email, a)) {
+			return true
+		}
+	}
+	if l.userFile != nil {
+		for _, entry := range l.userFile.userFileEntries {
+			if strings.EqualFold(entry.Email, email) || strings.EqualFold(entry.Sub, email) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tooManyMails records a requested mail to the address and returns true,
+// if more than maxMails were requested within the window.
+func (l *magicLinkLogin) tooManyMails(email string) bool {
+	if l.maxMails <= 0 {
+		return false
+	}
+	now := time.Now()
+	requests, _ := l.sent.Add(strings.ToLower(email), now, now.Add(-l.window)) // the memory store never fails
+	return len(requests) > l.maxMails
+}
+
+// magicLinkPath is the path to request and use the login links below the login path.
+func (h *Handler) magicLinkPath() string {
+	return strings.TrimRight(h.config.LoginPath, "/") + "/magiclink"
+}
+
+// handleMagicLink shows the confirmation page of a link on GET.
+// Only the POST of the confirmation uses the link, because mail scanners and link previews follow the links by GET.
+// A POST without token requests a new link.
+func (h *Handler) handleMagicLink(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", contentTypeHTML)
+		writeLoginForm(w,
+			loginFormData{
+				Config:         h.config,
+				MagicLinkToken: r.URL.Query().Get("token"),
+			})
+	case "POST":
+		email, token, err := getMagicLinkForm(r)
+		if err != nil {
+			h.respondBadRequest(w, r)
+			return
+		}
+		if token != "" {
+			h.handleMagicLinkUse(w, r, token)
+			return
+		}
+		h.handleMagicLinkRequest(w, r, email)
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+// handleMagicLinkRequest queues a login link to the posted email address, if it is allowed.
+// To not disclose the allowed addresses, the response is the same in all cases, even if the mail can not be sent.
+func (h *Handler) handleMagicLinkRequest(w http.ResponseWriter, r *http.Request, email string) {
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	if !h.magicLink.allowed(address.Address) {
+		logging.Application(r.Header).WithField("username", address.Address).Info("login link requested for an address, which is not allowed")
+	} else if h.magicLink.tooManyMails(address.Address) {
+		logging.Application(r.Header).WithField("username", address.Address).Warn("login link not sent, too many links requested for the address")
+	} else if !h.magicLink.enqueue(address.Address, r.Header) {
+		logging.Application(r.Header).WithField("username", address.Address).Warn("login link not sent, too many links waiting to be sent")
+	}
+
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		writeLoginForm(w,
+			loginFormData{
+				Config:        h.config,
+				MagicLinkSent: true,
+			})
+		return
+	}
+	if wantJSON(r) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(202)
+		fmt.Fprintf(w, `{"status": "sent"}`)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypePlain)
+	w.WriteHeader(202)
+	fmt.Fprintf(w, "Login link sent")
+}
+
+// handleMagicLinkUse issues the token for the token of a valid link.
+func (h *Handler) handleMagicLinkUse(w http.ResponseWriter, r *http.Request, token string) {
+	email, err := h.magicLink.manager.Verify(token)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Info("failed login link authentication")
+		if wantHTML(r) {
+			w.Header().Set("Content-Type", contentTypeHTML)
+			w.WriteHeader(403)
+			writeLoginForm(w,
+				loginFormData{
+					Config:           h.config,
+					MagicLinkInvalid: true,
+				})
+			return
+		}
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	logging.Application(r.Header).
+		WithField("username", email).
+		WithField("backend", magiclink.Origin).Info("successfully authenticated")
+	h.respondAuthenticated(w, r, model.UserInfo{
+		Sub:    email,
+		Email:  email,
+		Origin: magiclink.Origin,
+	})
+}
+
+// getMagicLinkForm returns the email address of a link request or the token of a confirmed link.
+func getMagicLinkForm(r *http.Request) (email, token string, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		m := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", "", err
+		}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return "", "", err
+		}
+		return m["email"], m["token"], nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return "", "", err
+	}
+	return r.PostForm.Get("email"), r.PostForm.Get("token"), nil
+}
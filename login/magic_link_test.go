@@ -0,0 +1,307 @@
+package login
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// magicLinkTestMailer records the mails, which are sent in the background
+type magicLinkTestMailer struct {
+	mu      sync.Mutex
+	to      []string
+	body    string
+	err     error
+	sent    chan struct{}
+	blocked chan struct{}
+}
+
+func newMagicLinkTestMailer() *magicLinkTestMailer {
+	return &magicLinkTestMailer{sent: make(chan struct{}, 1000)}
+}
+
+func (m *magicLinkTestMailer) Send(to, subject, body string) error {
+	if m.blocked != nil {
+		<-m.blocked
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.to = append(m.to, to)
+	m.body = body
+	m.sent <- struct{}{}
+	return m.err
+}
+
+// wait waits for the next mail and returns all recipients
+func (m *magicLinkTestMailer) wait(t *testing.T) []string {
+	select {
+	case <-m.sent:
+	case <-time.After(time.Second):
+		t.Error("no mail sent")
+	}
+	return m.recipients()
+}
+
+// none asserts, that no further mail is sent
+func (m *magicLinkTestMailer) none(t *testing.T) {
+	select {
+	case <-m.sent:
+		t.Error("unexpected mail sent")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func (m *magicLinkTestMailer) recipients() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.to...)
+}
+
+// link returns the path and query of the link in the last mail
+func (m *magicLinkTestMailer) link() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return regexp.MustCompile(`https?://\S+`).FindString(m.body)
+}
+
+// useMagicLink confirms the link like the form of its confirmation page
+func useMagicLink(h *Handler, link string, headers ...string) *httptest.ResponseRecorder {
+	u, _ := url.Parse(link)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", link, url.Values{"token": {u.Query().Get("token")}}.Encode(), append(headers, TypeForm)...))
+	return recorder
+}
+
+func testMagicLinkHandler(t *testing.T) (*Handler, *magicLinkTestMailer) {
+	mailer := newMagicLinkTestMailer()
+	config := testConfig()
+	config.Backends = Options{}
+	config.MagicLinkMailer = mailer
+	config.MagicLinkAllow = "@example.com, Carol@Example.org"
+	config.MagicLinkURL = "https://login.example.com/context/login/magiclink"
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h, mailer
+}
+
+func TestHandler_MagicLink_JSON(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "http://example.com/context/login/magiclink", `{"email": "bob@example.com"}`, TypeJSON, "Accept: application/json"))
+	Equal(t, 202, recorder.Code)
+	JSONEq(t, `{"status": "sent"}`, recorder.Body.String())
+	Equal(t, []string{"bob@example.com"}, mailer.wait(t))
+
+	link := mailer.link()
+	True(t, strings.HasPrefix(link, "https://login.example.com/context/login/magiclink?token="), link)
+	u, _ := url.Parse(link)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", `{"token": "`+u.Query().Get("token")+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob@example.com", claims["sub"])
+	Equal(t, "bob@example.com", claims["email"])
+	Equal(t, "magiclink", claims["origin"])
+
+	// the link can be used only once
+	Equal(t, 403, useMagicLink(h, link, AcceptJwt).Code)
+}
+
+func TestHandler_MagicLink_Allowed(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+
+	for _, test := range []struct {
+		email   string
+		allowed bool
+	}{
+		{"bob@example.com", true},
+		{"Bob Builder <BOB@EXAMPLE.COM>", true},
+		{"carol@example.org", true},
+		{"alice@example.org", false},
+		{"bob@example.com.evil.org", false},
+	} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", url.Values{"email": {test.email}}.Encode(), TypeForm))
+		Equal(t, 202, recorder.Code, test.email)
+		Equal(t, "Login link sent", recorder.Body.String())
+		if test.allowed {
+			mailer.wait(t)
+		} else {
+			mailer.none(t)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", url.Values{"email": {"no address"}}.Encode(), TypeForm))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_MagicLink_UserFile(t *testing.T) {
+	f, _ := ioutil.TempFile("", "loginsrv_users")
+	f.WriteString("- sub: dave@example.org\n- email: erin@example.org\n  claims:\n    role: admin\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	mailer := newMagicLinkTestMailer()
+	config := testConfig()
+	config.Backends = Options{}
+	config.MagicLinkMailer = mailer
+	config.UserFile = f.Name()
+	config.MagicLinkURL = "https://login.example.com/context/login/magiclink"
+	h, err := NewHandler(config)
+	NoError(t, err)
+
+	for _, email := range []string{"dave@example.org", "erin@example.org", "frank@example.org"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", url.Values{"email": {email}}.Encode(), TypeForm))
+		Equal(t, 202, recorder.Code)
+	}
+	mailer.wait(t)
+	Equal(t, []string{"dave@example.org", "erin@example.org"}, mailer.wait(t))
+	mailer.none(t)
+
+	// the claims of the user file are added to the token
+	recorder := useMagicLink(h, mailer.link(), AcceptJwt)
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "admin", claims["role"])
+}
+
+func TestHandler_MagicLink_HTML(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML))
+	Contains(t, recorder.Body.String(), `action="/context/login/magiclink"`)
+	Contains(t, recorder.Body.String(), "Email me a login link")
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", url.Values{"email": {"bob@example.com"}}.Encode(), TypeForm, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "a login link has been sent")
+
+	mailer.wait(t)
+	link := mailer.link()
+	True(t, strings.HasPrefix(link, "https://login.example.com/context/login/magiclink?token="), link)
+	u, _ := url.Parse(link)
+
+	// opening the link only shows the confirmation, e.g. for mail scanners
+	for i := 0; i < 2; i++ {
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", link, "", AcceptHTML))
+		Equal(t, 200, recorder.Code)
+		Empty(t, recorder.Header().Get("Set-Cookie"))
+		Contains(t, recorder.Body.String(), `action="/context/login/magiclink"`)
+		Contains(t, recorder.Body.String(), `name="token" value="`+u.Query().Get("token")+`"`)
+	}
+
+	recorder = useMagicLink(h, link, AcceptHTML)
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+	Contains(t, recorder.Header().Get("Set-Cookie"), h.config.CookieName+"=")
+
+	recorder = useMagicLink(h, link, AcceptHTML)
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "The login link is invalid or expired")
+}
+
+func TestHandler_MagicLink_IgnoresRequestHost(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "http://evil.example/context/login/magiclink", `{"email": "bob@example.com"}`, TypeJSON,
+		"X-Forwarded-Host: evil.example", "X-Forwarded-Proto: http"))
+	Equal(t, 202, recorder.Code)
+	mailer.wait(t)
+	True(t, strings.HasPrefix(mailer.link(), "https://login.example.com/context/login/magiclink?token="), mailer.link())
+}
+
+func TestHandler_MagicLink_MaxMails(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+
+	for i := 0; i < 5; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", `{"email": "Bob@example.com"}`, TypeJSON))
+		// the response does not tell, whether the mail was sent
+		Equal(t, 202, recorder.Code)
+	}
+	mailer.wait(t)
+	mailer.wait(t)
+	Equal(t, 3, len(mailer.wait(t)))
+	mailer.none(t)
+
+	// other addresses are not affected
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", `{"email": "alice@example.com"}`, TypeJSON))
+	Equal(t, 202, recorder.Code)
+	Equal(t, 4, len(mailer.wait(t)))
+}
+
+func TestHandler_MagicLink_MailError(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+	mailer.err = errors.New("smtp error")
+
+	// the response does not depend on the mail server
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", `{"email": "bob@example.com"}`, TypeJSON))
+	Equal(t, 202, recorder.Code)
+	mailer.wait(t)
+}
+
+func TestHandler_MagicLink_SlowMailServer(t *testing.T) {
+	h, mailer := testMagicLinkHandler(t)
+	h.magicLink.maxMails = 0
+	mailer.blocked = make(chan struct{})
+
+	// the requests are answered alike, while the mails wait or even are dropped
+	for i := 0; i < magicLinkQueueSize+10; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login/magiclink", `{"email": "bob@example.com"}`, TypeJSON, "Accept: application/json"))
+		Equal(t, 202, recorder.Code)
+		JSONEq(t, `{"status": "sent"}`, recorder.Body.String())
+	}
+	close(mailer.blocked)
+	mailer.wait(t)
+}
+
+func TestHandler_NewFromConfig_MagicLink(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{}
+	config.MagicLinkSMTP = "localhost:25"
+	config.MagicLinkAllow = "@example.com"
+	config.MagicLinkURL = "https://login.example.com/login/magiclink"
+
+	_, err := NewHandler(config)
+	Error(t, err)
+
+	config.MagicLinkFrom = "loginsrv@example.com"
+	config.MagicLinkURL = ""
+	_, err = NewHandler(config)
+	Error(t, err)
+
+	config.MagicLinkURL = "/login/magiclink"
+	_, err = NewHandler(config)
+	Error(t, err)
+
+	config.MagicLinkURL = "https://login.example.com/login/magiclink"
+	h, err := NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.magicLink)
+
+	config.MagicLinkAllow = ""
+	_, err = NewHandler(config)
+	Error(t, err)
+}
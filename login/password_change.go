@@ -0,0 +1,165 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// passwordChangeData is the state of the password change page
+type passwordChangeData struct {
+	Changed       bool
+	WrongPassword bool
+	Violations    []string
+	Overloaded    bool
+}
+
+// passwordPath is the path of the password change below the login path.
+func (h *Handler) passwordPath() string {
+	return strings.TrimRight(h.config.LoginPath, "/") + "/password"
+}
+
+// passwordChangeBackend returns the backend, which issued the token of the user, if it supports password changes.
+func (h *Handler) passwordChangeBackend(userInfo model.UserInfo) PasswordChangeBackend {
+	for _, b := range h.backends {
+		if pb, ok := b.Backend.(PasswordChangeBackend); ok && b.name == userInfo.Origin {
+			return pb
+		}
+	}
+	return nil
+}
+
+// supportsPasswordChange returns true, if any of the backends supports password changes.
+func (h *Handler) supportsPasswordChange() bool {
+	for _, b := range h.backends {
+		if _, ok := b.Backend.(PasswordChangeBackend); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	session, valid := h.GetToken(r)
+	if !valid {
+		h.respondAuthFailure(w, r)
+		return
+	}
+	backend := h.passwordChangeBackend(session)
+	if backend == nil {
+		h.respondPasswordChange(w, r, session, 400, passwordChangeData{Violations: []string{"The password of this login can not be changed."}})
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		h.respondPasswordChange(w, r, session, 200, passwordChangeData{})
+		return
+	case "POST":
+	default:
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	values, err := getPasswordChangeValues(r)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+	current, newPassword := values["current_password"], values["new_password"]
+
+	violations := h.passwordPolicy.check(session.Sub, current, newPassword)
+	if confirm, exist := values["new_password_confirm"]; exist && confirm != newPassword {
+		violations = append(violations, "The new passwords do not match.")
+	}
+	if len(violations) > 0 {
+		h.respondPasswordChange(w, r, session, 400, passwordChangeData{Violations: violations})
+		return
+	}
+
+	changed, err := backend.ChangePassword(session.Sub, current, newPassword)
+	if errors.Is(err, ErrOverloaded) {
+		logging.Application(r.Header).WithError(err).WithField("username", session.Sub).Warn("rejected password change, backend overloaded")
+		w.Header().Set("Retry-After", strconv.Itoa(overloadedRetryAfter))
+		h.respondPasswordChange(w, r, session, 503, passwordChangeData{Overloaded: true})
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).WithField("username", session.Sub).Error("password change failed")
+		h.respondError(w, r)
+		return
+	}
+	if !changed {
+		logging.Application(r.Header).WithField("username", session.Sub).Info("password change with wrong current password")
+		h.respondPasswordChange(w, r, session, 403, passwordChangeData{WrongPassword: true})
+		return
+	}
+
+	logging.Application(r.Header).WithField("username", session.Sub).Info("changed password")
+	h.respondPasswordChange(w, r, session, 200, passwordChangeData{Changed: true})
+}
+
+func (h *Handler) respondPasswordChange(w http.ResponseWriter, r *http.Request, session model.UserInfo, status int, data passwordChangeData) {
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(status)
+		writeLoginForm(w,
+			loginFormData{
+				Config:         h.config,
+				Authenticated:  true,
+				UserInfo:       session,
+				PasswordChange: &data,
+			})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	switch {
+	case data.Changed:
+		fmt.Fprintf(w, `{"status": "changed"}`)
+	case data.WrongPassword:
+		fmt.Fprintf(w, `{"error": "Wrong current password"}`)
+	case data.Overloaded:
+		fmt.Fprintf(w, `{"error": "Service Unavailable"}`)
+	case len(data.Violations) > 0:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":      "Password rejected",
+			"violations": data.Violations,
+		})
+	default:
+		fmt.Fprintf(w, `{"username": %q}`, session.Sub)
+	}
+}
+
+// getPasswordChangeValues returns the fields of the password change form or json object.
+func getPasswordChangeValues(r *http.Request) (map[string]string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		m := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, k := range []string{"current_password", "new_password", "new_password_confirm"} {
+		if _, exist := r.PostForm[k]; exist {
+			values[k] = r.PostForm.Get(k)
+		}
+	}
+	return values, nil
+}
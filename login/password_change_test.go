@@ -0,0 +1,151 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type passwordTestBackend map[string]string
+
+func (b passwordTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	if p, exist := b[username]; exist && p == password {
+		return true, model.UserInfo{Sub: username, Origin: "password"}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func (b passwordTestBackend) ChangePassword(username, currentPassword, newPassword string) (bool, error) {
+	if username == "error" {
+		return false, errors.New("test error")
+	}
+	if username == "busy" {
+		return false, fmt.Errorf("busy: %w", ErrOverloaded)
+	}
+	if b[username] != currentPassword {
+		return false, nil
+	}
+	b[username] = newPassword
+	return true, nil
+}
+
+func testPasswordHandler(t *testing.T, backend passwordTestBackend) (*Handler, func(sub, origin string) string) {
+	h := &Handler{
+		backends: []configuredBackend{
+			{"simple", NewSimpleBackend(map[string]string{"alice": "secret"})},
+			{"password", backend},
+		},
+		oauth:          oauth2.NewManager(),
+		config:         testConfig(),
+		passwordPolicy: passwordPolicy{minLength: 8},
+	}
+	return h, func(sub, origin string) string {
+		token, err := h.createToken(model.UserInfo{Sub: sub, Origin: origin, Expiry: time.Now().Add(time.Hour).Unix()})
+		NoError(t, err)
+		return "Cookie: " + h.config.CookieName + "=" + token
+	}
+}
+
+func TestHandler_PasswordChange_HTML(t *testing.T) {
+	backend := passwordTestBackend{"bob": "secret"}
+	h, cookie := testPasswordHandler(t, backend)
+	bob := cookie("bob", "password")
+
+	// the password change needs a login
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML, bob))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `action="/context/login/password"`)
+	Contains(t, recorder.Body.String(), `name="current_password"`)
+
+	recorder = httptest.NewRecorder()
+	form := url.Values{"current_password": {"secret"}, "new_password": {"n3w-secret"}, "new_password_confirm": {"other-secret"}}
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", form.Encode(), TypeForm, AcceptHTML, bob))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), "The new passwords do not match.")
+
+	recorder = httptest.NewRecorder()
+	form = url.Values{"current_password": {"wrong"}, "new_password": {"n3w-secret"}, "new_password_confirm": {"n3w-secret"}}
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", form.Encode(), TypeForm, AcceptHTML, bob))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "The current password is wrong.")
+	Equal(t, "secret", backend["bob"])
+
+	recorder = httptest.NewRecorder()
+	form = url.Values{"current_password": {"secret"}, "new_password": {"n3w-secret"}, "new_password_confirm": {"n3w-secret"}}
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", form.Encode(), TypeForm, AcceptHTML, bob))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Your password has been changed.")
+	Equal(t, "n3w-secret", backend["bob"])
+
+	// the user info links to the password change
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, bob))
+	Contains(t, recorder.Body.String(), `href="/context/login/password"`)
+
+	// but not for users of other backends
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, cookie("alice", "simple")))
+	NotContains(t, recorder.Body.String(), `href="/context/login/password"`)
+}
+
+func TestHandler_PasswordChange_JSON(t *testing.T) {
+	backend := passwordTestBackend{"bob": "secret"}
+	h, cookie := testPasswordHandler(t, backend)
+	bob := cookie("bob", "password")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "secret", "new_password": "short"}`, TypeJSON, AcceptJwt, bob))
+	Equal(t, 400, recorder.Code)
+	JSONEq(t, `{"error": "Password rejected", "violations": ["The password needs at least 8 characters."]}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "wrong", "new_password": "n3w-secret"}`, TypeJSON, AcceptJwt, bob))
+	Equal(t, 403, recorder.Code)
+	JSONEq(t, `{"error": "Wrong current password"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "secret", "new_password": "n3w-secret"}`, TypeJSON, AcceptJwt, bob))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"status": "changed"}`, recorder.Body.String())
+	Equal(t, "n3w-secret", backend["bob"])
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "secret", "new_password": "n3w-secret"}`, TypeJSON, AcceptJwt, cookie("error", "password")))
+	Equal(t, 500, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "secret", "new_password": "n3w-secret"}`, TypeJSON, AcceptJwt, cookie("busy", "password")))
+	Equal(t, 503, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	JSONEq(t, `{"error": "Service Unavailable"}`, recorder.Body.String())
+
+	// users of backends without password change are rejected
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password": "secret", "new_password": "n3w-secret"}`, TypeJSON, AcceptJwt, cookie("alice", "simple")))
+	Equal(t, 400, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/password", `{"current_password":`, TypeJSON, AcceptJwt, bob))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_PasswordChange_NotSupported(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/password", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	NotContains(t, recorder.Body.String(), `name="current_password"`)
+}
@@ -0,0 +1,119 @@
+package login
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultPasswordPolicy requires passwords with at least 8 characters
+const defaultPasswordPolicy = "min_length=8"
+
+// The character classes, which can be required by the password policy
+const (
+	passwordClassUpper   = "upper"
+	passwordClassLower   = "lower"
+	passwordClassDigit   = "digit"
+	passwordClassSpecial = "special"
+)
+
+// passwordPolicy are the rules for new passwords.
+type passwordPolicy struct {
+	minLength int
+	maxLength int
+	require   []string
+}
+
+// parsePasswordPolicy parses a policy in the form min_length=12,max_length=64,require=upper;lower;digit;special
+func parsePasswordPolicy(s string) (passwordPolicy, error) {
+	policy := passwordPolicy{}
+	if s == "" {
+		s = defaultPasswordPolicy
+	}
+	opts, err := parseOptions(s)
+	if err != nil {
+		return policy, err
+	}
+	for key, value := range opts {
+		switch key {
+		case "min_length", "max_length":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return policy, fmt.Errorf(`invalid parameter value "%s" in "%s" of the password policy: %v`, value, key, err)
+			}
+			if key == "min_length" {
+				policy.minLength = n
+			} else {
+				policy.maxLength = n
+			}
+		case "require":
+			for _, class := range strings.Split(value, ";") {
+				switch class {
+				case passwordClassUpper, passwordClassLower, passwordClassDigit, passwordClassSpecial:
+					policy.require = append(policy.require, class)
+				default:
+					return policy, fmt.Errorf(`invalid parameter value "%s" in "%s" of the password policy: unknown character class`, class, key)
+				}
+			}
+		default:
+			return policy, fmt.Errorf(`unknown parameter "%s" of the password policy`, key)
+		}
+	}
+	return policy, nil
+}
+
+// check returns the violations of the policy by the new password, or nil if it is accepted.
+func (p passwordPolicy) check(username, currentPassword, newPassword string) []string {
+	var violations []string
+	length := len([]rune(newPassword))
+	if length < p.minLength {
+		violations = append(violations, fmt.Sprintf("The password needs at least %v characters.", p.minLength))
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		violations = append(violations, fmt.Sprintf("The password may have at most %v characters.", p.maxLength))
+	}
+	for _, class := range p.require {
+		if !containsClass(newPassword, class) {
+			violations = append(violations, fmt.Sprintf("The password needs at least one %v character.", passwordClassNames[class]))
+		}
+	}
+	if newPassword == currentPassword {
+		violations = append(violations, "The password has to differ from the current one.")
+	}
+	if strings.EqualFold(newPassword, username) {
+		violations = append(violations, "The password must not be the username.")
+	}
+	return violations
+}
+
+var passwordClassNames = map[string]string{
+	passwordClassUpper:   "uppercase",
+	passwordClassLower:   "lowercase",
+	passwordClassDigit:   "numeric",
+	passwordClassSpecial: "special",
+}
+
+func containsClass(s, class string) bool {
+	for _, r := range s {
+		switch class {
+		case passwordClassUpper:
+			if unicode.IsUpper(r) {
+				return true
+			}
+		case passwordClassLower:
+			if unicode.IsLower(r) {
+				return true
+			}
+		case passwordClassDigit:
+			if unicode.IsDigit(r) {
+				return true
+			}
+		case passwordClassSpecial:
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
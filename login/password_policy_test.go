@@ -0,0 +1,39 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_parsePasswordPolicy(t *testing.T) {
+	p, err := parsePasswordPolicy("")
+	NoError(t, err)
+	Equal(t, passwordPolicy{minLength: 8}, p)
+
+	p, err = parsePasswordPolicy("min_length=12,max_length=64,require=upper;digit")
+	NoError(t, err)
+	Equal(t, passwordPolicy{minLength: 12, maxLength: 64, require: []string{"upper", "digit"}}, p)
+
+	for _, invalid := range []string{"min_length=x", "max_length=-1", "require=upper;emoji", "foo=bar"} {
+		_, err = parsePasswordPolicy(invalid)
+		Error(t, err, invalid)
+	}
+}
+
+func Test_passwordPolicy_check(t *testing.T) {
+	p, err := parsePasswordPolicy("min_length=8,max_length=12,require=upper;lower;digit;special")
+	NoError(t, err)
+
+	Empty(t, p.check("bob", "secret", "N3w-secret"))
+
+	Equal(t, []string{
+		"The password needs at least 8 characters.",
+		"The password needs at least one uppercase character.",
+		"The password needs at least one numeric character.",
+		"The password needs at least one special character.",
+	}, p.check("bob", "secret", "short"))
+
+	Equal(t, []string{"The password may have at most 12 characters."}, p.check("bob", "secret", "N3w-secret-too-long"))
+	Equal(t, []string{"The password has to differ from the current one."}, p.check("bob", "N3w-secret", "N3w-secret"))
+	Equal(t, []string{"The password must not be the username."}, p.check("B0b-builder", "secret", "b0B-BUILDER"))
+}
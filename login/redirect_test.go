@@ -32,8 +32,8 @@ func TestRedirect_NotAllowed(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Redirect = false
 	h := &Handler{
-		backends: []Backend{
-			NewSimpleBackend(map[string]string{"bob": "secret"}),
+		backends: []configuredBackend{
+			{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})},
 		},
 		oauth:  oauth2.NewManager(),
 		config: cfg,
@@ -59,8 +59,8 @@ func TestRedirect_NonMatchingReferrer(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.RedirectCheckReferer = false
 	h := &Handler{
-		backends: []Backend{
-			NewSimpleBackend(map[string]string{"bob": "secret"}),
+		backends: []configuredBackend{
+			{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})},
 		},
 		oauth:  oauth2.NewManager(),
 		config: cfg,
@@ -95,8 +95,8 @@ func TestRedirect_Whitelisting(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.RedirectHostFile = whitelistFile.Name()
 	h := &Handler{
-		backends: []Backend{
-			NewSimpleBackend(map[string]string{"bob": "secret"}),
+		backends: []configuredBackend{
+			{"simple", NewSimpleBackend(map[string]string{"bob": "secret"})},
 		},
 		oauth:  oauth2.NewManager(),
 		config: cfg,
@@ -1,8 +1,17 @@
 package login
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
 )
 
 // SimpleProviderName const with the providers name
@@ -12,7 +21,7 @@ func init() {
 	RegisterProvider(
 		&ProviderDescription{
 			Name:     SimpleProviderName,
-			HelpText: "Simple login backend opts: user1=password,user2=password,..",
+			HelpText: "Simple login backend opts: user1=password,user2=password,.. (passwords may be bcrypt, argon2id or SHA-crypt hashes)",
 		},
 		SimpleBackendFactory)
 }
@@ -26,6 +35,18 @@ func SimpleBackendFactory(config map[string]string) (Backend, error) {
 	if len(userPassword) == 0 {
 		return nil, errors.New("no users provided for simple backend")
 	}
+
+	var plaintextUsers []string
+	for user, password := range userPassword {
+		if !isSimpleHash(password) {
+			plaintextUsers = append(plaintextUsers, user)
+		}
+	}
+	if len(plaintextUsers) > 0 {
+		sort.Strings(plaintextUsers)
+		logging.Logger.Warnf("simple backend: plaintext passwords configured for %v, please use bcrypt, argon2id or SHA-crypt hashes instead",
+			strings.Join(plaintextUsers, ", "))
+	}
 	return NewSimpleBackend(userPassword), nil
 }
 
@@ -35,6 +56,7 @@ type SimpleBackend struct {
 }
 
 // NewSimpleBackend creates a new SIMPLE Backend and verifies the parameters.
+// The passwords may be plaintext or bcrypt, argon2id or SHA-crypt hashes.
 func NewSimpleBackend(userPassword map[string]string) *SimpleBackend {
 	return &SimpleBackend{
 		userPassword: userPassword,
@@ -43,11 +65,63 @@ func NewSimpleBackend(userPassword map[string]string) *SimpleBackend {
 
 // Authenticate the user
 func (sb *SimpleBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
-	if p, exist := sb.userPassword[username]; exist && p == password {
-		return true, model.UserInfo{
-			Origin: SimpleProviderName,
-			Sub:    username,
-		}, nil
+	return sb.AuthenticateContext(context.Background(), LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext checks the credentials. Hashes are checked, as soon as a worker of the pwhash.DefaultPool is free.
+// If no worker became free within the queue timeout, it returns ErrOverloaded.
+func (sb *SimpleBackend) AuthenticateContext(ctx context.Context, req LoginRequest) (bool, model.UserInfo, error) {
+	username := req.Username
+	p, exist := sb.userPassword[username]
+	if !exist {
+		return false, model.UserInfo{}, nil
+	}
+
+	matched, err := compareSimplePassword(ctx, p, req.Password)
+	if err == pwhash.ErrQueueTimeout {
+		return false, model.UserInfo{}, fmt.Errorf("simple: %w", ErrOverloaded)
 	}
-	return false, model.UserInfo{}, nil
+	if err != nil || !matched {
+		return false, model.UserInfo{}, err
+	}
+	return true, model.UserInfo{
+		Origin: SimpleProviderName,
+		Sub:    username,
+	}, nil
+}
+
+// LookupUser returns the user info of a configured user, without checking the password.
+func (sb *SimpleBackend) LookupUser(username string) (bool, model.UserInfo, error) {
+	if _, exist := sb.userPassword[username]; !exist {
+		return false, model.UserInfo{}, nil
+	}
+	return true, model.UserInfo{
+		Origin: SimpleProviderName,
+		Sub:    username,
+	}, nil
+}
+
+// isSimpleHash returns true, if the configured password is a hash in one of the formats accepted by the simple backend.
+func isSimpleHash(password string) bool {
+	switch pwhash.HashAlgorithm(password) {
+	case pwhash.AlgorithmBcrypt, pwhash.AlgorithmArgon2id, pwhash.AlgorithmSHA256Crypt, pwhash.AlgorithmSHA512Crypt:
+		return true
+	}
+	return false
+}
+
+// compareSimplePassword checks the password against the configured hash or plaintext password in constant time.
+func compareSimplePassword(ctx context.Context, configured, password string) (bool, error) {
+	if isSimpleHash(configured) {
+		// the options are separated by ',', so the parameters of argon2id hashes are separated by ';' instead
+		if pwhash.HashAlgorithm(configured) == pwhash.AlgorithmArgon2id {
+			configured = strings.Replace(configured, ";", ",", -1)
+		}
+		return pwhash.DefaultPool.CompareHash(ctx, configured, password)
+	}
+
+	// compare the digests, so that the time does not depend on the length of the passwords
+	c := sha256.Sum256([]byte(configured))
+	p := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(c[:], p[:]) == 1, nil
 }
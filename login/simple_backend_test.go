@@ -1,8 +1,16 @@
 package login
 
 import (
+	"bytes"
+	"errors"
+	"expvar"
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestSetup(t *testing.T) {
@@ -22,6 +30,28 @@ func TestSetup(t *testing.T) {
 		backend.(*SimpleBackend).userPassword)
 }
 
+func TestSetup_WarnOnPlaintextPasswords(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	logging.Logger.Out = b
+	defer func() { logging.Logger.Out = os.Stderr }()
+
+	p, _ := GetProvider(SimpleProviderName)
+	_, err := p(map[string]string{
+		"bob":   "secret",
+		"alice": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+	})
+	NoError(t, err)
+	Contains(t, b.String(), "plaintext passwords configured for bob")
+	NotContains(t, b.String(), "alice")
+
+	b.Reset()
+	_, err = p(map[string]string{
+		"alice": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+	})
+	NoError(t, err)
+	Empty(t, b.String())
+}
+
 func TestSimpleBackend_Authenticate(t *testing.T) {
 	backend := NewSimpleBackend(map[string]string{
 		"bob": "secret",
@@ -37,8 +67,98 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	Equal(t, "", userInfo.Sub)
 	NoError(t, err)
 
+	authenticated, userInfo, err = backend.Authenticate("bob", "secret2")
+	False(t, authenticated)
+	NoError(t, err)
+
 	authenticated, userInfo, err = backend.Authenticate("", "")
 	False(t, authenticated)
 	Equal(t, "", userInfo.Sub)
 	NoError(t, err)
 }
+
+func TestSimpleBackend_LookupUser(t *testing.T) {
+	backend := NewSimpleBackend(map[string]string{
+		"bob": "secret",
+	})
+
+	exist, userInfo, err := backend.LookupUser("bob")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, model.UserInfo{Origin: "simple", Sub: "bob"}, userInfo)
+
+	exist, _, err = backend.LookupUser("alice")
+	NoError(t, err)
+	False(t, exist)
+}
+
+func TestSimpleBackend_Authenticate_Hashes(t *testing.T) {
+	// password for all of them is 'secret'
+	hashes := map[string]string{
+		"bcrypt":             "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+		"argon2id":           "$argon2id$v=19$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"argon2id-semicolon": "$argon2id$v=19$m=16384;t=2;p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"sha256-crypt":       "$5$htpasswdsalt$h133erJPVhvsuGbP1mGweT7etjqEwS88HB4kHhy/YvD",
+		"sha512-crypt":       "$6$rounds=10000$htpasswdsalt$9odM2l4gWPLvg.U45YEHxF.TtYCUukW2WQVHe.1QRPBsc93x1CL7OD3GMlyVVqmzYArkFVH3Hf0iEy26KrqYz/",
+	}
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			backend := NewSimpleBackend(map[string]string{"bob": hash})
+
+			authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+			NoError(t, err)
+			True(t, authenticated)
+			Equal(t, "bob", userInfo.Sub)
+
+			authenticated, _, err = backend.Authenticate("bob", "XXXXX")
+			NoError(t, err)
+			False(t, authenticated)
+
+			// the hash itself is not accepted as password
+			authenticated, _, err = backend.Authenticate("bob", hash)
+			NoError(t, err)
+			False(t, authenticated)
+		})
+	}
+}
+
+func TestSimpleBackend_Authenticate_WeakHashesArePlaintext(t *testing.T) {
+	// md5 and sha1 hashes are not accepted, so they are used as plaintext passwords
+	backend := NewSimpleBackend(map[string]string{"bob": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="})
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("bob", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+func TestSimpleBackend_Authenticate_Overloaded(t *testing.T) {
+	defaultPool := pwhash.DefaultPool
+	defer func() { pwhash.DefaultPool = defaultPool }()
+	pwhash.DefaultPool = pwhash.NewPool(1, 10*time.Millisecond)
+
+	// bcrypt with cost 12 keeps the only worker busy for a while
+	backend := NewSimpleBackend(map[string]string{
+		"slow": "$2a$12$jcqvjD2MaHmmxwW3DUZfGOWlwnajWRgxFo/F51SXPllaRWQwds/Dy",
+		"bob":  "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+	})
+
+	busyWorkers := expvar.Get("hash_verification").(*expvar.Map).Get("busy_workers").(*expvar.Int)
+	busy := busyWorkers.Value()
+
+	done := make(chan bool)
+	go func() {
+		authenticated, _, _ := backend.Authenticate("slow", "secret")
+		done <- authenticated
+	}()
+	Eventually(t, func() bool { return busyWorkers.Value() > busy }, time.Second, time.Millisecond)
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	False(t, authenticated)
+	True(t, errors.Is(err, ErrOverloaded))
+
+	True(t, <-done)
+}
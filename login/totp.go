@@ -0,0 +1,291 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/tarent/loginsrv/lockout"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/totp"
+)
+
+// totpQRCodeSize is the width and height of the QR code on the enrolment page
+const totpQRCodeSize = 200
+
+func newTOTPManager(config *Config) (*totp.Manager, error) {
+	store := config.TOTPStore
+	if store == nil {
+		var err error
+		store, err = totp.NewFileStore(config.TOTPSecretFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return totp.NewManager(totp.Config{
+		Issuer: config.TOTPIssuer,
+		Store:  store,
+	})
+}
+
+// totpPath is the path of the code prompt below the login path.
+// The enrolment page is at totpPath()+"/enroll".
+func (h *Handler) totpPath() string {
+	return strings.TrimRight(h.config.LoginPath, "/") + "/totp"
+}
+
+// requireTOTP starts the second login step, if the authenticated user is enrolled in TOTP.
+// It returns false, if no code is needed and the token can be issued.
+func (h *Handler) requireTOTP(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) (bool, error) {
+	if h.totp == nil {
+		return false, nil
+	}
+	enrolled, err := h.totp.Enrolled(userInfo.Sub)
+	if err != nil || !enrolled {
+		return false, err
+	}
+
+	token, err := h.totp.BeginLogin(userInfo)
+	if err != nil {
+		return false, err
+	}
+	logging.Application(r.Header).WithField("username", userInfo.Sub).Info("totp code required")
+	h.respondTOTPRequired(w, r, token, userInfo.Sub, false)
+	return true, nil
+}
+
+func (h *Handler) handleTOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	values, err := getTOTPValues(r)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+
+	token := values["totp_token"]
+	var attempt *lockout.Attempt
+	if pending, exist := h.totp.PendingUser(token); exist {
+		var answered bool
+		if attempt, answered = h.beginLogin(w, r, pending.Sub); answered {
+			return
+		}
+		defer attempt.Release()
+	}
+
+	userInfo, err := h.totp.FinishLogin(token, values["code"])
+	if err == totp.ErrWrongCode || err == totp.ErrLoginExpired && userInfo.Sub != "" {
+		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("wrong totp code")
+		if h.recordFailedLogin(w, r, attempt, userInfo.Sub) {
+			return
+		}
+	}
+	if err == totp.ErrWrongCode {
+		h.respondTOTPRequired(w, r, token, userInfo.Sub, true)
+		return
+	}
+	if err == totp.ErrLoginExpired {
+		logging.Application(r.Header).Info("totp login expired")
+		h.respondAuthFailure(w, r)
+		return
+	}
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	h.resetLockout(r, attempt)
+	userInfo.AMR = []string{totp.AMRPassword, totp.AMROTP}
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).Info("successfully authenticated with totp code")
+	h.respondAuthenticated(w, r, userInfo)
+}
+
+func (h *Handler) respondTOTPRequired(w http.ResponseWriter, r *http.Request, token, username string, wrongCode bool) {
+	// like all rejections, 403 is used instead of 401, to not conflict with an HTTP Basic authentication
+	message := "TOTP code required"
+	if wrongCode {
+		message = "Wrong TOTP code"
+	}
+
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		writeLoginForm(w,
+			loginFormData{
+				Failure:   wrongCode,
+				Config:    h.config,
+				UserInfo:  model.UserInfo{Sub: username},
+				TOTPToken: token,
+			})
+		return
+	}
+
+	// the client needs the token for the next request, so we always answer with json
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(403)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":      message,
+		"totp_token": token,
+	})
+}
+
+func (h *Handler) handleTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	session, valid := h.GetToken(r)
+	if !valid {
+		h.respondAuthFailure(w, r)
+		return
+	}
+
+	enrolled, err := h.totp.Enrolled(session.Sub)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	if enrolled {
+		h.respondTOTPAlreadyEnrolled(w, r, session)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		key, err := h.totp.NewKey(session.Sub)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		if wantJSON(r) {
+			w.Header().Set("Content-Type", contentTypeJSON)
+			json.NewEncoder(w).Encode(map[string]string{
+				"secret": key.Secret(),
+				"url":    key.String(),
+			})
+			return
+		}
+		h.respondTOTPEnroll(w, r, session, key.Secret(), false)
+
+	case "POST":
+		values, err := getTOTPValues(r)
+		if err != nil {
+			h.respondBadRequest(w, r)
+			return
+		}
+		enrolled, err := h.totp.Enroll(session.Sub, values["secret"], values["code"])
+		if err == totp.ErrAlreadyEnrolled {
+			h.respondTOTPAlreadyEnrolled(w, r, session)
+			return
+		}
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
+		}
+		if !enrolled {
+			logging.Application(r.Header).WithField("username", session.Sub).Info("wrong totp code on enrolment")
+			if wantHTML(r) {
+				h.respondTOTPEnroll(w, r, session, values["secret"], true)
+				return
+			}
+			w.Header().Set("Content-Type", contentTypeJSON)
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error": "Wrong TOTP code"}`)
+			return
+		}
+
+		logging.Application(r.Header).WithField("username", session.Sub).Info("enrolled in totp")
+		if wantHTML(r) {
+			w.Header().Set("Location", h.config.LoginPath)
+			w.WriteHeader(303)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(201)
+		fmt.Fprintf(w, `{"status": "enrolled"}`)
+
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+// respondTOTPAlreadyEnrolled rejects the enrolment of a user, who already has a secret.
+// Otherwise a stolen session would be enough to replace the secret and lock out the owner.
+func (h *Handler) respondTOTPAlreadyEnrolled(w http.ResponseWriter, r *http.Request, session model.UserInfo) {
+	logging.Application(r.Header).WithField("username", session.Sub).Warn("rejected totp enrolment of an enrolled user")
+	if wantHTML(r) {
+		w.Header().Set("Content-Type", contentTypeHTML)
+		w.WriteHeader(403)
+		writeLoginForm(w,
+			loginFormData{
+				Config:         h.config,
+				Authenticated:  true,
+				UserInfo:       session,
+				TOTPEnrollment: &totpEnrollment{Enrolled: true},
+			})
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(403)
+	fmt.Fprintf(w, `{"error": "Already enrolled in TOTP"}`)
+}
+
+func (h *Handler) respondTOTPEnroll(w http.ResponseWriter, r *http.Request, session model.UserInfo, secret string, wrongCode bool) {
+	key, err := h.totp.Key(session.Sub, secret)
+	if err != nil {
+		h.respondBadRequest(w, r)
+		return
+	}
+	qrCode, err := totp.QRCode(key, totpQRCodeSize)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeHTML)
+	if wrongCode {
+		w.WriteHeader(403)
+	}
+	writeLoginForm(w,
+		loginFormData{
+			Failure:       wrongCode,
+			Config:        h.config,
+			Authenticated: true,
+			UserInfo:      session,
+			TOTPEnrollment: &totpEnrollment{
+				Secret: key.Secret(),
+				QRCode: template.URL(qrCode),
+			},
+		})
+}
+
+// getTOTPValues returns the fields of the code prompt and enrolment form, or of the same json object.
+func getTOTPValues(r *http.Request) (map[string]string, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), contentTypeJSON) {
+		m := map[string]string{}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"totp_token": r.PostForm.Get("totp_token"),
+		"code":       r.PostForm.Get("code"),
+		"secret":     r.PostForm.Get("secret"),
+	}, nil
+}
@@ -0,0 +1,244 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+type totpTestStore map[string]string
+
+func (s totpTestStore) Secret(sub string) (string, bool, error) {
+	secret, exist := s[sub]
+	return secret, exist, nil
+}
+
+func (s totpTestStore) SetSecret(sub, secret string) error {
+	s[sub] = secret
+	return nil
+}
+
+// totpCode returns the code for the current time step with the offset
+func totpCode(secret string, offset int) string {
+	code, err := hotp.GenerateCode(secret, uint64(time.Now().Unix()/totp.Period+int64(offset)))
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+func testTOTPHandler(t *testing.T, store totpTestStore) *Handler {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret", "alice": "secret"}}
+	config.TOTPStore = store
+	h, err := NewHandler(config)
+	NoError(t, err)
+	return h
+}
+
+func TestHandler_TOTP_JSON(t *testing.T) {
+	h := testTOTPHandler(t, totpTestStore{"bob": testTOTPSecret})
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	response := map[string]string{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	Equal(t, "TOTP code required", response["error"])
+	token := response["totp_token"]
+	NotEmpty(t, token)
+
+	// wrong code
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", `{"totp_token": "`+token+`", "code": "000000"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), token)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", `{"totp_token": "`+token+`", "code": "`+totpCode(testTOTPSecret, 0)+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, []interface{}{"pwd", "otp"}, claims["amr"])
+
+	// the login can be finished only once
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", `{"totp_token": "`+token+`", "code": "`+totpCode(testTOTPSecret, 1)+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	NotContains(t, recorder.Body.String(), token)
+
+	// users without secret get the token directly
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "alice", claims["sub"])
+	Nil(t, claims["amr"])
+
+	// no prompt on wrong passwords
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+}
+
+func TestHandler_TOTP_HTML(t *testing.T) {
+	h := testTOTPHandler(t, totpTestStore{"bob": testTOTPSecret})
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+	Empty(t, recorder.Header().Get("Set-Cookie"))
+	body := recorder.Body.String()
+	Contains(t, body, `action="/context/login/totp"`)
+	Contains(t, body, `name="code"`)
+	NotContains(t, body, `name="password"`)
+
+	token := between(body, `name="totp_token" value="`, `"`)
+	NotEmpty(t, token)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", url.Values{"totp_token": {token}, "code": {"000000"}}.Encode(), TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Invalid code")
+	Contains(t, recorder.Body.String(), token)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", url.Values{"totp_token": {token}, "code": {totpCode(testTOTPSecret, 0)}}.Encode(), TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+	cookie := strings.SplitN(recorder.Header().Get("Set-Cookie"), ";", 2)[0]
+	claims, err := tokenAsMap(strings.TrimPrefix(cookie, h.config.CookieName+"="))
+	NoError(t, err)
+	Equal(t, []interface{}{"pwd", "otp"}, claims["amr"])
+
+	// an unknown login shows the login form again
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp", url.Values{"totp_token": {"foo"}, "code": {"123456"}}.Encode(), TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `name="password"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/totp", "", AcceptHTML))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_TOTP_Enroll(t *testing.T) {
+	store := totpTestStore{}
+	h := testTOTPHandler(t, store)
+	session, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	cookie := "Cookie: " + h.config.CookieName + "=" + session
+
+	// the enrolment needs a login
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/totp/enroll", "", AcceptHTML))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/totp/enroll", "", AcceptHTML, cookie))
+	Equal(t, 200, recorder.Code)
+	body := recorder.Body.String()
+	Contains(t, body, `src="data:image/png;base64,`)
+	Contains(t, body, `action="/context/login/totp/enroll"`)
+	secret := between(body, `name="secret" value="`, `"`)
+	NotEmpty(t, secret)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp/enroll", url.Values{"secret": {secret}, "code": {"000000"}}.Encode(), TypeForm, AcceptHTML, cookie))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "Invalid code")
+	Contains(t, recorder.Body.String(), secret)
+	Empty(t, store)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp/enroll", url.Values{"secret": {secret}, "code": {totpCode(secret, 0)}}.Encode(), TypeForm, AcceptHTML, cookie))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/context/login", recorder.Header().Get("Location"))
+	Equal(t, secret, store["bob"])
+
+	// the next login needs a code
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// the user info shows the link to the enrolment
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, cookie))
+	Contains(t, recorder.Body.String(), `href="/context/login/totp/enroll"`)
+
+	// the session is not enough to replace the secret
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/totp/enroll", "", AcceptHTML, cookie))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "already set up")
+	NotContains(t, recorder.Body.String(), `name="secret"`)
+
+	other := "KRSXG5CTMVRXEZLU"
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp/enroll", `{"secret": "`+other+`", "code": "`+totpCode(other, 0)+`"}`, TypeJSON, cookie))
+	Equal(t, 403, recorder.Code)
+	JSONEq(t, `{"error": "Already enrolled in TOTP"}`, recorder.Body.String())
+	Equal(t, secret, store["bob"])
+}
+
+func TestHandler_TOTP_Enroll_JSON(t *testing.T) {
+	store := totpTestStore{}
+	h := testTOTPHandler(t, store)
+	session, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	NoError(t, err)
+	cookie := "Cookie: " + h.config.CookieName + "=" + session
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/totp/enroll", "", "Accept: application/json", cookie))
+	Equal(t, 200, recorder.Code)
+	key := map[string]string{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &key))
+	True(t, strings.HasPrefix(key["url"], "otpauth://totp/loginsrv:bob?"))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp/enroll", `{"secret": "`+key["secret"]+`", "code": "000000"}`, TypeJSON, cookie))
+	Equal(t, 403, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/totp/enroll", `{"secret": "`+key["secret"]+`", "code": "`+totpCode(key["secret"], 0)+`"}`, TypeJSON, cookie))
+	Equal(t, 201, recorder.Code)
+	Equal(t, key["secret"], store["bob"])
+}
+
+func TestHandler_NewFromConfig_TOTP(t *testing.T) {
+	config := testConfig()
+	config.Backends = Options{"simple": {"bob": "secret"}}
+	config.TOTPSecretFile = "/tmp/loginsrv_not_existing_totp.json"
+
+	h, err := NewHandler(config)
+	NoError(t, err)
+	NotNil(t, h.totp)
+
+	config.TOTPSecretFile = ""
+	h, err = NewHandler(config)
+	NoError(t, err)
+	Nil(t, h.totp)
+}
+
+func between(s, start, end string) string {
+	i := strings.Index(s, start)
+	if i < 0 {
+		return ""
+	}
+	s = s[i+len(start):]
+	return s[:strings.Index(s, end)]
+}
@@ -0,0 +1,36 @@
+package magiclink
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestSMTPMailer(t *testing.T) {
+	server, err := startTestSMTPServer()
+	NoError(t, err)
+	defer server.Close()
+
+	m := &SMTPMailer{Addr: server.Addr(), From: "loginsrv@example.com"}
+	NoError(t, m.Send("bob@example.com", "Your login link", "Hello Bob\r\n"))
+
+	mails := server.Mails()
+	Equal(t, 1, len(mails))
+	Equal(t, "loginsrv@example.com", mails[0].from)
+	Equal(t, []string{"bob@example.com"}, mails[0].to)
+	Contains(t, mails[0].data, "To: bob@example.com\r\n")
+	Contains(t, mails[0].data, "Subject: Your login link\r\n")
+	Contains(t, mails[0].data, "\r\n\r\nHello Bob\r\n")
+}
+
+func TestSMTPMailer_Errors(t *testing.T) {
+	server, err := startTestSMTPServer()
+	NoError(t, err)
+	server.Close()
+
+	m := &SMTPMailer{Addr: server.Addr(), From: "loginsrv@example.com"}
+	Error(t, m.Send("bob@example.com", "Your login link", "Hello Bob\r\n"))
+
+	m = &SMTPMailer{Addr: "no-port", Username: "user", From: "loginsrv@example.com"}
+	Error(t, m.Send("bob@example.com", "Your login link", "Hello Bob\r\n"))
+}
@@ -0,0 +1,175 @@
+package magiclink
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Origin is the origin of the user info for magic link logins
+const Origin = "magiclink"
+
+// DefaultExpiry is the lifetime of a login link.
+const DefaultExpiry = 15 * time.Minute
+
+// DefaultSubject is the subject of the login mails.
+const DefaultSubject = "Your login link"
+
+// DefaultMaxMails is the number of login links, which are sent to one address within the lifetime of the links.
+const DefaultMaxMails = 3
+
+var (
+	// ErrInvalidLink is returned for links, which were not created by the manager or were modified.
+	ErrInvalidLink = errors.New("invalid login link")
+
+	// ErrLinkExpired is returned for links older than the expiry.
+	ErrLinkExpired = errors.New("login link expired")
+
+	// ErrLinkUsed is returned for links, which were used before.
+	ErrLinkUsed = errors.New("login link already used")
+)
+
+// Config for the Manager
+type Config struct {
+	// Key to sign the links
+	Key []byte
+
+	// Expiry is the lifetime of a link
+	Expiry time.Duration
+
+	// Mailer sends the links
+	Mailer Mailer
+
+	// Subject of the mails
+	Subject string
+}
+
+// linkPayload is the signed content of a link
+type linkPayload struct {
+	Email  string `json:"email"`
+	Expiry int64  `json:"exp"`
+	Nonce  string `json:"nonce"`
+}
+
+// Manager creates the login links, sends them and verifies them, when they are used.
+type Manager struct {
+	config Config
+	// used holds the nonces of the used links until they expire.
+	// They are only kept in memory, so a link can be used again after a restart or on another instance.
+	used map[string]time.Time
+	mu   sync.Mutex
+	now  func() time.Time
+}
+
+// NewManager creates a Manager.
+func NewManager(config Config) (*Manager, error) {
+	if len(config.Key) == 0 {
+		return nil, errors.New("missing key to sign the login links")
+	}
+	if config.Mailer == nil {
+		return nil, errors.New("missing mailer for the login links")
+	}
+	if config.Expiry == 0 {
+		config.Expiry = DefaultExpiry
+	}
+	if config.Subject == "" {
+		config.Subject = DefaultSubject
+	}
+	return &Manager{
+		config: config,
+		used:   map[string]time.Time{},
+		now:    time.Now,
+	}, nil
+}
+
+// Send mails a login link for the email address. The link is the linkURL with the token as query parameter.
+func (m *Manager) Send(email, linkURL string) error {
+	token, err := m.createToken(email)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(linkURL)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	body := fmt.Sprintf("Hello,\r\n\r\n"+
+		"please use the following link to login. It is valid for %v and can be used only once.\r\n\r\n"+
+		"%v\r\n\r\n"+
+		"If you did not request this link, you can ignore this mail.\r\n",
+		m.config.Expiry, u.String())
+	return m.config.Mailer.Send(email, m.config.Subject, body)
+}
+
+// Verify checks the token of a link and returns its email address.
+// Each link can only be used once.
+func (m *Manager) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidLink
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, m.sign(parts[0])) {
+		return "", ErrInvalidLink
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidLink
+	}
+	payload := linkPayload{}
+	if err := json.Unmarshal(b, &payload); err != nil || payload.Email == "" || payload.Nonce == "" {
+		return "", ErrInvalidLink
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Unix() > payload.Expiry {
+		return "", ErrLinkExpired
+	}
+	for nonce, expiry := range m.used {
+		if now.After(expiry) {
+			delete(m.used, nonce)
+		}
+	}
+	if _, used := m.used[payload.Nonce]; used {
+		return "", ErrLinkUsed
+	}
+	m.used[payload.Nonce] = time.Unix(payload.Expiry+1, 0)
+	return payload.Email, nil
+}
+
+func (m *Manager) createToken(email string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(linkPayload{
+		Email:  email,
+		Expiry: m.now().Add(m.config.Expiry).Unix(),
+		Nonce:  base64.RawURLEncoding.EncodeToString(nonce),
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(m.sign(payload)), nil
+}
+
+func (m *Manager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, m.config.Key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
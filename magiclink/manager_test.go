@@ -0,0 +1,132 @@
+package magiclink
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+type testMailer struct {
+	to, subject, body string
+	err               error
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.to, m.subject, m.body = to, subject, body
+	return m.err
+}
+
+// token returns the token of the link in the last mail
+func (m *testMailer) token(t *testing.T) string {
+	link := regexp.MustCompile(`https?://\S+`).FindString(m.body)
+	u, err := url.Parse(link)
+	NoError(t, err)
+	return u.Query().Get("token")
+}
+
+func testManager() (*Manager, *testMailer) {
+	mailer := &testMailer{}
+	m, _ := NewManager(Config{Key: []byte("secret"), Mailer: mailer})
+	return m, mailer
+}
+
+func TestManager_NewManager(t *testing.T) {
+	_, err := NewManager(Config{Mailer: &testMailer{}})
+	Error(t, err)
+
+	_, err = NewManager(Config{Key: []byte("secret")})
+	Error(t, err)
+
+	m, err := NewManager(Config{Key: []byte("secret"), Mailer: &testMailer{}})
+	NoError(t, err)
+	Equal(t, DefaultExpiry, m.config.Expiry)
+	Equal(t, DefaultSubject, m.config.Subject)
+}
+
+func TestManager_SendAndVerify(t *testing.T) {
+	m, mailer := testManager()
+
+	NoError(t, m.Send("bob@example.com", "https://example.com/login/magiclink?backTo=%2F"))
+	Equal(t, "bob@example.com", mailer.to)
+	Equal(t, DefaultSubject, mailer.subject)
+	Contains(t, mailer.body, "https://example.com/login/magiclink?backTo=%2F&token=")
+	Contains(t, mailer.body, "valid for 15m0s")
+
+	email, err := m.Verify(mailer.token(t))
+	NoError(t, err)
+	Equal(t, "bob@example.com", email)
+
+	// the link can be used only once
+	_, err = m.Verify(mailer.token(t))
+	Equal(t, ErrLinkUsed, err)
+}
+
+func TestManager_Verify_Expired(t *testing.T) {
+	m, mailer := testManager()
+	NoError(t, m.Send("bob@example.com", "https://example.com/login/magiclink"))
+
+	m.now = func() time.Time { return time.Now().Add(DefaultExpiry + time.Second) }
+	_, err := m.Verify(mailer.token(t))
+	Equal(t, ErrLinkExpired, err)
+}
+
+func TestManager_Verify_Invalid(t *testing.T) {
+	m, mailer := testManager()
+	NoError(t, m.Send("bob@example.com", "https://example.com/login/magiclink"))
+	token := mailer.token(t)
+
+	other, otherMailer := testManager()
+	other.config.Key = []byte("other secret")
+	NoError(t, other.Send("bob@example.com", "https://example.com/login/magiclink"))
+
+	parts := strings.Split(token, ".")
+	for _, invalid := range []string{
+		"",
+		"foo",
+		parts[0],
+		parts[0] + ".",
+		parts[0] + "." + parts[1] + "x",
+		"eyJlbWFpbCI6ImFsaWNlQGV4YW1wbGUuY29tIn0." + parts[1],
+		otherMailer.token(t),
+	} {
+		_, err := m.Verify(invalid)
+		Equal(t, ErrInvalidLink, err, invalid)
+	}
+
+	email, err := m.Verify(token)
+	NoError(t, err)
+	Equal(t, "bob@example.com", email)
+}
+
+func TestManager_Send_Errors(t *testing.T) {
+	m, mailer := testManager()
+	mailer.err = errors.New("smtp error")
+	Error(t, m.Send("bob@example.com", "https://example.com/login/magiclink"))
+
+	Error(t, m.Send("bob@example.com", "%zz"))
+}
+
+func TestManager_SMTP(t *testing.T) {
+	server, err := startTestSMTPServer()
+	NoError(t, err)
+	defer server.Close()
+
+	m, err := NewManager(Config{
+		Key:    []byte("secret"),
+		Mailer: &SMTPMailer{Addr: server.Addr(), From: "loginsrv@example.com"},
+	})
+	NoError(t, err)
+	NoError(t, m.Send("bob@example.com", "https://example.com/login/magiclink"))
+
+	mails := server.Mails()
+	Equal(t, 1, len(mails))
+	mailer := &testMailer{body: mails[0].data}
+	email, err := m.Verify(mailer.token(t))
+	NoError(t, err)
+	Equal(t, "bob@example.com", email)
+}
@@ -0,0 +1,111 @@
+package magiclink
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// testMail is a mail received by the testSMTPServer
+type testMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// testSMTPServer is a minimal SMTP server without TLS and authentication, which keeps the received mails.
+type testSMTPServer struct {
+	listener net.Listener
+	mails    []testMail
+	mu       sync.Mutex
+}
+
+func startTestSMTPServer() (*testSMTPServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &testSMTPServer{listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *testSMTPServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func (s *testSMTPServer) Mails() []testMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]testMail{}, s.mails...)
+}
+
+func (s *testSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost test smtp")
+
+	mail := testMail{}
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case cmd == "EHLO" || cmd == "HELO":
+			c.PrintfLine("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			mail.from = strings.Trim(line[len("MAIL FROM:"):], "<> ")
+			c.PrintfLine("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			c.PrintfLine("250 OK")
+		case cmd == "DATA":
+			c.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(c.Reader.R)
+			if err != nil {
+				return
+			}
+			mail.data = data
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			mail = testMail{}
+			c.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("250 OK")
+		}
+	}
+}
+
+func readData(r *bufio.Reader) (string, error) {
+	b := &strings.Builder{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return b.String(), nil
+		}
+		b.WriteString(strings.TrimPrefix(line, "."))
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	_ "github.com/tarent/loginsrv/accesstoken"
+	_ "github.com/tarent/loginsrv/exec"
 	_ "github.com/tarent/loginsrv/htpasswd"
 	_ "github.com/tarent/loginsrv/httpupstream"
+	_ "github.com/tarent/loginsrv/ldap"
 	_ "github.com/tarent/loginsrv/osiam"
+	_ "github.com/tarent/loginsrv/radius"
+	_ "github.com/tarent/loginsrv/sql"
+	_ "github.com/tarent/loginsrv/userfile"
 
 	"github.com/tarent/loginsrv/login"
 
 	"context"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -46,9 +53,25 @@ func main() {
 	}
 
 	httpSrv := &http.Server{Addr: port, Handler: handlerChain}
+	if config.TLSCert != "" {
+		httpSrv.TLSConfig, err = config.TLSConfig()
+		if err != nil {
+			exit(nil, err)
+		}
+	}
+
+	if config.MetricsAddr != "" {
+		go serveMetrics(config.MetricsAddr)
+	}
 
 	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil {
+		var err error
+		if config.TLSCert != "" {
+			err = httpSrv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		} else {
+			err = httpSrv.ListenAndServe()
+		}
+		if err != nil {
 			if err == http.ErrServerClosed {
 				logging.ServerClosed(applicationName)
 			} else {
@@ -64,6 +87,16 @@ func main() {
 	ctxCancel()
 }
 
+// serveMetrics serves the expvar metrics, e.g. of the hash verifications, on a separate address,
+// so that they are not exposed together with the login.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logging.Logger.WithError(err).Error("could not serve the metrics")
+	}
+}
+
 var exit = func(signal os.Signal, err error) {
 	logging.LifecycleStop(applicationName, signal, err)
 	if err == nil {
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -17,6 +18,47 @@ type UserInfo struct {
 	Refreshes int      `json:"refs,omitempty"`
 	Domain    string   `json:"domain,omitempty"`
 	Groups    []string `json:"groups,omitempty"`
+	AMR       []string `json:"amr,omitempty"`
+
+	// Claims are additional claims of the token, which are supplied by the backend.
+	// They are serialized on the top level of the token and can not override the fields above.
+	Claims map[string]interface{} `json:"-"`
+}
+
+// standardClaimNames are the json names of the fields of the UserInfo
+var standardClaimNames = []string{"sub", "picture", "name", "email", "origin", "exp", "refs", "domain", "groups", "amr"}
+
+// userInfoFields is used for the default json (un)marshalling of the UserInfo
+type userInfoFields UserInfo
+
+// MarshalJSON serializes the UserInfo together with the additional claims.
+func (u UserInfo) MarshalJSON() ([]byte, error) {
+	if len(u.Claims) == 0 {
+		return json.Marshal(userInfoFields(u))
+	}
+	return json.Marshal(u.AsMap())
+}
+
+// UnmarshalJSON deserializes the UserInfo and collects all unknown fields as additional claims.
+func (u *UserInfo) UnmarshalJSON(data []byte) error {
+	fields := userInfoFields{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for _, k := range standardClaimNames {
+		delete(all, k)
+	}
+	if len(all) > 0 {
+		fields.Claims = all
+	}
+
+	*u = UserInfo(fields)
+	return nil
 }
 
 // Valid lets us use the user info as Claim for jwt-go.
@@ -28,7 +70,22 @@ func (u UserInfo) Valid() error {
 	return nil
 }
 
+// AsMap returns the UserInfo with its additional claims as map.
 func (u UserInfo) AsMap() map[string]interface{} {
+	m := map[string]interface{}{}
+	for k, v := range u.Claims {
+		m[k] = v
+	}
+	for _, k := range standardClaimNames {
+		delete(m, k)
+	}
+	for k, v := range u.standardClaims() {
+		m[k] = v
+	}
+	return m
+}
+
+func (u UserInfo) standardClaims() map[string]interface{} {
 	m := map[string]interface{}{
 		"sub": u.Sub,
 	}
@@ -56,5 +113,8 @@ func (u UserInfo) AsMap() map[string]interface{} {
 	if len(u.Groups) > 0 {
 		m["groups"] = u.Groups
 	}
+	if len(u.AMR) > 0 {
+		m["amr"] = u.AMR
+	}
 	return m
 }
@@ -25,6 +25,7 @@ func Test_UserInfo_AsMap(t *testing.T) {
 		Refreshes: 42,
 		Domain:    `json:"domain,omitempty"`,
 		Groups:    []string{`json:"groups,omitempty"`},
+		AMR:       []string{`json:"amr,omitempty"`},
 	}
 
 	givenJson, _ := json.Marshal(u.AsMap())
@@ -45,3 +46,39 @@ func Test_UserInfo_AsMap_Minimal(t *testing.T) {
 	NoError(t, err)
 	Equal(t, u, given)
 }
+
+func Test_UserInfo_Claims(t *testing.T) {
+	u := UserInfo{
+		Sub:    "bob",
+		Origin: "httpupstream",
+		Claims: map[string]interface{}{
+			"department": "builders",
+			"sub":        "alice",
+		},
+	}
+
+	Equal(t, map[string]interface{}{"sub": "bob", "origin": "httpupstream", "department": "builders"}, u.AsMap())
+
+	givenJson, err := json.Marshal(u)
+	NoError(t, err)
+	JSONEq(t, `{"sub": "bob", "origin": "httpupstream", "department": "builders"}`, string(givenJson))
+
+	given := UserInfo{}
+	err = json.Unmarshal(givenJson, &given)
+	NoError(t, err)
+	Equal(t, "bob", given.Sub)
+	Equal(t, map[string]interface{}{"department": "builders"}, given.Claims)
+}
+
+func Test_UserInfo_JSON_WithoutClaims(t *testing.T) {
+	u := UserInfo{Sub: "bob", Expiry: 23}
+
+	givenJson, err := json.Marshal(u)
+	NoError(t, err)
+	JSONEq(t, `{"sub": "bob", "exp": 23}`, string(givenJson))
+
+	given := UserInfo{}
+	err = json.Unmarshal(givenJson, &given)
+	NoError(t, err)
+	Equal(t, u, given)
+}
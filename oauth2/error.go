@@ -0,0 +1,67 @@
+package oauth2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Machine readable classes of oauth errors, which are caused by the user or the provider
+// and not by an internal problem of loginsrv.
+const (
+	// ErrorCodeAccessDenied is used, if the user cancelled the login or denied the access at the provider.
+	ErrorCodeAccessDenied = "access_denied"
+
+	// ErrorCodeStateMismatch is used, if the state parameter does not match the state cookie.
+	ErrorCodeStateMismatch = "state_mismatch"
+
+	// ErrorCodeMissingCode is used, if the provider redirected back without an auth code.
+	ErrorCodeMissingCode = "missing_code"
+
+	// ErrorCodeNotAuthorized is used, if the provider refused to authorize the client or the grant.
+	ErrorCodeNotAuthorized = "not_authorized"
+
+	// ErrorCodeProviderError is used for all other errors reported by the provider.
+	ErrorCodeProviderError = "provider_error"
+)
+
+// ErrInvalidToken is returned by the GetUserInfo of a provider, if the provider rejected the access token.
+var ErrInvalidToken = errors.New("access token rejected by the provider")
+
+// OauthError is an oauth error, which was reported by the provider or detected while verifying the callback.
+type OauthError struct {
+	// Code is the machine readable class of the error, e.g. ErrorCodeAccessDenied
+	Code string
+
+	// ProviderError is the value of the `error` field as returned by the provider, if any
+	ProviderError string
+
+	// Description is a human readable description, e.g. the `error_description` of the provider
+	Description string
+}
+
+func (e *OauthError) Error() string {
+	if e.ProviderError == "" {
+		return "error: " + e.Description
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("error: %v", e.ProviderError)
+	}
+	return fmt.Sprintf("error: %v: %v", e.ProviderError, e.Description)
+}
+
+// NewProviderError creates an OauthError out of the `error` and `error_description`
+// parameters returned by the provider and classifies it by the oauth error code.
+func NewProviderError(providerError, description string) *OauthError {
+	code := ErrorCodeProviderError
+	switch providerError {
+	case "access_denied":
+		code = ErrorCodeAccessDenied
+	case "unauthorized_client", "invalid_client", "unauthorized", "invalid_grant":
+		code = ErrorCodeNotAuthorized
+	}
+	return &OauthError{
+		Code:          code,
+		ProviderError: providerError,
+		Description:   description,
+	}
+}
@@ -0,0 +1,35 @@
+package oauth2
+
+import (
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_NewProviderError(t *testing.T) {
+	tests := []struct {
+		providerError string
+		expectedCode  string
+	}{
+		{"access_denied", ErrorCodeAccessDenied},
+		{"unauthorized_client", ErrorCodeNotAuthorized},
+		{"invalid_client", ErrorCodeNotAuthorized},
+		{"invalid_grant", ErrorCodeNotAuthorized},
+		{"invalid_scope", ErrorCodeProviderError},
+		{"server_error", ErrorCodeProviderError},
+	}
+	for _, test := range tests {
+		t.Run(test.providerError, func(t *testing.T) {
+			e := NewProviderError(test.providerError, "some description")
+			Equal(t, test.expectedCode, e.Code)
+			Equal(t, test.providerError, e.ProviderError)
+			Equal(t, "some description", e.Description)
+		})
+	}
+}
+
+func Test_Error_Message(t *testing.T) {
+	EqualError(t, &OauthError{Code: ErrorCodeStateMismatch, Description: "state mismatch"}, "error: state mismatch")
+	EqualError(t, NewProviderError("access_denied", ""), "error: access_denied")
+	EqualError(t, NewProviderError("access_denied", "cancelled"), "error: access_denied: cancelled")
+}
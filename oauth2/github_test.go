@@ -57,3 +57,17 @@ func Test_Github_getUserInfo(t *testing.T) {
 	Equal(t, "monalisa octocat", u.Name)
 	Equal(t, githubTestUserResponse, rawJSON)
 }
+
+func Test_Github_getUserInfo_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "Bad credentials"}`))
+	}))
+	defer server.Close()
+
+	githubAPI = server.URL
+
+	_, _, err := providerGithub.GetUserInfo(TokenInfo{AccessToken: "invalid"})
+	Equal(t, ErrInvalidToken, err)
+}
@@ -43,6 +43,10 @@ var providerGitlab = Provider{
 		}
 		defer respUser.Body.Close()
 
+		if respUser.StatusCode == http.StatusUnauthorized {
+			return model.UserInfo{}, "", ErrInvalidToken
+		}
+
 		if !strings.Contains(respUser.Header.Get("Content-Type"), "application/json") {
 			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on gitlab get user info: %v", respUser.Header.Get("Content-Type"))
 		}
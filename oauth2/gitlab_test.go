@@ -186,6 +186,20 @@ func Test_Gitlab_getUserInfo_UserStatusCodeNegative(t *testing.T) {
 	Regexp(t, regexp.MustCompile(`^got http status [0-9]{3} on gitlab get user info`), err.Error())
 }
 
+func Test_Gitlab_getUserInfo_InvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "401 Unauthorized"}`))
+	}))
+	defer server.Close()
+
+	gitlabAPI = server.URL
+
+	_, _, err := providerGitlab.GetUserInfo(TokenInfo{AccessToken: "invalid"})
+	Equal(t, ErrInvalidToken, err)
+}
+
 func Test_Gitlab_getUserInfo_GroupsStatusCodeNegative(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/user" {
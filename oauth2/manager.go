@@ -41,7 +41,7 @@ func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
 	err error) {
 
 	if r.FormValue("error") != "" {
-		return false, false, model.UserInfo{}, fmt.Errorf("error: %v", r.FormValue("error"))
+		return false, false, model.UserInfo{}, NewProviderError(r.FormValue("error"), r.FormValue("error_description"))
 	}
 
 	cfg, err := manager.GetConfigFromRequest(r)
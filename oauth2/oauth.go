@@ -56,7 +56,8 @@ type TokenInfo struct {
 
 // JSONError represents an oauth error response in json form.
 type JSONError struct {
-	Error string `json:"error"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
 }
 
 const stateCookieName = "oauthState"
@@ -95,18 +96,18 @@ func StartFlow(cfg Config, w http.ResponseWriter) error {
 // Verify the state parameter againt the state cookie from the request.
 func Authenticate(cfg Config, r *http.Request) (TokenInfo, error) {
 	if r.FormValue("error") != "" {
-		return TokenInfo{}, fmt.Errorf("error: %v", r.FormValue("error"))
+		return TokenInfo{}, NewProviderError(r.FormValue("error"), r.FormValue("error_description"))
 	}
 
 	state := r.FormValue("state")
 	stateCookie, err := r.Cookie(stateCookieName)
 	if err != nil || stateCookie.Value != state {
-		return TokenInfo{}, fmt.Errorf("error: oauth state param could not be verified")
+		return TokenInfo{}, &OauthError{Code: ErrorCodeStateMismatch, Description: "oauth state param could not be verified"}
 	}
 
 	code := r.FormValue("code")
 	if code == "" {
-		return TokenInfo{}, fmt.Errorf("error: no auth code provided")
+		return TokenInfo{}, &OauthError{Code: ErrorCodeMissingCode, Description: "no auth code provided"}
 	}
 	return getAccessToken(cfg, state, code)
 }
@@ -131,18 +132,21 @@ func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return TokenInfo{}, fmt.Errorf("error: expected http status 200 on token exchange, but got %v", resp.StatusCode)
-	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return TokenInfo{}, fmt.Errorf("error reading token exchange response: %q", err)
 	}
 
+	// the provider may report errors with status 200 or 4xx,
+	// so we check the body for an oauth error in both cases
 	jsonError := JSONError{}
 	json.Unmarshal(body, &jsonError)
-	if jsonError.Error != "" {
-		return TokenInfo{}, fmt.Errorf("error: got %q on token exchange", jsonError.Error)
+	if jsonError.Error != "" && (resp.StatusCode == 200 || (resp.StatusCode >= 400 && resp.StatusCode < 500)) {
+		return TokenInfo{}, NewProviderError(jsonError.Error, jsonError.ErrorDescription)
+	}
+
+	if resp.StatusCode != 200 {
+		return TokenInfo{}, fmt.Errorf("error: expected http status 200 on token exchange, but got %v", resp.StatusCode)
 	}
 
 	tokenInfo := TokenInfo{}
@@ -99,7 +99,16 @@ func Test_Authenticate_CodeExchangeError(t *testing.T) {
 	testReturnCode = 200
 	tokenInfo, err = Authenticate(testConfigCopy, request)
 	Error(t, err)
-	EqualError(t, err, `error: got "bad_verification_code" on token exchange`)
+	EqualError(t, err, `error: bad_verification_code: The code passed is incorrect or expired.`)
+	Equal(t, ErrorCodeProviderError, err.(*OauthError).Code)
+	Equal(t, "", tokenInfo.AccessToken)
+
+	testReturnCode = 401
+	testResponseJSON = `{"error":"invalid_client","error_description":"Client authentication failed."}`
+	tokenInfo, err = Authenticate(testConfigCopy, request)
+	Error(t, err)
+	Equal(t, ErrorCodeNotAuthorized, err.(*OauthError).Code)
+	Equal(t, "Client authentication failed.", err.(*OauthError).Description)
 	Equal(t, "", tokenInfo.AccessToken)
 
 	testReturnCode = 200
@@ -119,6 +128,19 @@ func Test_Authentication_ProviderError(t *testing.T) {
 
 	Error(t, err)
 	Equal(t, "error: provider_login_error", err.Error())
+	Equal(t, ErrorCodeProviderError, err.(*OauthError).Code)
+}
+
+func Test_Authentication_ProviderAccessDenied(t *testing.T) {
+	request, _ := http.NewRequest("GET", testConfig.RedirectURI, nil)
+	request.URL, _ = url.Parse("http://localhost/callback?error=access_denied&error_description=The+user+has+denied+your+application+access.")
+
+	_, err := Authenticate(testConfig, request)
+
+	Error(t, err)
+	Equal(t, "error: access_denied: The user has denied your application access.", err.Error())
+	Equal(t, ErrorCodeAccessDenied, err.(*OauthError).Code)
+	Equal(t, "The user has denied your application access.", err.(*OauthError).Description)
 }
 
 func Test_Authentication_StateError(t *testing.T) {
@@ -130,6 +152,7 @@ func Test_Authentication_StateError(t *testing.T) {
 
 	Error(t, err)
 	Equal(t, "error: oauth state param could not be verified", err.Error())
+	Equal(t, ErrorCodeStateMismatch, err.(*OauthError).Code)
 }
 
 func Test_Authentication_NoCodeError(t *testing.T) {
@@ -141,6 +164,7 @@ func Test_Authentication_NoCodeError(t *testing.T) {
 
 	Error(t, err)
 	Equal(t, "error: no auth code provided", err.Error())
+	Equal(t, ErrorCodeMissingCode, err.(*OauthError).Code)
 }
 
 func Test_Authentication_Provider500(t *testing.T) {
@@ -1,44 +1,170 @@
 package osiam
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/tarent/loginsrv/login"
 	"github.com/tarent/loginsrv/model"
 	"net/url"
+	"time"
 )
 
+// sessionClaim is the token claim with the id of the session, which holds the osiam refresh token
+const sessionClaim = "sid"
+
+// DefaultSessionTTL is the time, after which a session without refresh is removed from the MemorySessionStore
+const DefaultSessionTTL = 24 * time.Hour
+
+// Config for the osiam backend
+type Config struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+
+	// FetchProfile loads the name, email, picture and groups of the user from the SCIM /Me resource.
+	// This needs the scope ME for the client.
+	FetchProfile bool
+
+	// RefreshTokens keeps the osiam refresh token of each session and confirms
+	// every refresh of the loginsrv token by the refresh token grant.
+	RefreshTokens bool
+
+	// SessionStore for the refresh tokens, a MemorySessionStore with the DefaultSessionTTL by default.
+	// The MemorySessionStore loses all sessions on a restart and is not shared between instances.
+	SessionStore SessionStore
+}
+
 // Backend is the osiam authentication backend.
 type Backend struct {
-	client *Client
+	client        *Client
+	fetchProfile  bool
+	refreshTokens bool
+	sessions      SessionStore
 }
 
 // NewBackend creates a new OSIAM Backend and verifies the parameters.
-func NewBackend(endpoint, clientID, clientSecret string) (*Backend, error) {
-	if _, err := url.Parse(endpoint); err != nil {
-		return nil, fmt.Errorf("osiam endpoint has to be a valid url: %v: %v", endpoint, err)
+func NewBackend(config Config) (*Backend, error) {
+	if _, err := url.Parse(config.Endpoint); err != nil {
+		return nil, fmt.Errorf("osiam endpoint has to be a valid url: %v: %v", config.Endpoint, err)
 	}
 
-	if clientID == "" {
+	if config.ClientID == "" {
 		return nil, errors.New("no osiam clientID provided.")
 	}
-	if clientSecret == "" {
+	if config.ClientSecret == "" {
 		return nil, errors.New("no osiam clientSecret provided")
 	}
-	client := NewClient(endpoint, clientID, clientSecret)
+	client := NewClient(config.Endpoint, config.ClientID, config.ClientSecret)
+	sessions := config.SessionStore
+	if sessions == nil {
+		sessions = NewMemorySessionStore(DefaultSessionTTL)
+	}
+
 	return &Backend{
-		client: client,
+		client:        client,
+		fetchProfile:  config.FetchProfile,
+		refreshTokens: config.RefreshTokens,
+		sessions:      sessions,
 	}, nil
 }
 
 // Authenticate the user
 func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
-	authenticated, _, err := b.client.GetTokenByPassword(username, password)
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext authenticates the user like Authenticate. The requests to osiam are cancelled with the context.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username := req.Username
+	authenticated, token, err := b.client.GetTokenByPasswordContext(ctx, username, req.Password)
 	if !authenticated || err != nil {
 		return authenticated, model.UserInfo{}, err
 	}
+
 	userInfo := model.UserInfo{
 		Origin: OsiamProviderName,
 		Sub:    username,
 	}
+
+	if b.fetchProfile {
+		if err := b.updateProfile(ctx, &userInfo, token); err != nil {
+			return false, model.UserInfo{}, err
+		}
+	}
+
+	if b.refreshTokens {
+		if token.RefreshToken == "" {
+			return false, model.UserInfo{}, errors.New("osiam returned no refresh token, please allow the refresh_token grant for the client")
+		}
+		sessionID, err := newSessionID()
+		if err != nil {
+			return false, model.UserInfo{}, err
+		}
+		b.sessions.Put(sessionID, token.RefreshToken)
+		userInfo.Claims = map[string]interface{}{sessionClaim: sessionID}
+	}
+
 	return true, userInfo, nil
 }
+
+// Refresh confirms the refresh of a token by the refresh token grant against osiam.
+// The refresh is rejected, if the session is unknown or osiam rejects the refresh token, e.g. because the user was disabled.
+func (b *Backend) Refresh(userInfo model.UserInfo) (bool, model.UserInfo, error) {
+	if !b.refreshTokens || userInfo.Origin != OsiamProviderName {
+		return true, userInfo, nil
+	}
+
+	sessionID, _ := userInfo.Claims[sessionClaim].(string)
+	refreshToken, exist := b.sessions.Get(sessionID)
+	if !exist {
+		return false, userInfo, nil
+	}
+
+	authenticated, token, err := b.client.GetTokenByRefreshToken(refreshToken)
+	if _, rejected := err.(*login.AuthError); rejected {
+		authenticated, err = false, nil
+	}
+	if err != nil {
+		return false, userInfo, err
+	}
+	if !authenticated {
+		b.sessions.Delete(sessionID)
+		return false, userInfo, nil
+	}
+
+	// osiam may issue a new refresh token
+	if token.RefreshToken != "" {
+		refreshToken = token.RefreshToken
+	}
+	b.sessions.Put(sessionID, refreshToken)
+
+	if b.fetchProfile {
+		if err := b.updateProfile(context.Background(), &userInfo, token); err != nil {
+			return false, userInfo, err
+		}
+	}
+	return true, userInfo, nil
+}
+
+func (b *Backend) updateProfile(ctx context.Context, userInfo *model.UserInfo, token *Token) error {
+	user, err := b.client.GetMeContext(ctx, token)
+	if err != nil {
+		return err
+	}
+	userInfo.Name = user.FullName()
+	userInfo.Email = user.PrimaryEmail()
+	userInfo.Picture = user.PrimaryPhoto()
+	userInfo.Groups = user.GroupNames()
+	return nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
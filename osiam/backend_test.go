@@ -1,11 +1,16 @@
 package osiam
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
 	"github.com/tarent/loginsrv/model"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestBackend_Authenticate(t *testing.T) {
@@ -13,7 +18,7 @@ func TestBackend_Authenticate(t *testing.T) {
 	defer server.Close()
 
 	// positive case
-	backend, err := NewBackend(server.URL, "example-client", "secret")
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret"})
 	NoError(t, err)
 	authenticated, userInfo, err := backend.Authenticate("admin", "koala")
 
@@ -27,14 +32,14 @@ func TestBackend_Authenticate(t *testing.T) {
 		userInfo)
 
 	// wrong client credentials
-	backend, err = NewBackend(server.URL, "example-client", "XXX")
+	backend, err = NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "XXX"})
 	NoError(t, err)
 	authenticated, _, err = backend.Authenticate("admin", "koala")
 	Error(t, err)
 	False(t, authenticated)
 
 	// wrong user credentials
-	backend, err = NewBackend(server.URL, "example-client", "secret")
+	backend, err = NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret"})
 	NoError(t, err)
 	authenticated, _, err = backend.Authenticate("admin", "XXX")
 	NoError(t, err)
@@ -43,12 +48,215 @@ func TestBackend_Authenticate(t *testing.T) {
 }
 
 func TestBackend_AuthenticateErrorCases(t *testing.T) {
-	_, err := NewBackend("://", "example-client", "secret")
+	_, err := NewBackend(Config{Endpoint: "://", ClientID: "example-client", ClientSecret: "secret"})
 	Error(t, err)
 
-	_, err = NewBackend("http://example.com", "", "secret")
+	_, err = NewBackend(Config{Endpoint: "http://example.com", ClientSecret: "secret"})
 	Error(t, err)
 
-	_, err = NewBackend("http://example.com", "example-client", "")
+	_, err = NewBackend(Config{Endpoint: "http://example.com", ClientID: "example-client"})
 	Error(t, err)
 }
+
+func TestBackend_AuthenticateRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret"})
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("expired", "koala")
+	False(t, authenticated)
+	Equal(t, login.NewAuthError(login.AuthErrorPasswordExpired, "User credentials have expired"), err)
+}
+
+func TestBackend_AuthenticateWithProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret", FetchProfile: true})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("admin", "koala")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t,
+		model.UserInfo{
+			Origin:  "osiam",
+			Sub:     "admin",
+			Name:    "Ad Min",
+			Email:   "admin@example.org",
+			Picture: "https://example.com/admin.jpg",
+			Groups:  []string{"admins", "0b6f4e4c-2c1c-4e7c-9f5d-b3b8c2a5d5e1"},
+		},
+		userInfo)
+}
+
+func TestBackend_AuthenticateWithProfileError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Me" {
+			w.WriteHeader(403)
+			fmt.Fprintf(w, `{"error":"insufficient_scope","error_description":"Insufficient scope for this resource"}`)
+			return
+		}
+		osiamMockHandler(w, r)
+	}))
+	defer server.Close()
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret", FetchProfile: true})
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("admin", "koala")
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_AuthenticateContext(t *testing.T) {
+	// osiam does not answer before the end of the test
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret"})
+	NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	authenticated, _, err := backend.AuthenticateContext(ctx, login.LoginRequest{Username: "admin", Password: "koala"})
+	False(t, authenticated)
+	True(t, errors.Is(err, context.DeadlineExceeded), "%v", err)
+}
+
+func TestBackendFactory(t *testing.T) {
+	p, exist := login.GetProvider(OsiamProviderName)
+	True(t, exist)
+
+	backend, err := p(map[string]string{"endpoint": "http://localhost:8080", "client_id": "example-client", "client_secret": "secret"})
+	NoError(t, err)
+	False(t, backend.(*Backend).fetchProfile)
+	False(t, backend.(*Backend).refreshTokens)
+	Equal(t, "example-client", backend.(*Backend).client.ClientID)
+
+	backend, err = p(map[string]string{"endpoint": "http://localhost:8080", "clientId": "example-client", "clientSecret": "secret", "fetch_profile": "true"})
+	NoError(t, err)
+	True(t, backend.(*Backend).fetchProfile)
+	Equal(t, "secret", backend.(*Backend).client.ClientSecret)
+
+	backend, err = p(map[string]string{"endpoint": "http://localhost:8080", "client_id": "example-client", "client_secret": "secret", "refresh_tokens": "true", "session_ttl": "1h"})
+	NoError(t, err)
+	True(t, backend.(*Backend).refreshTokens)
+	Equal(t, time.Hour, backend.(*Backend).sessions.(*MemorySessionStore).ttl)
+
+	_, err = p(map[string]string{"endpoint": "http://localhost:8080", "client_id": "example-client", "client_secret": "secret", "fetch_profile": "maybe"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"endpoint": "http://localhost:8080", "client_id": "example-client", "client_secret": "secret", "refresh_tokens": "maybe"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"endpoint": "http://localhost:8080", "client_id": "example-client", "client_secret": "secret", "session_ttl": "forever"})
+	Error(t, err)
+}
+
+func TestBackend_Refresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	store := NewMemorySessionStore(time.Minute)
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret", RefreshTokens: true, SessionStore: store})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("admin", "koala")
+	NoError(t, err)
+	True(t, authenticated)
+	sessionID, _ := userInfo.Claims["sid"].(string)
+	Len(t, sessionID, 32)
+	refreshToken, exist := store.Get(sessionID)
+	True(t, exist)
+	Equal(t, "15b22304-f838-48c2-9c40-18bf285060a6", refreshToken)
+
+	// confirmed refresh
+	confirmed, refreshed, err := backend.Refresh(userInfo)
+	NoError(t, err)
+	True(t, confirmed)
+	Equal(t, userInfo, refreshed)
+
+	// tokens of other backends are not checked
+	confirmed, _, err = backend.Refresh(model.UserInfo{Sub: "bob", Origin: "htpasswd"})
+	NoError(t, err)
+	True(t, confirmed)
+
+	// unknown session
+	confirmed, _, err = backend.Refresh(model.UserInfo{Sub: "admin", Origin: "osiam", Claims: map[string]interface{}{"sid": "unknown"}})
+	NoError(t, err)
+	False(t, confirmed)
+
+	confirmed, _, err = backend.Refresh(model.UserInfo{Sub: "admin", Origin: "osiam"})
+	NoError(t, err)
+	False(t, confirmed)
+
+	// refresh token rejected by osiam, e.g. because the user was disabled
+	store.Put(sessionID, "XXX")
+	confirmed, _, err = backend.Refresh(userInfo)
+	NoError(t, err)
+	False(t, confirmed)
+	_, exist = store.Get(sessionID)
+	False(t, exist)
+}
+
+func TestBackend_RefreshWithProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret", RefreshTokens: true, FetchProfile: true})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("admin", "koala")
+	NoError(t, err)
+	True(t, authenticated)
+
+	userInfo.Name = "outdated"
+	confirmed, refreshed, err := backend.Refresh(userInfo)
+	NoError(t, err)
+	True(t, confirmed)
+	Equal(t, "Ad Min", refreshed.Name)
+}
+
+func TestBackend_RefreshError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+
+	backend, err := NewBackend(Config{Endpoint: server.URL, ClientID: "example-client", ClientSecret: "secret", RefreshTokens: true})
+	NoError(t, err)
+
+	_, userInfo, err := backend.Authenticate("admin", "koala")
+	NoError(t, err)
+
+	server.Close()
+	confirmed, _, err := backend.Refresh(userInfo)
+	Error(t, err)
+	False(t, confirmed)
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore(50 * time.Millisecond)
+	store.Put("a", "token-a")
+
+	token, exist := store.Get("a")
+	True(t, exist)
+	Equal(t, "token-a", token)
+
+	store.Delete("a")
+	_, exist = store.Get("a")
+	False(t, exist)
+
+	// expired sessions
+	store.Put("b", "token-b")
+	time.Sleep(60 * time.Millisecond)
+	_, exist = store.Get("b")
+	False(t, exist)
+
+	store.Put("c", "token-c")
+	Len(t, store.sessions, 1)
+}
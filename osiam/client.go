@@ -1,12 +1,15 @@
 package osiam
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/tarent/loginsrv/login"
 )
 
 // Client is a wrapper for the osiam API.
@@ -27,14 +30,31 @@ func NewClient(endpoint string, clientID string, clientSecret string) *Client {
 
 // GetTokenByPassword does an Osiam authorisation by Resource Owner Password Credentials Grant.
 // If no scopes are supplied, the default scope is 'me'.
+// A *login.AuthError is returned, if osiam rejects a known user, e.g. because the account is locked.
 func (c *Client) GetTokenByPassword(username, password string, scopes ...string) (authenticated bool, token *Token, err error) {
+	return c.GetTokenByPasswordContext(context.Background(), username, password, scopes...)
+}
+
+// GetTokenByPasswordContext is GetTokenByPassword with a request, which is cancelled with the context.
+func (c *Client) GetTokenByPasswordContext(ctx context.Context, username, password string, scopes ...string) (authenticated bool, token *Token, err error) {
 	scopeList := strings.Join(scopes, ",")
 	if scopeList == "" {
 		scopeList = "ME"
 	}
 
 	reqBody := fmt.Sprintf("grant_type=password&username=%v&password=%v&scope=%v", url.QueryEscape(username), url.QueryEscape(password), scopeList)
-	req, err := http.NewRequest("POST", c.Endpoint+"/oauth/token", strings.NewReader(reqBody))
+	return c.requestToken(ctx, reqBody)
+}
+
+// GetTokenByRefreshToken does an Osiam authorisation by Refresh Token Grant.
+// It returns false, if the refresh token was rejected, e.g. because it is expired or the user was disabled.
+func (c *Client) GetTokenByRefreshToken(refreshToken string) (authenticated bool, token *Token, err error) {
+	reqBody := fmt.Sprintf("grant_type=refresh_token&refresh_token=%v", url.QueryEscape(refreshToken))
+	return c.requestToken(context.Background(), reqBody)
+}
+
+func (c *Client) requestToken(ctx context.Context, reqBody string) (authenticated bool, token *Token, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint+"/oauth/token", strings.NewReader(reqBody))
 	if err != nil {
 		return false, nil, err
 	}
@@ -46,6 +66,7 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 	if err != nil {
 		return false, nil, err
 	}
+	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -71,6 +92,10 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 
 	errorMessage := ParseOsiamError(body)
 
+	if code := errorMessage.AuthErrorCode(); code != "" { // e.g. locked user
+		return false, nil, login.NewAuthError(code, errorMessage.Message)
+	}
+
 	if errorMessage.IsLoginError() { // wrong user credentials
 		return false, nil, nil
 	}
@@ -82,6 +107,43 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 	return false, nil, fmt.Errorf("Osiam error: %v, %v (http status %v)", errorMessage.Error, errorMessage.Message, res.StatusCode)
 }
 
+// GetMe fetches the SCIM user resource of the owner of the access token.
+func (c *Client) GetMe(token *Token) (*User, error) {
+	return c.GetMeContext(context.Background(), token)
+}
+
+// GetMeContext is GetMe with a request, which is cancelled with the context.
+func (c *Client) GetMeContext(ctx context.Context, token *Token) (*User, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.Endpoint+"/Me", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != 200 {
+		errorMessage := ParseOsiamError(body)
+		return nil, fmt.Errorf("Osiam error on fetching the user profile: %v, %v (http status %v)", errorMessage.Error, errorMessage.Message, res.StatusCode)
+	}
+
+	user := &User{}
+	if err := json.Unmarshal(body, user); err != nil {
+		return nil, fmt.Errorf("Osiam user profile is no valid json: %v", err)
+	}
+	return user, nil
+}
+
 func isJSON(contentType string) bool {
 	return strings.HasPrefix(contentType, "application/json")
 }
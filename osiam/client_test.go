@@ -3,6 +3,7 @@ package osiam
 import (
 	"fmt"
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -47,6 +48,11 @@ func TestClient_GetTokenByPasswordErrorCases(t *testing.T) {
 	NoError(t, err)
 	False(t, authenticated)
 
+	// locked user
+	authenticated, _, err = client.GetTokenByPassword("locked", "koala")
+	Equal(t, login.NewAuthError(login.AuthErrorAccountLocked, "User account is locked"), err)
+	False(t, authenticated)
+
 	// wrong url -> 404
 	client = NewClient(server.URL+"/Foo", "example-client", "secret")
 	_, _, err = client.GetTokenByPassword("admin", "koala")
@@ -92,6 +98,57 @@ func TestClient_GetTokenByPasswordUnknownError(t *testing.T) {
 	Error(t, err)
 }
 
+func TestClient_GetTokenByRefreshToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	client := NewClient(server.URL, "example-client", "secret")
+	authenticated, token, err := client.GetTokenByRefreshToken("15b22304-f838-48c2-9c40-18bf285060a6")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef", token.AccessToken)
+
+	// rejected refresh token
+	authenticated, _, err = client.GetTokenByRefreshToken("XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// wrong client secret
+	client = NewClient(server.URL, "example-client", "XXX")
+	_, _, err = client.GetTokenByRefreshToken("15b22304-f838-48c2-9c40-18bf285060a6")
+	Error(t, err)
+}
+
+func TestClient_GetMe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(osiamMockHandler))
+	defer server.Close()
+
+	client := NewClient(server.URL, "example-client", "secret")
+	user, err := client.GetMe(&Token{AccessToken: "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef"})
+	NoError(t, err)
+	Equal(t, "admin", user.UserName)
+	Equal(t, "Ad Min", user.FullName())
+	Equal(t, "admin@example.org", user.PrimaryEmail())
+	Equal(t, "https://example.com/admin.jpg", user.PrimaryPhoto())
+	Equal(t, []string{"admins", "0b6f4e4c-2c1c-4e7c-9f5d-b3b8c2a5d5e1"}, user.GroupNames())
+
+	// invalid token
+	_, err = client.GetMe(&Token{AccessToken: "XXX"})
+	Error(t, err)
+}
+
+func TestClient_GetMeInvalidJson(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "{...")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "example-client", "secret")
+	_, err := client.GetMe(&Token{AccessToken: "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef"})
+	Error(t, err)
+}
+
 func TestClient_GetTokenByPasswordNoServer(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	}))
@@ -103,6 +160,11 @@ func TestClient_GetTokenByPasswordNoServer(t *testing.T) {
 }
 
 func osiamMockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && r.URL.Path == "/Me" {
+		osiamMeMockHandler(w, r)
+		return
+	}
+
 	if r.Method != "POST" {
 		w.WriteHeader(400)
 		fmt.Fprintf(w, `Method not supported`)
@@ -124,7 +186,19 @@ func osiamMockHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	b, _ := ioutil.ReadAll(r.Body)
 
-	if string(b) != "grant_type=password&username=admin&password=koala&scope=ME" {
+	if string(b) == "grant_type=password&username=locked&password=koala&scope=ME" {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error":"invalid_grant","error_description":"User account is locked"}`)
+		return
+	}
+	if string(b) == "grant_type=password&username=expired&password=koala&scope=ME" {
+		w.WriteHeader(400)
+		fmt.Fprintf(w, `{"error":"invalid_grant","error_description":"User credentials have expired"}`)
+		return
+	}
+
+	if string(b) != "grant_type=password&username=admin&password=koala&scope=ME" &&
+		string(b) != "grant_type=refresh_token&refresh_token=15b22304-f838-48c2-9c40-18bf285060a6" {
 		w.WriteHeader(400)
 		fmt.Fprintf(w, `{"error":"invalid_grant","error_description":"some message!"}`)
 		return
@@ -143,3 +217,27 @@ func osiamMockHandler(w http.ResponseWriter, r *http.Request) {
                 "access_token" : "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef",
                 "scope" : "ME"}`)
 }
+
+func osiamMeMockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/scim+json;charset=UTF-8")
+	if r.Header.Get("Authorization") != "Bearer 59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef" {
+		w.WriteHeader(401)
+		fmt.Fprintf(w, `{"error":"invalid_token","error_description":"Invalid access token"}`)
+		return
+	}
+	w.WriteHeader(200)
+	fmt.Fprintf(w, `{
+                "id": "84f6cffa-4505-48ec-a851-424160892283",
+                "userName": "admin",
+                "displayName": "Ad Min",
+                "name": {"givenName": "Ad", "familyName": "Min"},
+                "emails": [
+                        {"value": "admin@example.com", "type": "work"},
+                        {"value": "admin@example.org", "type": "home", "primary": true}
+                ],
+                "photos": [{"value": "https://example.com/admin.jpg", "type": "photo"}],
+                "groups": [
+                        {"value": "a7b5ca4b-7d1b-4bd1-a4b6-2cdbe0fd8d4d", "display": "admins"},
+                        {"value": "0b6f4e4c-2c1c-4e7c-9f5d-b3b8c2a5d5e1"}
+                ]}`)
+}
@@ -2,6 +2,9 @@ package osiam
 
 import (
 	"encoding/json"
+	"strings"
+
+	"github.com/tarent/loginsrv/login"
 )
 
 // OsiamError represents an error response from osiam.
@@ -57,3 +60,21 @@ func (e OsiamError) IsLoginError() bool {
 func (e OsiamError) IsUnauthorized() bool {
 	return e.Error == "Unauthorized"
 }
+
+// AuthErrorCode returns the reason of a rejected grant for a known user, e.g. login.AuthErrorAccountLocked.
+// It returns an empty string for other errors and wrong credentials.
+func (e OsiamError) AuthErrorCode() string {
+	if !e.IsLoginError() {
+		return ""
+	}
+	message := strings.ToLower(e.Message)
+	switch {
+	case strings.Contains(message, "credentials have expired"):
+		return login.AuthErrorPasswordExpired
+	case strings.Contains(message, "locked"),
+		strings.Contains(message, "disabled"),
+		strings.Contains(message, "account has expired"):
+		return login.AuthErrorAccountLocked
+	}
+	return ""
+}
@@ -2,6 +2,7 @@ package osiam
 
 import (
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
 	"testing"
 )
 
@@ -12,3 +13,21 @@ func TestError_ParseOsiamError_CornerCases(t *testing.T) {
 	e = ParseOsiamError([]byte("{}"))
 	Equal(t, "client_parse_error", e.Error)
 }
+
+func TestError_AuthErrorCode(t *testing.T) {
+	tests := []struct {
+		err  OsiamError
+		code string
+	}{
+		{OsiamError{"invalid_grant", "Bad credentials"}, ""},
+		{OsiamError{"invalid_grant", "User account is locked"}, login.AuthErrorAccountLocked},
+		{OsiamError{"invalid_grant", "The user with the username 'bob' is temporary locked."}, login.AuthErrorAccountLocked},
+		{OsiamError{"invalid_grant", "User is disabled"}, login.AuthErrorAccountLocked},
+		{OsiamError{"invalid_grant", "User account has expired"}, login.AuthErrorAccountLocked},
+		{OsiamError{"invalid_grant", "User credentials have expired"}, login.AuthErrorPasswordExpired},
+		{OsiamError{"Unauthorized", "User account is locked"}, ""},
+	}
+	for _, test := range tests {
+		Equal(t, test.code, test.err.AuthErrorCode(), test.err.Message)
+	}
+}
@@ -0,0 +1,75 @@
+package osiam
+
+import (
+	"sync"
+	"time"
+)
+
+// SessionStore keeps the osiam refresh tokens of the loginsrv sessions.
+type SessionStore interface {
+	// Put stores the refresh token for the session.
+	Put(sessionID, refreshToken string)
+
+	// Get returns the refresh token of the session, or false, if the session is unknown or expired.
+	Get(sessionID string) (string, bool)
+
+	// Delete removes the session.
+	Delete(sessionID string)
+}
+
+// MemorySessionStore is a SessionStore, which keeps the sessions in memory.
+// The sessions are lost on restart, so the users have to login again afterwards.
+type MemorySessionStore struct {
+	ttl      time.Duration
+	sessions map[string]memorySession
+	mu       sync.Mutex
+}
+
+type memorySession struct {
+	refreshToken string
+	expiresAt    time.Time
+}
+
+// NewMemorySessionStore creates a MemorySessionStore, where a session expires after ttl without a refresh.
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{
+		ttl:      ttl,
+		sessions: map[string]memorySession{},
+	}
+}
+
+// Put stores the refresh token for the session and removes all expired sessions.
+func (s *MemorySessionStore) Put(sessionID, refreshToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range s.sessions {
+		if now.After(session.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[sessionID] = memorySession{
+		refreshToken: refreshToken,
+		expiresAt:    now.Add(s.ttl),
+	}
+}
+
+// Get returns the refresh token of the session.
+func (s *MemorySessionStore) Get(sessionID string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exist := s.sessions[sessionID]
+	if !exist || time.Now().After(session.expiresAt) {
+		return "", false
+	}
+	return session.refreshToken, true
+}
+
+// Delete removes the session.
+func (s *MemorySessionStore) Delete(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+}
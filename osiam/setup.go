@@ -1,6 +1,10 @@
 package osiam
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/login"
 )
@@ -12,13 +16,48 @@ func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     OsiamProviderName,
-			HelpText: "Osiam login backend opts: endpoint=..,client_id=..,client_secret=..",
+			HelpText: "Osiam login backend opts: endpoint=..,client_id=..,client_secret=..[,fetch_profile=true|false,refresh_tokens=true|false,session_ttl=..]",
 		},
-		func(config map[string]string) (login.Backend, error) {
-			if config["clientId"] != "" {
-				logging.Logger.Warn("DEPRECATED: please use 'client_id' and 'client_secret' in future.")
-				return NewBackend(config["endpoint"], config["clientId"], config["clientSecret"])
-			}
-			return NewBackend(config["endpoint"], config["client_id"], config["client_secret"])
-		})
+		BackendFactory)
+}
+
+// BackendFactory creates an osiam backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	config := Config{
+		Endpoint:     opts["endpoint"],
+		ClientID:     opts["client_id"],
+		ClientSecret: opts["client_secret"],
+	}
+
+	if opts["clientId"] != "" {
+		logging.Logger.Warn("DEPRECATED: please use 'client_id' and 'client_secret' in future.")
+		config.ClientID = opts["clientId"]
+		config.ClientSecret = opts["clientSecret"]
+	}
+
+	if v, exist := opts["fetch_profile"]; exist {
+		var err error
+		config.FetchProfile, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "fetch_profile" osiam provider: %v`, v, err)
+		}
+	}
+
+	if v, exist := opts["refresh_tokens"]; exist {
+		var err error
+		config.RefreshTokens, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "refresh_tokens" osiam provider: %v`, v, err)
+		}
+	}
+
+	if v, exist := opts["session_ttl"]; exist {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "session_ttl" osiam provider: %v`, v, err)
+		}
+		config.SessionStore = NewMemorySessionStore(ttl)
+	}
+
+	return NewBackend(config)
 }
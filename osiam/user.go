@@ -0,0 +1,74 @@
+package osiam
+
+// User is the SCIM representation of an osiam user, as returned by the /Me resource.
+// Only the attributes used for the user info are mapped.
+type User struct {
+	ID          string `json:"id"`
+	UserName    string `json:"userName"`
+	DisplayName string `json:"displayName"`
+	Name        struct {
+		Formatted  string `json:"formatted"`
+		GivenName  string `json:"givenName"`
+		FamilyName string `json:"familyName"`
+	} `json:"name"`
+	Emails []MultiValuedAttribute `json:"emails"`
+	Photos []MultiValuedAttribute `json:"photos"`
+	Groups []MultiValuedAttribute `json:"groups"`
+}
+
+// MultiValuedAttribute is an entry of a SCIM multi valued attribute, like emails or groups.
+type MultiValuedAttribute struct {
+	Value   string `json:"value"`
+	Display string `json:"display"`
+	Type    string `json:"type"`
+	Primary bool   `json:"primary"`
+}
+
+// FullName returns the display name of the user, or the name built out of its parts.
+func (u *User) FullName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	if u.Name.GivenName != "" && u.Name.FamilyName != "" {
+		return u.Name.GivenName + " " + u.Name.FamilyName
+	}
+	return u.Name.GivenName + u.Name.FamilyName
+}
+
+// PrimaryEmail returns the primary email or the first one, if none is marked as primary.
+func (u *User) PrimaryEmail() string {
+	return primaryValue(u.Emails)
+}
+
+// PrimaryPhoto returns the url of the primary photo or the first one, if none is marked as primary.
+func (u *User) PrimaryPhoto() string {
+	return primaryValue(u.Photos)
+}
+
+// GroupNames returns the display names of the groups. For groups without a display name, the id is used.
+func (u *User) GroupNames() []string {
+	var names []string
+	for _, g := range u.Groups {
+		if g.Display != "" {
+			names = append(names, g.Display)
+		} else if g.Value != "" {
+			names = append(names, g.Value)
+		}
+	}
+	return names
+}
+
+func primaryValue(values []MultiValuedAttribute) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package pwhash
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/abbot/go-http-auth"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+)
+
+// The names of the supported hash algorithms, as returned by HashAlgorithm.
+const (
+	AlgorithmBcrypt      = "bcrypt"
+	AlgorithmSHA         = "sha"
+	AlgorithmAPR1        = "apr1"
+	AlgorithmArgon2id    = "argon2id"
+	AlgorithmScrypt      = "scrypt"
+	AlgorithmSHA256Crypt = "sha256-crypt"
+	AlgorithmSHA512Crypt = "sha512-crypt"
+)
+
+// ErrUnknownAlgorithm is returned by CompareHash, if the format of the hash is not supported.
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// HashAlgorithm detects the algorithm of a password hash by its prefix.
+// It returns an empty string, if the format is not supported.
+func HashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2a$"):
+		return AlgorithmBcrypt
+	case strings.HasPrefix(hash, "{SHA}"):
+		return AlgorithmSHA
+	case strings.HasPrefix(hash, "$apr1$"):
+		return AlgorithmAPR1
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(hash, "$scrypt$"):
+		return AlgorithmScrypt
+	case strings.HasPrefix(hash, "$5$"):
+		return AlgorithmSHA256Crypt
+	case strings.HasPrefix(hash, "$6$"):
+		return AlgorithmSHA512Crypt
+	}
+	return ""
+}
+
+// CompareHash checks the password against a hash in one of the supported formats:
+// bcrypt ($2y$, $2b$, $2a$), SHA1 ({SHA}), Apache MD5 ($apr1$), argon2id ($argon2id$),
+// scrypt ($scrypt$ln=..,r=..,p=..$salt$hash) and the glibc SHA-crypt ($5$, $6$).
+// It returns ErrUnknownAlgorithm, if the hash format is not supported.
+func CompareHash(hash, password string) (bool, error) {
+	h := []byte(hash)
+	p := []byte(password)
+	switch HashAlgorithm(hash) {
+	case AlgorithmBcrypt:
+		matchErr := bcrypt.CompareHashAndPassword(h, p)
+		return (matchErr == nil), nil
+	case AlgorithmSHA:
+		return compareSha(h, p), nil
+	case AlgorithmAPR1:
+		return compareMD5(h, p), nil
+	case AlgorithmArgon2id:
+		return compareArgon2id(hash, p)
+	case AlgorithmScrypt:
+		return compareScrypt(hash, p)
+	case AlgorithmSHA256Crypt, AlgorithmSHA512Crypt:
+		computed, ok := shaCrypt(hash, p)
+		return ok && 1 == subtle.ConstantTimeCompare(h, []byte(computed)), nil
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+func compareSha(hashedPassword, password []byte) bool {
+	d := sha1.New()
+	d.Write(password)
+	return 1 == subtle.ConstantTimeCompare(hashedPassword[5:], []byte(base64.StdEncoding.EncodeToString(d.Sum(nil))))
+}
+
+func compareMD5(hashedPassword, password []byte) bool {
+	parts := bytes.SplitN(hashedPassword, []byte("$"), 4)
+	if len(parts) != 4 {
+		return false
+	}
+	magic := []byte("$" + string(parts[1]) + "$")
+	salt := parts[2]
+	return 1 == subtle.ConstantTimeCompare(hashedPassword, auth.MD5Crypt(password, salt, magic))
+}
+
+// compareArgon2id checks a hash in the PHC string format: $argon2id$v=19$m=65536,t=3,p=4$salt$hash
+func compareArgon2id(hashedPassword string, password []byte) (bool, error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return false, errors.New("invalid argon2id hash format")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2id version: %v", parts[2])
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, fmt.Errorf("invalid argon2id parameters: %v", parts[3])
+	}
+
+	salt, err := decodeBase64(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+	key, err := decodeBase64(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("invalid argon2id hash: %v", err)
+	}
+
+	computed := argon2.IDKey(password, salt, time, memory, threads, uint32(len(key)))
+	return 1 == subtle.ConstantTimeCompare(key, computed), nil
+}
+
+// compareScrypt checks a hash in the PHC like format of passlib: $scrypt$ln=16,r=8,p=1$salt$hash
+func compareScrypt(hashedPassword string, password []byte) (bool, error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 5 {
+		return false, errors.New("invalid scrypt hash format")
+	}
+
+	var ln, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p); err != nil || ln < 1 || ln > 30 {
+		return false, fmt.Errorf("invalid scrypt parameters: %v", parts[2])
+	}
+
+	salt, err := decodeBase64(parts[3])
+	if err != nil {
+		return false, fmt.Errorf("invalid scrypt salt: %v", err)
+	}
+	key, err := decodeBase64(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("invalid scrypt hash: %v", err)
+	}
+
+	computed, err := scrypt.Key(password, salt, 1<<uint(ln), r, p, len(key))
+	if err != nil {
+		return false, fmt.Errorf("invalid scrypt parameters: %v", err)
+	}
+	return 1 == subtle.ConstantTimeCompare(key, computed), nil
+}
+
+// decodeBase64 decodes the unpadded base64 of the PHC string format.
+// The adapted alphabet of passlib, which uses '.' instead of '+', is supported as well.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Replace(strings.TrimRight(s, "="), ".", "+", -1)
+	return base64.RawStdEncoding.DecodeString(s)
+}
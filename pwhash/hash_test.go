@@ -0,0 +1,91 @@
+package pwhash
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCompareHash(t *testing.T) {
+	// password for all of them is 'secret'
+	hashes := map[string]string{
+		"md5":          "$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.",
+		"bcrypt":       "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+		"sha":          "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"argon2id":     "$argon2id$v=19$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"scrypt":       "$scrypt$ln=14,r=8,p=1$bG9naW5zcnZzYWx0MTIzNA$WuWbEH6JeqmqPeFgdxEteLkKe5fsqMYEfA.RuL7dn3Q",
+		"sha256-crypt": "$5$htpasswdsalt$h133erJPVhvsuGbP1mGweT7etjqEwS88HB4kHhy/YvD",
+		"sha512-crypt": "$6$rounds=10000$htpasswdsalt$9odM2l4gWPLvg.U45YEHxF.TtYCUukW2WQVHe.1QRPBsc93x1CL7OD3GMlyVVqmzYArkFVH3Hf0iEy26KrqYz/",
+	}
+	for name, hash := range hashes {
+		t.Run(name, func(t *testing.T) {
+			matched, err := CompareHash(hash, "secret")
+			NoError(t, err)
+			True(t, matched)
+
+			matched, err = CompareHash(hash, "XXXXX")
+			NoError(t, err)
+			False(t, matched)
+		})
+	}
+}
+
+func TestCompareHash_UnknownAlgorithm(t *testing.T) {
+	matched, err := CompareHash("{fooo}sdcsdcsdc/BfQ=", "secret")
+	Equal(t, ErrUnknownAlgorithm, err)
+	False(t, matched)
+
+	matched, err = CompareHash("secret", "secret")
+	Equal(t, ErrUnknownAlgorithm, err)
+	False(t, matched)
+}
+
+func TestCompareHash_SHACryptReferenceValues(t *testing.T) {
+	// test vectors from https://www.akkadia.org/drepper/SHA-crypt.txt
+	for _, hash := range []string{
+		"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+	} {
+		matched, err := CompareHash(hash, "Hello world!")
+		NoError(t, err)
+		True(t, matched, hash)
+	}
+}
+
+func TestCompareHash_InvalidFormats(t *testing.T) {
+	for _, hash := range []string{
+		"$argon2id$v=19$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA",
+		"$argon2id$v=16$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"$argon2id$v=19$m=foo$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"$argon2id$v=19$m=16384,t=2,p=1$!!!$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY",
+		"$scrypt$ln=14,r=8,p=1$bG9naW5zcnZzYWx0MTIzNA",
+		"$scrypt$ln=99,r=8,p=1$bG9naW5zcnZzYWx0MTIzNA$WuWbEH6JeqmqPeFgdxEteLkKe5fsqMYEfA.RuL7dn3Q",
+		"$scrypt$ln=14,r=8,p=1$bG9naW5zcnZzYWx0MTIzNA$!!!",
+	} {
+		matched, err := CompareHash(hash, "secret")
+		Error(t, err, hash)
+		False(t, matched)
+	}
+
+	// malformed sha-crypt hashes never match
+	for _, hash := range []string{
+		"$5$htpasswdsalt",
+		"$6$rounds=foo$htpasswdsalt$9odM2l4gWPLvg",
+	} {
+		matched, err := CompareHash(hash, "secret")
+		NoError(t, err)
+		False(t, matched)
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	Equal(t, AlgorithmBcrypt, HashAlgorithm("$2a$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"))
+	Equal(t, AlgorithmSHA, HashAlgorithm("{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="))
+	Equal(t, AlgorithmAPR1, HashAlgorithm("$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB."))
+	Equal(t, AlgorithmArgon2id, HashAlgorithm("$argon2id$v=19$m=16384,t=2,p=1$c2FsdA$aGFzaA"))
+	Equal(t, AlgorithmScrypt, HashAlgorithm("$scrypt$ln=14,r=8,p=1$c2FsdA$aGFzaA"))
+	Equal(t, AlgorithmSHA256Crypt, HashAlgorithm("$5$htpasswdsalt$h133erJPVhvsuGbP1mGweT7etjqEwS88HB4kHhy/YvD"))
+	Equal(t, AlgorithmSHA512Crypt, HashAlgorithm("$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl"))
+	Equal(t, "", HashAlgorithm("secret"))
+	Equal(t, "", HashAlgorithm("$argon2i$v=19$m=16384,t=2,p=1$c2FsdA$aGFzaA"))
+}
@@ -0,0 +1,130 @@
+package pwhash
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"runtime"
+	"time"
+)
+
+// DefaultQueueTimeout is the maximum time to wait for a free worker of the DefaultPool.
+const DefaultQueueTimeout = 5 * time.Second
+
+// ErrQueueTimeout is returned by Pool.CompareHash and Pool.GenerateHash, if no worker became free within the queue timeout.
+var ErrQueueTimeout = errors.New("timeout waiting for a free hash verification worker")
+
+// The metrics of all pools, published by expvar as "hash_verification".
+var (
+	metrics = expvar.NewMap("hash_verification")
+
+	// metricQueueDepth is the number of verifications waiting for a worker
+	metricQueueDepth = new(expvar.Int)
+
+	// metricBusyWorkers is the number of running verifications
+	metricBusyWorkers = new(expvar.Int)
+
+	// metricVerifications is the number of finished verifications
+	metricVerifications = new(expvar.Int)
+
+	// metricQueueTimeouts is the number of verifications, which were rejected after the queue timeout
+	metricQueueTimeouts = new(expvar.Int)
+
+	// metricVerifySeconds is the total time of the finished verifications
+	metricVerifySeconds = new(expvar.Float)
+
+	// metricQueueSeconds is the total time, the verifications waited for a worker
+	metricQueueSeconds = new(expvar.Float)
+)
+
+func init() {
+	metrics.Set("queue_depth", metricQueueDepth)
+	metrics.Set("busy_workers", metricBusyWorkers)
+	metrics.Set("verifications", metricVerifications)
+	metrics.Set("queue_timeouts", metricQueueTimeouts)
+	metrics.Set("verify_seconds_total", metricVerifySeconds)
+	metrics.Set("queue_seconds_total", metricQueueSeconds)
+}
+
+// DefaultPool is shared by the htpasswd, userfile, sql and simple backends of the process,
+// unless an htpasswd backend configures its own pool.
+// So the number of parallel verifications is bounded for all sites of a Caddy server, too.
+var DefaultPool = NewPool(runtime.NumCPU(), DefaultQueueTimeout)
+
+// Pool limits the number of parallel hash verifications.
+// Slow hashes like bcrypt and argon2id take a lot of CPU time, so an unbounded number of
+// verifications on the request goroutines would starve the whole process on a burst of logins.
+type Pool struct {
+	workers      chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewPool creates a pool with the number of workers, which is at least one.
+// A verification waits at most the queueTimeout for a free worker, or without limit if it is zero.
+func NewPool(workers int, queueTimeout time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		workers:      make(chan struct{}, workers),
+		queueTimeout: queueTimeout,
+	}
+}
+
+// CompareHash checks the password against the hash like the package function CompareHash,
+// as soon as a worker is free. It returns ErrQueueTimeout, if no worker became free within the queue timeout.
+func (p *Pool) CompareHash(ctx context.Context, hash, password string) (bool, error) {
+	if err := p.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer p.release()
+
+	start := time.Now()
+	defer func() {
+		metricVerifications.Add(1)
+		metricVerifySeconds.Add(time.Since(start).Seconds())
+	}()
+	return CompareHash(hash, password)
+}
+
+// GenerateHash creates a hash of the password like the package function GenerateHash, as soon as a worker is free.
+// The hashes of the upgrades and password changes are as expensive as the verifications, so they share the workers.
+// It returns ErrQueueTimeout, if no worker became free within the queue timeout.
+func (p *Pool) GenerateHash(ctx context.Context, algorithm, password string) (string, error) {
+	if err := p.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer p.release()
+	return GenerateHash(algorithm, password)
+}
+
+func (p *Pool) acquire(ctx context.Context) error {
+	metricQueueDepth.Add(1)
+	defer metricQueueDepth.Add(-1)
+
+	start := time.Now()
+	defer func() { metricQueueSeconds.Add(time.Since(start).Seconds()) }()
+
+	var timeout <-chan time.Time
+	if p.queueTimeout > 0 {
+		timer := time.NewTimer(p.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p.workers <- struct{}{}:
+		metricBusyWorkers.Add(1)
+		return nil
+	case <-timeout:
+		metricQueueTimeouts.Add(1)
+		return ErrQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) release() {
+	metricBusyWorkers.Add(-1)
+	<-p.workers
+}
@@ -0,0 +1,109 @@
+package pwhash
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const testBcryptHash = "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"
+
+func TestPool_CompareHash(t *testing.T) {
+	p := NewPool(2, time.Second)
+	verifications := metricVerifications.Value()
+
+	matched, err := p.CompareHash(context.Background(), testBcryptHash, "secret")
+	NoError(t, err)
+	True(t, matched)
+
+	matched, err = p.CompareHash(context.Background(), testBcryptHash, "XXXXX")
+	NoError(t, err)
+	False(t, matched)
+
+	_, err = p.CompareHash(context.Background(), "{fooo}sdcsdcsdc/BfQ=", "secret")
+	Equal(t, ErrUnknownAlgorithm, err)
+
+	Equal(t, verifications+3, metricVerifications.Value())
+	Equal(t, int64(0), metricBusyWorkers.Value())
+	Equal(t, int64(0), metricQueueDepth.Value())
+}
+
+func TestPool_QueueTimeout(t *testing.T) {
+	p := NewPool(1, 50*time.Millisecond)
+	timeouts := metricQueueTimeouts.Value()
+
+	// occupy the only worker
+	NoError(t, p.acquire(context.Background()))
+
+	start := time.Now()
+	_, err := p.CompareHash(context.Background(), testBcryptHash, "secret")
+	Equal(t, ErrQueueTimeout, err)
+	True(t, time.Since(start) >= 50*time.Millisecond)
+	Equal(t, timeouts+1, metricQueueTimeouts.Value())
+
+	// the request is gone, before the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.CompareHash(ctx, testBcryptHash, "secret")
+	Equal(t, context.Canceled, err)
+	Equal(t, timeouts+1, metricQueueTimeouts.Value())
+
+	p.release()
+	matched, err := p.CompareHash(context.Background(), testBcryptHash, "secret")
+	NoError(t, err)
+	True(t, matched)
+}
+
+func TestPool_GenerateHash(t *testing.T) {
+	p := NewPool(1, 50*time.Millisecond)
+
+	hash, err := p.GenerateHash(context.Background(), AlgorithmBcrypt, "secret")
+	NoError(t, err)
+	matched, err := CompareHash(hash, "secret")
+	NoError(t, err)
+	True(t, matched)
+
+	_, err = p.GenerateHash(context.Background(), "foo", "secret")
+	Error(t, err)
+
+	// the hashes share the workers with the verifications
+	NoError(t, p.acquire(context.Background()))
+	_, err = p.GenerateHash(context.Background(), AlgorithmBcrypt, "secret")
+	Equal(t, ErrQueueTimeout, err)
+	p.release()
+	Equal(t, int64(0), metricBusyWorkers.Value())
+}
+
+func TestPool_BoundsParallelVerifications(t *testing.T) {
+	p := NewPool(2, 0)
+	NoError(t, p.acquire(context.Background()))
+	NoError(t, p.acquire(context.Background()))
+
+	done := make(chan bool)
+	go func() {
+		matched, _ := p.CompareHash(context.Background(), testBcryptHash, "secret")
+		done <- matched
+	}()
+
+	Eventually(t, func() bool { return metricQueueDepth.Value() == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-done:
+		Fail(t, "verification did not wait for a free worker")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	p.release()
+	True(t, <-done)
+	p.release()
+}
+
+func TestPool_Metrics(t *testing.T) {
+	v := expvar.Get("hash_verification")
+	NotNil(t, v)
+	for _, name := range []string{"queue_depth", "busy_workers", "verifications", "queue_timeouts", "verify_seconds_total", "queue_seconds_total"} {
+		Contains(t, v.String(), `"`+name+`"`)
+	}
+}
@@ -0,0 +1,180 @@
+package pwhash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Implementation of the SHA-crypt algorithm used by glibc for $5$ and $6$ hashes,
+// see https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSaltLength = 16
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// byte order of the final encoding, each triple is encoded to 4 characters
+var sha256CryptPermutation = [][]int{
+	{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+	{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+}
+
+var sha512CryptPermutation = [][]int{
+	{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+	{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+	{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+	{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+	{62, 20, 41},
+}
+
+// shaCrypt computes the $5$ or $6$ hash of the password with the salt and rounds from the supplied hash.
+// It returns false, if the supplied hash is malformed.
+func shaCrypt(hashedPassword string, password []byte) (string, bool) {
+	var newHash func() hash.Hash
+	var magic string
+	switch {
+	case strings.HasPrefix(hashedPassword, "$5$"):
+		newHash, magic = sha256.New, "$5$"
+	case strings.HasPrefix(hashedPassword, "$6$"):
+		newHash, magic = sha512.New, "$6$"
+	default:
+		return "", false
+	}
+
+	parts := strings.Split(hashedPassword[len(magic):], "$")
+	rounds := shaCryptDefaultRounds
+	roundsCustom := false
+	if strings.HasPrefix(parts[0], "rounds=") {
+		var err error
+		rounds, err = strconv.Atoi(strings.TrimPrefix(parts[0], "rounds="))
+		if err != nil {
+			return "", false
+		}
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		}
+		if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+		roundsCustom = true
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return "", false
+	}
+	salt := []byte(parts[0])
+	if len(salt) > shaCryptMaxSaltLength {
+		salt = salt[:shaCryptMaxSaltLength]
+	}
+
+	sum := shaCryptSum(newHash, password, salt, rounds)
+
+	result := magic
+	if roundsCustom {
+		result += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	result += string(salt) + "$"
+
+	var encoded []byte
+	if magic == "$5$" {
+		for _, p := range sha256CryptPermutation {
+			encoded = cryptEncode24(encoded, sum[p[0]], sum[p[1]], sum[p[2]], 4)
+		}
+		encoded = cryptEncode24(encoded, 0, sum[31], sum[30], 3)
+	} else {
+		for _, p := range sha512CryptPermutation {
+			encoded = cryptEncode24(encoded, sum[p[0]], sum[p[1]], sum[p[2]], 4)
+		}
+		encoded = cryptEncode24(encoded, 0, 0, sum[63], 2)
+	}
+	return result + string(encoded), true
+}
+
+func shaCryptSum(newHash func() hash.Hash, password, salt []byte, rounds int) []byte {
+	// digest B
+	b := newHash()
+	b.Write(password)
+	b.Write(salt)
+	b.Write(password)
+	digestB := b.Sum(nil)
+
+	// digest A
+	a := newHash()
+	a.Write(password)
+	a.Write(salt)
+	a.Write(repeatBytes(digestB, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(digestB)
+		} else {
+			a.Write(password)
+		}
+	}
+	digestA := a.Sum(nil)
+
+	// sequence P
+	dp := newHash()
+	for range password {
+		dp.Write(password)
+	}
+	p := repeatBytes(dp.Sum(nil), len(password))
+
+	// sequence S
+	ds := newHash()
+	for i := 0; i < 16+int(digestA[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatBytes(ds.Sum(nil), len(salt))
+
+	c := digestA
+	for i := 0; i < rounds; i++ {
+		h := newHash()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+	return c
+}
+
+// repeatBytes repeats the input until the result has the length n
+func repeatBytes(in []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		rest := n - len(out)
+		if rest > len(in) {
+			rest = len(in)
+		}
+		out = append(out, in[:rest]...)
+	}
+	return out
+}
+
+func cryptEncode24(out []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out = append(out, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return out
+}
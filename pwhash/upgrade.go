@@ -0,0 +1,57 @@
+package pwhash
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Parameters of the argon2id hashes created by GenerateHash, as recommended by RFC 9106 for memory constrained environments.
+const (
+	argon2idMemory  = 64 * 1024
+	argon2idTime    = 3
+	argon2idThreads = 4
+	argon2idSaltLen = 16
+	argon2idKeyLen  = 32
+)
+
+// IsWeak returns true for the hashes of outdated algorithms, which should be replaced by a stronger one.
+func IsWeak(hash string) bool {
+	switch HashAlgorithm(hash) {
+	case AlgorithmSHA, AlgorithmAPR1:
+		return true
+	}
+	return false
+}
+
+// ValidateUpgradeAlgorithm returns an error, if GenerateHash does not support the algorithm.
+func ValidateUpgradeAlgorithm(algorithm string) error {
+	switch algorithm {
+	case AlgorithmBcrypt, AlgorithmArgon2id:
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q for hash upgrades, use %v or %v", algorithm, AlgorithmBcrypt, AlgorithmArgon2id)
+}
+
+// GenerateHash creates a new hash of the password with bcrypt or argon2id.
+func GenerateHash(algorithm, password string) (string, error) {
+	switch algorithm {
+	case AlgorithmBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(hash), err
+	case AlgorithmArgon2id:
+		salt := make([]byte, argon2idSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, argon2idMemory, argon2idTime, argon2idThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", ValidateUpgradeAlgorithm(algorithm)
+}
@@ -0,0 +1,41 @@
+package pwhash
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestIsWeak(t *testing.T) {
+	True(t, IsWeak("{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="))
+	True(t, IsWeak("$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB."))
+	False(t, IsWeak("$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"))
+	False(t, IsWeak("$argon2id$v=19$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY"))
+	False(t, IsWeak("secret"))
+}
+
+func TestGenerateHash(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			NoError(t, ValidateUpgradeAlgorithm(algorithm))
+
+			hash, err := GenerateHash(algorithm, "secret")
+			NoError(t, err)
+			Equal(t, algorithm, HashAlgorithm(hash))
+
+			matched, err := CompareHash(hash, "secret")
+			NoError(t, err)
+			True(t, matched)
+
+			other, err := GenerateHash(algorithm, "secret")
+			NoError(t, err)
+			NotEqual(t, hash, other)
+		})
+	}
+}
+
+func TestGenerateHash_UnsupportedAlgorithm(t *testing.T) {
+	Error(t, ValidateUpgradeAlgorithm(AlgorithmSHA))
+
+	_, err := GenerateHash(AlgorithmSHA, "secret")
+	Error(t, err)
+}
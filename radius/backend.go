@@ -0,0 +1,155 @@
+package radius
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	goradius "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// ProviderName const
+const ProviderName = "radius"
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "RADIUS login backend opts: servers=host[:port];host2..,secret=..|secret_file=..[,timeout=..,retry=..,nas_identifier=..,group_attributes=Class;Filter-Id]",
+		},
+		BackendFactory)
+}
+
+// maxPasswordLength is the maximum length of the User-Password of RFC 2865
+const maxPasswordLength = 128
+
+// BackendFactory creates a radius backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg, err := ConfigFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewBackend(cfg)
+}
+
+// Backend is a radius based authentication backend, using PAP.
+type Backend struct {
+	config          Config
+	servers         []string
+	groupAttributes []goradius.Type
+}
+
+// NewBackend creates a new Backend and verifies the parameters.
+func NewBackend(cfg Config) (*Backend, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	groupAttributes, _ := cfg.groupAttributeTypes()
+	return &Backend{
+		config:          cfg,
+		servers:         cfg.serverAddresses(),
+		groupAttributes: groupAttributes,
+	}, nil
+}
+
+// Authenticate the user by an Access-Request.
+// The servers are asked in the configured order, until one of them responds.
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext authenticates the user like Authenticate. The exchange is cancelled with the context.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username, password := req.Username, req.Password
+	// a longer password can not be sent by PAP, so it can not be right
+	if username == "" || password == "" || len(password) > maxPasswordLength {
+		return false, model.UserInfo{}, nil
+	}
+
+	var lastErr error
+	for _, server := range b.servers {
+		response, err := b.exchange(ctx, server, username, password)
+		if ctx.Err() != nil {
+			return false, model.UserInfo{}, ctx.Err()
+		}
+		if err != nil {
+			logging.Logger.WithError(err).WithField("server", server).Warn("radius server not available")
+			lastErr = err
+			continue
+		}
+
+		switch response.Code {
+		case goradius.CodeAccessAccept:
+			return true, model.UserInfo{
+				Origin: ProviderName,
+				Sub:    username,
+				Groups: b.groups(response),
+			}, nil
+		case goradius.CodeAccessChallenge:
+			logging.Logger.WithField("server", server).Warn("radius challenge response is not supported, rejecting the login")
+			return false, model.UserInfo{}, nil
+		default:
+			return false, model.UserInfo{}, nil
+		}
+	}
+	return false, model.UserInfo{}, fmt.Errorf("no radius server available: %v", lastErr)
+}
+
+func (b *Backend) exchange(ctx context.Context, server, username, password string) (*goradius.Packet, error) {
+	packet := goradius.New(goradius.CodeAccessRequest, []byte(b.config.Secret))
+	if err := rfc2865.UserName_SetString(packet, username); err != nil {
+		return nil, err
+	}
+	if err := rfc2865.UserPassword_Set(packet, paddedPassword(password)); err != nil {
+		return nil, err
+	}
+	if b.config.NASIdentifier != "" {
+		if err := rfc2865.NASIdentifier_SetString(packet, b.config.NASIdentifier); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.config.Timeout)
+	defer cancel()
+	return b.send(ctx, packet, server)
+}
+
+// paddedPassword returns the password with a capacity of a multiple of 16 bytes.
+// RFC 2865 pads the User-Password with nulls, but the radius library reads the
+// padding from the capacity of the slice and panics on shorter ones.
+func paddedPassword(password string) []byte {
+	size := (len(password) + 15) / 16 * 16
+	if size == 0 {
+		size = 16
+	}
+	b := make([]byte, len(password), size)
+	copy(b, password)
+	return b
+}
+
+// groups returns the values of the configured reply attributes.
+func (b *Backend) groups(response *goradius.Packet) []string {
+	var groups []string
+	for _, t := range b.groupAttributes {
+		for _, value := range response.Attributes[t] {
+			g := strings.TrimSpace(string(value))
+			if g != "" && !contains(groups, g) {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
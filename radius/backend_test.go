@@ -0,0 +1,256 @@
+package radius
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	goradius "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{
+		"servers":          "radius1;radius2:1645",
+		"secret":           "s3cr3t",
+		"timeout":          "2s",
+		"retry":            "500ms",
+		"nas_identifier":   "login",
+		"group_attributes": "Filter-Id;26",
+	})
+	NoError(t, err)
+	b := backend.(*Backend)
+	Equal(t, []string{"radius1:1812", "radius2:1645"}, b.servers)
+	Equal(t, "s3cr3t", b.config.Secret)
+	Equal(t, 2*time.Second, b.config.Timeout)
+	Equal(t, 500*time.Millisecond, b.config.Retry)
+	Equal(t, "login", b.config.NASIdentifier)
+	Equal(t, []string{"Filter-Id", "26"}, b.config.GroupAttributes)
+}
+
+func TestSetup_SecretFile(t *testing.T) {
+	f, _ := ioutil.TempFile("", "loginsrv_radiustest")
+	f.WriteString("s3cr3t\n")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	cfg, err := ConfigFromOptions(map[string]string{"servers": "localhost", "secret_file": f.Name()})
+	NoError(t, err)
+	Equal(t, "s3cr3t", cfg.Secret)
+	Equal(t, []string{"Class", "Filter-Id"}, cfg.GroupAttributes)
+	Equal(t, defaultTimeout, cfg.Timeout)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"servers": "localhost"},
+		{"secret": "s3cr3t"},
+		{"servers": "localhost", "secret": "s3cr3t", "foo": "bar"},
+		{"servers": "localhost", "secret": "s3cr3t", "timeout": "forever"},
+		{"servers": "localhost", "secret": "s3cr3t", "timeout": "0s"},
+		{"servers": "localhost", "secret": "s3cr3t", "group_attributes": "Foo-Bar"},
+		{"servers": "localhost", "secret": "s3cr3t", "group_attributes": "300"},
+		{"servers": "localhost", "secret_file": "/tmp/foo/bar/nothing"},
+	} {
+		_, err := p(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	server, addr := startTestServer()
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{addr}
+	cfg.Secret = testSecret
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t,
+		model.UserInfo{
+			Origin: "radius",
+			Sub:    "bob",
+			Groups: []string{"builders", "admins"},
+		},
+		userInfo)
+	Equal(t, []string{"loginsrv"}, server.receivedNASIdentifiers())
+
+	authenticated, _, err = backend.Authenticate("bob", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("bob", "a-password-longer-than-16-bytes")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// challenges are not supported
+	authenticated, _, err = backend.Authenticate("carol", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// no request without password
+	authenticated, _, err = backend.Authenticate("bob", "")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// no request with a password, which is too long for PAP
+	authenticated, _, err = backend.Authenticate("bob", strings.Repeat("x", 129))
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, 5, len(server.receivedNASIdentifiers()))
+	Equal(t, 0, server.requestsWithoutMessageAuthenticator())
+}
+
+func TestBackend_GroupAttributes(t *testing.T) {
+	server, addr := startTestServer()
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{addr}
+	cfg.Secret = testSecret
+	cfg.GroupAttributes = []string{"Reply-Message", "11"}
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"Welcome bob", "admins", "builders"}, userInfo.Groups)
+}
+
+func TestBackend_Failover(t *testing.T) {
+	silent, silentAddr := startSilentServer()
+	defer silent.Close()
+
+	server, addr := startTestServer()
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{silentAddr, addr}
+	cfg.Secret = testSecret
+	cfg.Timeout = 200 * time.Millisecond
+	cfg.Retry = 50 * time.Millisecond
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestBackend_NoServerAvailable(t *testing.T) {
+	silent, silentAddr := startSilentServer()
+	defer silent.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{silentAddr}
+	cfg.Secret = testSecret
+	cfg.Timeout = 100 * time.Millisecond
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_AuthenticateContext(t *testing.T) {
+	silent, silentAddr := startSilentServer()
+	defer silent.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{silentAddr, silentAddr}
+	cfg.Secret = testSecret
+	cfg.Timeout = 10 * time.Second
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	// the other servers are not asked after the cancellation
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	authenticated, _, err := backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob", Password: "secret"})
+	Equal(t, context.DeadlineExceeded, err)
+	False(t, authenticated)
+	True(t, time.Since(start) < time.Second)
+}
+
+func TestBackend_MissingMessageAuthenticator(t *testing.T) {
+	server, addr := startTestServer()
+	defer server.Close()
+	server.omitMA = true
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{addr}
+	cfg.Secret = testSecret
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	// the response could be forged (Blast-RADIUS)
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	Error(t, err)
+	Contains(t, err.Error(), "Message-Authenticator")
+	False(t, authenticated)
+}
+
+func TestValidMessageAuthenticator(t *testing.T) {
+	request := goradius.New(goradius.CodeAccessRequest, []byte(testSecret))
+	response := request.Response(goradius.CodeAccessAccept)
+	rfc2865.FilterID_AddString(response, "admins")
+	NoError(t, setMessageAuthenticator(response))
+	wire, err := response.Encode()
+	NoError(t, err)
+	True(t, validMessageAuthenticator(wire, request.Authenticator, []byte(testSecret)))
+
+	False(t, validMessageAuthenticator(wire, request.Authenticator, []byte("wrong")))
+	False(t, validMessageAuthenticator(wire, [16]byte{1}, []byte(testSecret)))
+	False(t, validMessageAuthenticator(wire[:10], request.Authenticator, []byte(testSecret)))
+
+	tampered := append([]byte(nil), wire...)
+	tampered[len(tampered)-1] ^= 1
+	False(t, validMessageAuthenticator(tampered, request.Authenticator, []byte(testSecret)))
+
+	withoutMA := request.Response(goradius.CodeAccessAccept)
+	wire, err = withoutMA.Encode()
+	NoError(t, err)
+	False(t, validMessageAuthenticator(wire, request.Authenticator, []byte(testSecret)))
+}
+
+func TestBackend_WrongSecret(t *testing.T) {
+	server, addr := startTestServer()
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.Servers = []string{addr}
+	cfg.Secret = "wrong"
+	cfg.Timeout = 200 * time.Millisecond
+	backend, err := NewBackend(cfg)
+	NoError(t, err)
+
+	// the server drops requests with an invalid authenticator
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	Error(t, err)
+	False(t, authenticated)
+}
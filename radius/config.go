@@ -0,0 +1,148 @@
+package radius
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	goradius "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const (
+	defaultPort          = "1812"
+	defaultTimeout       = 5 * time.Second
+	defaultRetry         = time.Second
+	defaultNASIdentifier = "loginsrv"
+)
+
+// groupAttributeTypes are the names of the reply attributes, which can be mapped to the groups
+var groupAttributeTypes = map[string]goradius.Type{
+	"class":         rfc2865.Class_Type,
+	"filter-id":     rfc2865.FilterID_Type,
+	"reply-message": rfc2865.ReplyMessage_Type,
+}
+
+// Config for the radius backend.
+type Config struct {
+	// Servers are the addresses of the radius servers in the form host[:port].
+	// If a server does not respond, the next one is asked.
+	Servers []string
+
+	// Secret shared with the radius servers
+	Secret string
+
+	// Timeout for the request to a single server
+	Timeout time.Duration
+
+	// Retry is the interval, in which a request is retransmitted to a server until the Timeout
+	Retry time.Duration
+
+	// NASIdentifier is sent as NAS-Identifier attribute in each request
+	NASIdentifier string
+
+	// GroupAttributes are the reply attributes, which are mapped to the groups of the user.
+	// Supported are Class, Filter-Id and Reply-Message or the numeric type of an attribute.
+	GroupAttributes []string
+}
+
+// DefaultConfig returns a Config with the default timeouts and group attributes.
+func DefaultConfig() Config {
+	return Config{
+		Timeout:         defaultTimeout,
+		Retry:           defaultRetry,
+		NASIdentifier:   defaultNASIdentifier,
+		GroupAttributes: []string{"Class", "Filter-Id"},
+	}
+}
+
+// ConfigFromOptions creates a Config out of the backend options.
+// Because the options are separated by ',', lists are separated by ';', e.g. servers=radius1;radius2:1645
+func ConfigFromOptions(opts map[string]string) (Config, error) {
+	cfg := DefaultConfig()
+
+	for key, value := range opts {
+		var err error
+		switch key {
+		case "servers", "server":
+			cfg.Servers = splitList(value)
+		case "secret":
+			cfg.Secret = value
+		case "secret_file":
+			var b []byte
+			b, err = ioutil.ReadFile(value)
+			cfg.Secret = strings.TrimSpace(string(b))
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		case "retry":
+			cfg.Retry, err = time.ParseDuration(value)
+		case "nas_identifier":
+			cfg.NASIdentifier = value
+		case "group_attributes":
+			cfg.GroupAttributes = splitList(value)
+		default:
+			return Config{}, fmt.Errorf("unknown parameter %q for radius provider", key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf(`invalid parameter value "%s" in "%s" radius provider: %v`, value, key, err)
+		}
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg Config) validate() error {
+	if len(cfg.Servers) == 0 {
+		return errors.New(`missing parameter "servers" for radius provider`)
+	}
+	if cfg.Secret == "" {
+		return errors.New(`missing parameter "secret" or "secret_file" for radius provider`)
+	}
+	if cfg.Timeout <= 0 {
+		return errors.New(`parameter "timeout" of radius provider has to be positive`)
+	}
+	_, err := cfg.groupAttributeTypes()
+	return err
+}
+
+// serverAddresses returns the server addresses with the default port, if none was given
+func (cfg Config) serverAddresses() []string {
+	addrs := make([]string, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, defaultPort)
+		}
+		addrs = append(addrs, s)
+	}
+	return addrs
+}
+
+func (cfg Config) groupAttributeTypes() ([]goradius.Type, error) {
+	var types []goradius.Type
+	for _, name := range cfg.GroupAttributes {
+		if t, exist := groupAttributeTypes[strings.ToLower(name)]; exist {
+			types = append(types, t)
+			continue
+		}
+		t, err := strconv.Atoi(name)
+		if err != nil || t < 1 || t > 255 {
+			return nil, fmt.Errorf("unsupported radius group attribute %q", name)
+		}
+		types = append(types, goradius.Type(t))
+	}
+	return types, nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,143 @@
+package radius
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"errors"
+	"net"
+	"time"
+
+	goradius "layeh.com/radius"
+	"layeh.com/radius/rfc2869"
+)
+
+// maxPacketErrors is the number of invalid or not authentic packets, after which the exchange with a server fails.
+const maxPacketErrors = 10
+
+// errMessageAuthenticator is returned for an authentic response without a valid Message-Authenticator.
+// Without it, the response could be forged by an attacker on the path (Blast-RADIUS, CVE-2024-3596).
+var errMessageAuthenticator = errors.New("radius response without valid Message-Authenticator")
+
+// setMessageAuthenticator adds the Message-Authenticator of RFC 3579 to the packet.
+// For a response, the Authenticator of the packet has to be the one of the request, like after Packet.Response.
+func setMessageAuthenticator(p *goradius.Packet) error {
+	p.Set(rfc2869.MessageAuthenticator_Type, make([]byte, md5.Size))
+	wire, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	// the attributes are encoded in a fixed order, so the second encoding only differs in the value
+	copy(wire[4:20], p.Authenticator[:])
+	mac := hmac.New(md5.New, p.Secret)
+	mac.Write(wire)
+	p.Set(rfc2869.MessageAuthenticator_Type, mac.Sum(nil))
+	return nil
+}
+
+// validMessageAuthenticator verifies the Message-Authenticator of the raw response to the request with the authenticator.
+func validMessageAuthenticator(response []byte, requestAuthenticator [16]byte, secret []byte) bool {
+	if len(response) < 20 {
+		return false
+	}
+	b := append([]byte(nil), response...)
+	copy(b[4:20], requestAuthenticator[:])
+
+	var value []byte
+	for attrs := b[20:]; len(attrs) >= 2; {
+		length := int(attrs[1])
+		if length < 2 || length > len(attrs) {
+			return false
+		}
+		if goradius.Type(attrs[0]) == rfc2869.MessageAuthenticator_Type {
+			if value != nil || length != 2+md5.Size {
+				return false
+			}
+			value = append([]byte(nil), attrs[2:length]...)
+			for i := 2; i < length; i++ {
+				attrs[i] = 0
+			}
+		}
+		attrs = attrs[length:]
+	}
+	if value == nil {
+		return false
+	}
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(b)
+	return hmac.Equal(value, mac.Sum(nil))
+}
+
+// send sends the request to the server and returns the first authentic response.
+// The request is retransmitted every Retry interval, until the context is done.
+// Unlike goradius.Client.Exchange, it verifies the Message-Authenticator, which needs the raw response.
+func (b *Backend) send(ctx context.Context, request *goradius.Packet, server string) (*goradius.Packet, error) {
+	if err := setMessageAuthenticator(request); err != nil {
+		return nil, err
+	}
+	wire, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		var retry <-chan time.Time
+		if b.config.Retry > 0 {
+			ticker := time.NewTicker(b.config.Retry)
+			defer ticker.Stop()
+			retry = ticker.C
+		}
+		for {
+			select {
+			case <-retry:
+				conn.Write(wire)
+			case <-ctx.Done():
+				// unblocks the read
+				conn.Close()
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	conn.Write(wire)
+	packetErrors := 0
+	var incoming [goradius.MaxPacketLength]byte
+	for {
+		n, err := conn.Read(incoming[:])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		raw := incoming[:n]
+
+		response, err := goradius.Parse(raw, request.Secret)
+		if err != nil || response.Identifier != request.Identifier || !goradius.IsAuthenticResponse(raw, wire, request.Secret) {
+			packetErrors++
+			if packetErrors >= maxPacketErrors {
+				return nil, errors.New("too many invalid radius responses")
+			}
+			continue
+		}
+		if !validMessageAuthenticator(raw, request.Authenticator, request.Secret) {
+			return nil, errMessageAuthenticator
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,100 @@
+package radius
+
+import (
+	"net"
+	"sync"
+
+	goradius "layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+const testSecret = "s3cr3t"
+
+// testServer is an in-process radius responder for the tests.
+// It accepts bob/secret with the groups builders and admins, and challenges the user carol.
+type testServer struct {
+	server *goradius.PacketServer
+	conn   net.PacketConn
+	mu     sync.Mutex
+	nasIDs []string
+
+	// requestsWithoutMA counts the requests without valid Message-Authenticator
+	requestsWithoutMA int
+
+	// omitMA disables the Message-Authenticator of the responses, like an old server
+	omitMA bool
+}
+
+func startTestServer() (*testServer, string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &testServer{conn: conn}
+	s.server = &goradius.PacketServer{
+		SecretSource: goradius.StaticSecretSource([]byte(testSecret)),
+		Handler:      goradius.HandlerFunc(s.serveRADIUS),
+	}
+	go s.server.Serve(conn)
+	return s, conn.LocalAddr().String()
+}
+
+func (s *testServer) serveRADIUS(w goradius.ResponseWriter, r *goradius.Request) {
+	// the attributes of the client are in the order of the encoding, so the request can be encoded again
+	wire, _ := r.Packet.Encode()
+
+	s.mu.Lock()
+	s.nasIDs = append(s.nasIDs, rfc2865.NASIdentifier_GetString(r.Packet))
+	if !validMessageAuthenticator(wire, r.Authenticator, r.Secret) {
+		s.requestsWithoutMA++
+	}
+	omitMA := s.omitMA
+	s.mu.Unlock()
+
+	username := rfc2865.UserName_GetString(r.Packet)
+	password := rfc2865.UserPassword_GetString(r.Packet)
+
+	var response *goradius.Packet
+	switch {
+	case username == "bob" && password == "secret":
+		response = r.Response(goradius.CodeAccessAccept)
+		rfc2865.Class_AddString(response, "builders")
+		rfc2865.FilterID_AddString(response, "admins")
+		rfc2865.FilterID_AddString(response, "builders")
+		rfc2865.ReplyMessage_AddString(response, "Welcome bob")
+	case username == "carol" && password == "secret":
+		response = r.Response(goradius.CodeAccessChallenge)
+	default:
+		response = r.Response(goradius.CodeAccessReject)
+	}
+	if !omitMA {
+		setMessageAuthenticator(response)
+	}
+	w.Write(response)
+}
+
+func (s *testServer) receivedNASIdentifiers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.nasIDs...)
+}
+
+func (s *testServer) requestsWithoutMessageAuthenticator() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requestsWithoutMA
+}
+
+func (s *testServer) Close() {
+	s.conn.Close()
+}
+
+// startSilentServer returns the address of an udp socket, which never answers.
+func startSilentServer() (net.PacketConn, string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	return conn, conn.LocalAddr().String()
+}
@@ -0,0 +1,342 @@
+package sql
+
+import (
+	"context"
+	gosql "database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
+
+	// the drivers, which are supported out of the box
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ProviderName const
+const ProviderName = "sql"
+
+const defaultTimeout = 5 * time.Second
+
+// The columns of the query result, which are mapped to the UserInfo
+const (
+	columnPasswordHash = "password_hash"
+	columnName         = "name"
+	columnEmail        = "email"
+	columnGroups       = "groups"
+)
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "SQL login backend opts: driver=postgres|sqlite3,dsn=..,query=..|query_file=..[,timeout=..,max_open_conns=..,max_idle_conns=..,conn_max_lifetime=..,upgrade_hash=bcrypt|argon2id,upgrade_query=..]",
+		},
+		BackendFactory)
+}
+
+// Config for the sql backend
+type Config struct {
+	// Driver is the name of the database/sql driver, e.g. postgres or sqlite3
+	Driver string
+
+	// DSN is the driver specific data source name
+	DSN string
+
+	// Query selects the user by the username, which is supplied as the only query argument.
+	// The result has to contain the column password_hash and may contain the columns name, email and groups.
+	Query string
+
+	// Timeout for the query
+	Timeout time.Duration
+
+	// UpgradeHash is the algorithm, to which weak hashes are upgraded after a successful login.
+	// The upgrade is disabled, if it is empty.
+	UpgradeHash string
+
+	// UpgradeQuery stores the upgraded hash. The arguments are the new hash and the username.
+	UpgradeQuery string
+
+	// Settings for the connection pool, zero values keep the defaults of database/sql
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// BackendFactory creates a sql backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	cfg := Config{
+		Driver:  opts["driver"],
+		DSN:     opts["dsn"],
+		Timeout: defaultTimeout,
+	}
+
+	// the options are separated by ',', so the query uses ';' instead
+	if q, exist := opts["query"]; exist {
+		cfg.Query = strings.Replace(q, ";", ",", -1)
+	}
+	if q, exist := opts["upgrade_query"]; exist {
+		cfg.UpgradeQuery = strings.Replace(q, ";", ",", -1)
+	}
+	if f, exist := opts["query_file"]; exist {
+		q, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("can not read query_file of sql provider: %v", err)
+		}
+		cfg.Query = string(q)
+	}
+
+	var err error
+	for key, value := range opts {
+		switch key {
+		case "driver", "dsn", "query", "query_file", "upgrade_query":
+		case "upgrade_hash":
+			cfg.UpgradeHash = value
+		case "timeout":
+			cfg.Timeout, err = time.ParseDuration(value)
+		case "max_open_conns":
+			cfg.MaxOpenConns, err = strconv.Atoi(value)
+		case "max_idle_conns":
+			cfg.MaxIdleConns, err = strconv.Atoi(value)
+		case "conn_max_lifetime":
+			cfg.ConnMaxLifetime, err = time.ParseDuration(value)
+		default:
+			return nil, fmt.Errorf("unknown parameter %q for sql provider", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" sql provider: %v`, value, key, err)
+		}
+	}
+
+	return NewBackend(cfg)
+}
+
+// Backend is a sql database based authentication backend.
+type Backend struct {
+	db               *gosql.DB
+	query            string
+	timeout          time.Duration
+	upgradeAlgorithm string
+	upgradeQuery     string
+}
+
+// NewBackend creates a new Backend and verifies the parameters.
+func NewBackend(cfg Config) (*Backend, error) {
+	if cfg.Driver == "" {
+		return nil, errors.New(`missing parameter "driver" for sql provider`)
+	}
+	if cfg.DSN == "" {
+		return nil, errors.New(`missing parameter "dsn" for sql provider`)
+	}
+	if cfg.Query == "" {
+		return nil, errors.New(`missing parameter "query" or "query_file" for sql provider`)
+	}
+	if cfg.UpgradeHash != "" {
+		if err := pwhash.ValidateUpgradeAlgorithm(cfg.UpgradeHash); err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "upgrade_hash" sql provider: %v`, cfg.UpgradeHash, err)
+		}
+		if cfg.UpgradeQuery == "" {
+			return nil, errors.New(`missing parameter "upgrade_query" for "upgrade_hash" of sql provider`)
+		}
+	}
+
+	db, err := gosql.Open(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	return &Backend{
+		db:               db,
+		query:            cfg.Query,
+		timeout:          timeout,
+		upgradeAlgorithm: cfg.UpgradeHash,
+		upgradeQuery:     cfg.UpgradeQuery,
+	}, nil
+}
+
+// Authenticate the user
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext checks the credentials. The query is cancelled with the context
+// and the hash is checked, as soon as a worker of the pwhash.DefaultPool is free.
+// If no worker became free within the queue timeout, it returns login.ErrOverloaded.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	username, password := req.Username, req.Password
+	if username == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	user, found, err := b.lookup(ctx, username)
+	if !found || err != nil {
+		return false, model.UserInfo{}, err
+	}
+
+	// a NULL or empty hash, e.g. of a disabled account, never matches
+	if user.passwordHash == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	matched, err := pwhash.DefaultPool.CompareHash(ctx, user.passwordHash, password)
+	if err == pwhash.ErrQueueTimeout {
+		return false, model.UserInfo{}, fmt.Errorf("sql: %w", login.ErrOverloaded)
+	}
+	if err == pwhash.ErrUnknownAlgorithm {
+		return false, model.UserInfo{}, fmt.Errorf("unknown algorithm for user %q", username)
+	}
+	if !matched || err != nil {
+		return false, model.UserInfo{}, err
+	}
+	if b.upgradeAlgorithm != "" && pwhash.IsWeak(user.passwordHash) {
+		b.upgradeHash(ctx, username, user.passwordHash, password)
+	}
+
+	return true, user.userInfo(username), nil
+}
+
+// LookupUser returns the user info of a user with a password hash, without checking the password.
+func (b *Backend) LookupUser(username string) (bool, model.UserInfo, error) {
+	if username == "" {
+		return false, model.UserInfo{}, nil
+	}
+
+	user, found, err := b.lookup(context.Background(), username)
+	if !found || err != nil || user.passwordHash == "" {
+		return false, model.UserInfo{}, err
+	}
+	return true, user.userInfo(username), nil
+}
+
+// upgradeHash stores a hash of the upgrade algorithm for the user with a weak hash.
+// Errors are logged only, because the login itself was successful.
+func (b *Backend) upgradeHash(ctx context.Context, username, oldHash, password string) {
+	log := logging.Logger.WithField("user", username)
+	hash, err := pwhash.DefaultPool.GenerateHash(ctx, b.upgradeAlgorithm, password)
+	if err != nil {
+		log.WithError(err).Error("could not upgrade weak password hash in sql database")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	if _, err := b.db.ExecContext(ctx, b.upgradeQuery, hash, username); err != nil {
+		log.WithError(err).Error("could not upgrade weak password hash in sql database")
+		return
+	}
+	log.Infof("upgraded password hash in sql database from %v to %v", pwhash.HashAlgorithm(oldHash), b.upgradeAlgorithm)
+}
+
+type userRow struct {
+	passwordHash string
+	name         string
+	email        string
+	groups       []string
+}
+
+func (u userRow) userInfo(username string) model.UserInfo {
+	return model.UserInfo{
+		Origin: ProviderName,
+		Sub:    username,
+		Name:   u.name,
+		Email:  u.email,
+		Groups: u.groups,
+	}
+}
+
+// lookup runs the query for the user. The query may return more than one row,
+// e.g. one for each group of the user. In this case the groups of all rows are collected.
+func (b *Backend) lookup(ctx context.Context, username string) (user userRow, found bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	rows, err := b.db.QueryContext(ctx, b.query, username)
+	if err != nil {
+		return userRow{}, false, fmt.Errorf("sql user query failed: %v", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return userRow{}, false, err
+	}
+
+	hasHashColumn := false
+	for _, c := range columns {
+		if strings.EqualFold(c, columnPasswordHash) {
+			hasHashColumn = true
+		}
+	}
+	if !hasHashColumn {
+		return userRow{}, false, fmt.Errorf("sql user query has to return the column %v", columnPasswordHash)
+	}
+
+	for rows.Next() {
+		values := make([]gosql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return userRow{}, false, err
+		}
+
+		for i, c := range columns {
+			v := values[i].String
+			switch strings.ToLower(c) {
+			case columnPasswordHash:
+				if found && v != user.passwordHash {
+					return userRow{}, false, fmt.Errorf("sql user query returned different users for %q", username)
+				}
+				user.passwordHash = v
+			case columnName:
+				user.name = v
+			case columnEmail:
+				user.email = v
+			case columnGroups:
+				user.groups = appendGroups(user.groups, v)
+			}
+		}
+		found = true
+	}
+
+	return user, found, rows.Err()
+}
+
+// appendGroups appends the comma separated groups to the list, if they are not already contained.
+func appendGroups(groups []string, value string) []string {
+	for _, g := range strings.Split(value, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || contains(groups, g) {
+			continue
+		}
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
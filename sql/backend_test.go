@@ -0,0 +1,282 @@
+package sql
+
+import (
+	"context"
+	gosql "database/sql"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
+)
+
+// password for all of them is 'secret'
+const testSchema = `
+CREATE TABLE users (username TEXT PRIMARY KEY, password_hash TEXT, name TEXT, email TEXT);
+CREATE TABLE user_groups (username TEXT, groupname TEXT);
+INSERT INTO users VALUES ('bob-bcrypt', '$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6', 'Bob', 'bob@example.com');
+INSERT INTO users VALUES ('bob-md5', '$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.', NULL, NULL);
+INSERT INTO users VALUES ('bob-sha', '{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=', NULL, NULL);
+INSERT INTO users VALUES ('bob-foo', '{fooo}sdcsdcsdc/BfQ=', NULL, NULL);
+INSERT INTO users VALUES ('disabled-null', NULL, NULL, NULL);
+INSERT INTO users VALUES ('disabled-empty', '', NULL, NULL);
+INSERT INTO user_groups VALUES ('bob-bcrypt', 'admins');
+INSERT INTO user_groups VALUES ('bob-bcrypt', 'builders');
+`
+
+const testQuery = `SELECT u.password_hash, u.name, u.email, g.groupname AS groups
+FROM users u LEFT JOIN user_groups g ON g.username = u.username
+WHERE u.username = $1`
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	dsn, cleanup := createTestDB()
+	defer cleanup()
+
+	backend, err := p(map[string]string{
+		"driver":            "sqlite3",
+		"dsn":               dsn,
+		"query":             "SELECT password_hash; name FROM users WHERE username = $1",
+		"timeout":           "1s",
+		"max_open_conns":    "3",
+		"max_idle_conns":    "2",
+		"conn_max_lifetime": "1m",
+	})
+	NoError(t, err)
+	Equal(t, "SELECT password_hash, name FROM users WHERE username = $1", backend.(*Backend).query)
+	Equal(t, time.Second, backend.(*Backend).timeout)
+	Equal(t, 3, backend.(*Backend).db.Stats().MaxOpenConnections)
+}
+
+func TestSetup_QueryFile(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	dsn, cleanup := createTestDB()
+	defer cleanup()
+
+	f, _ := ioutil.TempFile("", "loginsrv_sqltest")
+	f.WriteString(testQuery)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	backend, err := p(map[string]string{
+		"driver":     "sqlite3",
+		"dsn":        dsn,
+		"query_file": f.Name(),
+	})
+	NoError(t, err)
+	Equal(t, testQuery, backend.(*Backend).query)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	for _, opts := range []map[string]string{
+		{},
+		{"dsn": "foo", "query": "SELECT 1"},
+		{"driver": "sqlite3", "query": "SELECT 1"},
+		{"driver": "sqlite3", "dsn": "foo"},
+		{"driver": "fooSQL", "dsn": "foo", "query": "SELECT 1"},
+		{"driver": "sqlite3", "dsn": "foo", "query_file": "/tmp/foo/bar/nothing"},
+		{"driver": "sqlite3", "dsn": "foo", "query": "SELECT 1", "timeout": "forever"},
+		{"driver": "sqlite3", "dsn": "foo", "query": "SELECT 1", "max_open_conns": "many"},
+		{"driver": "sqlite3", "dsn": "foo", "query": "SELECT 1", "foo": "bar"},
+		{"driver": "sqlite3", "dsn": "foo", "query": "SELECT 1", "upgrade_hash": "bcrypt"},
+		{"driver": "sqlite3", "dsn": "foo", "query": "SELECT 1", "upgrade_hash": "md5", "upgrade_query": "UPDATE users"},
+	} {
+		_, err := p(opts)
+		Error(t, err, "%v", opts)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	backend, cleanup := testBackend(testQuery)
+	defer cleanup()
+
+	authenticated, userInfo, err := backend.Authenticate("bob-bcrypt", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t,
+		model.UserInfo{
+			Origin: "sql",
+			Sub:    "bob-bcrypt",
+			Name:   "Bob",
+			Email:  "bob@example.com",
+			Groups: []string{"admins", "builders"},
+		},
+		userInfo)
+
+	for _, name := range []string{"bob-md5", "bob-sha"} {
+		authenticated, userInfo, err = backend.Authenticate(name, "secret")
+		NoError(t, err)
+		True(t, authenticated)
+		Equal(t, model.UserInfo{Origin: "sql", Sub: name}, userInfo)
+	}
+
+	authenticated, _, err = backend.Authenticate("bob-bcrypt", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("", "")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("bob-foo", "secret")
+	Error(t, err)
+	False(t, authenticated)
+
+	for _, name := range []string{"disabled-null", "disabled-empty"} {
+		authenticated, _, err = backend.Authenticate(name, "")
+		NoError(t, err)
+		False(t, authenticated)
+	}
+}
+
+func TestBackend_LookupUser(t *testing.T) {
+	backend, cleanup := testBackend(testQuery)
+	defer cleanup()
+
+	exist, userInfo, err := backend.LookupUser("bob-bcrypt")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t,
+		model.UserInfo{
+			Origin: "sql",
+			Sub:    "bob-bcrypt",
+			Name:   "Bob",
+			Email:  "bob@example.com",
+			Groups: []string{"admins", "builders"},
+		},
+		userInfo)
+
+	for _, name := range []string{"unknown", "", "disabled-null", "disabled-empty"} {
+		exist, _, err = backend.LookupUser(name)
+		NoError(t, err)
+		False(t, exist)
+	}
+}
+
+func TestBackend_GroupsAsList(t *testing.T) {
+	backend, cleanup := testBackend(`SELECT password_hash, 'admins, builders,,admins' AS groups FROM users WHERE username = $1`)
+	defer cleanup()
+
+	authenticated, userInfo, err := backend.Authenticate("bob-md5", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins", "builders"}, userInfo.Groups)
+}
+
+func TestBackend_QueryErrors(t *testing.T) {
+	for _, query := range []string{
+		`SELECT name FROM users WHERE username = $1`,
+		`SELECT password_hash FROM users WHERE username = $1 OR username = 'bob-md5'`,
+		`SELECT * FROM notexisting WHERE username = $1`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			backend, cleanup := testBackend(query)
+			defer cleanup()
+
+			authenticated, _, err := backend.Authenticate("bob-bcrypt", "secret")
+			Error(t, err)
+			False(t, authenticated)
+		})
+	}
+}
+
+func TestBackend_AuthenticateContext(t *testing.T) {
+	backend, cleanup := testBackend(`SELECT password_hash FROM users WHERE username = $1`)
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	authenticated, _, err := backend.AuthenticateContext(ctx, login.LoginRequest{Username: "bob-bcrypt", Password: "secret"})
+	Error(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_UpgradeWeakHashes(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	dsn, cleanup := createTestDB()
+	defer cleanup()
+
+	b, err := p(map[string]string{
+		"driver":        "sqlite3",
+		"dsn":           dsn,
+		"query":         testQuery,
+		"upgrade_hash":  "bcrypt",
+		"upgrade_query": "UPDATE users SET password_hash = $1 WHERE username = $2",
+	})
+	NoError(t, err)
+	backend := b.(*Backend)
+
+	authenticated, _, err := backend.Authenticate("bob-md5", "XXX")
+	NoError(t, err)
+	False(t, authenticated)
+	Equal(t, "$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.", passwordHash(t, backend, "bob-md5"))
+
+	for _, name := range []string{"bob-md5", "bob-sha"} {
+		authenticated, _, err = backend.Authenticate(name, "secret")
+		NoError(t, err)
+		True(t, authenticated)
+		Equal(t, pwhash.AlgorithmBcrypt, pwhash.HashAlgorithm(passwordHash(t, backend, name)))
+
+		authenticated, _, err = backend.Authenticate(name, "secret")
+		NoError(t, err)
+		True(t, authenticated)
+	}
+
+	authenticated, _, err = backend.Authenticate("bob-bcrypt", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6", passwordHash(t, backend, "bob-bcrypt"))
+}
+
+func passwordHash(t *testing.T, backend *Backend, username string) string {
+	var hash string
+	err := backend.db.QueryRow("SELECT password_hash FROM users WHERE username = $1", username).Scan(&hash)
+	NoError(t, err)
+	return hash
+}
+
+func testBackend(query string) (*Backend, func()) {
+	dsn, cleanup := createTestDB()
+	backend, err := NewBackend(Config{
+		Driver: "sqlite3",
+		DSN:    dsn,
+		Query:  query,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return backend, cleanup
+}
+
+func createTestDB() (string, func()) {
+	f, err := ioutil.TempFile("", "loginsrv_sqltest")
+	if err != nil {
+		panic(err)
+	}
+	f.Close()
+
+	db, err := gosql.Open("sqlite3", f.Name())
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(testSchema); err != nil {
+		panic(err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
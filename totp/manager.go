@@ -0,0 +1,273 @@
+package totp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/hotp"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	// Period is the time step of the codes in seconds, as recommended by RFC 6238.
+	Period = 30
+
+	// Skew is the number of time steps before and after the current one, for which codes are accepted.
+	Skew = 1
+
+	// MaxAttempts is the number of wrong codes for one login, after which the user has to login again.
+	MaxAttempts = 5
+
+	// DefaultTimeout is the time to enter the code after the password login.
+	DefaultTimeout = 5 * time.Minute
+)
+
+// Authentication method references for the amr claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+)
+
+var (
+	// ErrLoginExpired is returned for a code, which does not belong to a started login,
+	// e.g. because it expired or had too many wrong attempts.
+	ErrLoginExpired = errors.New("the login expired or does not exist")
+
+	// ErrWrongCode is returned for a code, which does not match the secret of the user.
+	ErrWrongCode = errors.New("wrong code")
+
+	// ErrAlreadyEnrolled is returned by Enroll, if the user already has a secret.
+	// A session alone must not be enough to replace the secret, so it has to be removed from the store first.
+	ErrAlreadyEnrolled = errors.New("the user is already enrolled")
+)
+
+var validateOpts = hotp.ValidateOpts{
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// Config for the Manager
+type Config struct {
+	// Issuer is shown in the authenticator apps of the users.
+	Issuer string
+
+	// Store holds the secrets of the enrolled users.
+	Store SecretStore
+
+	// Timeout is the time to enter the code after the password login.
+	Timeout time.Duration
+}
+
+// pendingLogin is a user, who has to enter the code to complete the login.
+type pendingLogin struct {
+	userInfo  model.UserInfo
+	attempts  int
+	expiresAt time.Time
+}
+
+// Manager does the second step of the login for users enrolled in TOTP (RFC 6238)
+// and the enrolment of new secrets.
+type Manager struct {
+	config Config
+	logins map[string]pendingLogin
+	// lastCounter is the time step of the last accepted code of each user, to prevent a replay of codes
+	lastCounter map[string]uint64
+	mu          sync.Mutex
+	now         func() time.Time
+}
+
+// NewManager creates a Manager.
+func NewManager(config Config) (*Manager, error) {
+	if config.Store == nil {
+		return nil, errors.New("missing secret store for totp")
+	}
+	if config.Issuer == "" {
+		config.Issuer = "loginsrv"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Manager{
+		config:      config,
+		logins:      map[string]pendingLogin{},
+		lastCounter: map[string]uint64{},
+		now:         time.Now,
+	}, nil
+}
+
+// Enrolled returns true, if the user has a secret and has to enter a code on login.
+func (m *Manager) Enrolled(sub string) (bool, error) {
+	_, enrolled, err := m.config.Store.Secret(sub)
+	return enrolled, err
+}
+
+// BeginLogin remembers the authenticated user until the code is entered
+// and returns the token to identify the login.
+func (m *Manager) BeginLogin(userInfo model.UserInfo) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for k, login := range m.logins {
+		if now.After(login.expiresAt) {
+			delete(m.logins, k)
+		}
+	}
+	m.logins[token] = pendingLogin{
+		userInfo:  userInfo,
+		expiresAt: now.Add(m.config.Timeout),
+	}
+	return token, nil
+}
+
+// PendingUser returns the user of the login of the token, or false if the login does not exist or is expired.
+func (m *Manager) PendingUser(token string) (model.UserInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	login, exist := m.logins[token]
+	if !exist || m.now().After(login.expiresAt) {
+		return model.UserInfo{}, false
+	}
+	return login.userInfo, true
+}
+
+// FinishLogin checks the code for the login of the token and returns the user of the login.
+// On ErrWrongCode, the user of the login is also returned and the code can be entered again.
+// After MaxAttempts wrong codes, ErrLoginExpired is returned together with the user of the login.
+func (m *Manager) FinishLogin(token, code string) (model.UserInfo, error) {
+	m.mu.Lock()
+	login, exist := m.logins[token]
+	if !exist || m.now().After(login.expiresAt) {
+		delete(m.logins, token)
+		m.mu.Unlock()
+		return model.UserInfo{}, ErrLoginExpired
+	}
+	m.mu.Unlock()
+
+	secret, enrolled, err := m.config.Store.Secret(login.userInfo.Sub)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the login may have been finished or burned by a parallel request in the meantime,
+	// and the attempts of parallel requests have to be counted
+	login, exist = m.logins[token]
+	if !exist {
+		return model.UserInfo{}, ErrLoginExpired
+	}
+
+	if enrolled && m.validate(login.userInfo.Sub, secret, code) {
+		delete(m.logins, token)
+		return login.userInfo, nil
+	}
+
+	login.attempts++
+	if login.attempts >= MaxAttempts {
+		delete(m.logins, token)
+		return login.userInfo, ErrLoginExpired
+	}
+	m.logins[token] = login
+	return login.userInfo, ErrWrongCode
+}
+
+// NewKey creates a random secret for the user.
+func (m *Manager) NewKey(sub string) (*otp.Key, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return m.Key(sub, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+// Key returns the key with the secret for the user, e.g. to show it again on a failed enrolment.
+func (m *Manager) Key(sub, secret string) (*otp.Key, error) {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", m.config.Issuer)
+	v.Set("period", strconv.Itoa(Period))
+	v.Set("algorithm", validateOpts.Algorithm.String())
+	v.Set("digits", validateOpts.Digits.String())
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + m.config.Issuer + ":" + sub,
+		RawQuery: v.Encode(),
+	}
+	return otp.NewKeyFromURL(u.String())
+}
+
+// Enroll stores the secret for the user, if the code matches it.
+// This ensures, that the user has added the secret to an authenticator app.
+// It returns ErrAlreadyEnrolled, if the user already has a secret.
+func (m *Manager) Enroll(sub, secret, code string) (bool, error) {
+	enrolled, err := m.Enrolled(sub)
+	if err != nil {
+		return false, err
+	}
+	if enrolled {
+		return false, ErrAlreadyEnrolled
+	}
+
+	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret)); err != nil || secret == "" {
+		return false, nil
+	}
+
+	m.mu.Lock()
+	valid := m.validate(sub, secret, code)
+	m.mu.Unlock()
+	if !valid {
+		return false, nil
+	}
+	return true, m.config.Store.SetSecret(sub, secret)
+}
+
+// validate checks the code for the current time steps and rejects a code,
+// which was already used. It has to be called with the lock held.
+func (m *Manager) validate(sub, secret, code string) bool {
+	current := uint64(m.now().Unix()) / Period
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		valid, err := hotp.ValidateCustom(code, counter, secret, validateOpts)
+		if err != nil || !valid {
+			continue
+		}
+		if last, used := m.lastCounter[sub]; used && counter <= last {
+			return false
+		}
+		m.lastCounter[sub] = counter
+		return true
+	}
+	return false
+}
+
+// QRCode returns the url of the key as QR code in a png data url.
+func QRCode(key *otp.Key, size int) (string, error) {
+	img, err := key.Image(size, size)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
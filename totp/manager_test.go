@@ -0,0 +1,252 @@
+package totp
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/hotp"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+// memoryStore is a SecretStore for the tests
+type memoryStore map[string]string
+
+func (s memoryStore) Secret(sub string) (string, bool, error) {
+	secret, exist := s[sub]
+	return secret, exist, nil
+}
+
+func (s memoryStore) SetSecret(sub, secret string) error {
+	s[sub] = secret
+	return nil
+}
+
+type errorStore struct{}
+
+func (errorStore) Secret(sub string) (string, bool, error) {
+	return "", false, errors.New("store error")
+}
+func (errorStore) SetSecret(sub, secret string) error { return errors.New("store error") }
+
+// barrierStore is a SecretStore, which returns the secret only, when all expected calls are waiting
+type barrierStore struct {
+	memoryStore
+	wg *sync.WaitGroup
+}
+
+func (s barrierStore) Secret(sub string) (string, bool, error) {
+	s.wg.Done()
+	s.wg.Wait()
+	return s.memoryStore.Secret(sub)
+}
+
+var testTime = time.Unix(1600000000, 0)
+
+func testManager(store SecretStore) *Manager {
+	m, _ := NewManager(Config{Store: store})
+	m.now = func() time.Time { return testTime }
+	return m
+}
+
+// code returns the code of the secret for the time step with the offset to the test time
+func code(secret string, offset int) string {
+	c, err := hotp.GenerateCode(secret, uint64(int(testTime.Unix()/Period)+offset))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func TestManager_NewManager(t *testing.T) {
+	_, err := NewManager(Config{})
+	Error(t, err)
+
+	m, err := NewManager(Config{Store: memoryStore{}})
+	NoError(t, err)
+	Equal(t, "loginsrv", m.config.Issuer)
+	Equal(t, DefaultTimeout, m.config.Timeout)
+}
+
+func TestManager_Login(t *testing.T) {
+	m := testManager(memoryStore{"bob": testSecret})
+
+	enrolled, err := m.Enrolled("bob")
+	NoError(t, err)
+	True(t, enrolled)
+	enrolled, err = m.Enrolled("alice")
+	NoError(t, err)
+	False(t, enrolled)
+
+	token, err := m.BeginLogin(model.UserInfo{Sub: "bob", Origin: "simple"})
+	NoError(t, err)
+
+	pending, exist := m.PendingUser(token)
+	True(t, exist)
+	Equal(t, "bob", pending.Sub)
+	_, exist = m.PendingUser("unknown")
+	False(t, exist)
+
+	userInfo, err := m.FinishLogin(token, "000000")
+	Equal(t, ErrWrongCode, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	userInfo, err = m.FinishLogin(token, code(testSecret, 0))
+	NoError(t, err)
+	Equal(t, model.UserInfo{Sub: "bob", Origin: "simple"}, userInfo)
+
+	// the login can be finished only once
+	_, err = m.FinishLogin(token, code(testSecret, 1))
+	Equal(t, ErrLoginExpired, err)
+
+	_, err = m.FinishLogin("unknown", code(testSecret, 1))
+	Equal(t, ErrLoginExpired, err)
+}
+
+func TestManager_Login_Skew(t *testing.T) {
+	for _, test := range []struct {
+		offset int
+		valid  bool
+	}{{-2, false}, {-1, true}, {0, true}, {1, true}, {2, false}} {
+		m := testManager(memoryStore{"bob": testSecret})
+		token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+		_, err := m.FinishLogin(token, code(testSecret, test.offset))
+		Equal(t, test.valid, err == nil, "offset %v", test.offset)
+	}
+}
+
+func TestManager_Login_Replay(t *testing.T) {
+	m := testManager(memoryStore{"bob": testSecret})
+
+	token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+	_, err := m.FinishLogin(token, code(testSecret, 0))
+	NoError(t, err)
+
+	// the same or an older code can not be used again
+	token, _ = m.BeginLogin(model.UserInfo{Sub: "bob"})
+	_, err = m.FinishLogin(token, code(testSecret, 0))
+	Equal(t, ErrWrongCode, err)
+	_, err = m.FinishLogin(token, code(testSecret, -1))
+	Equal(t, ErrWrongCode, err)
+
+	_, err = m.FinishLogin(token, code(testSecret, 1))
+	NoError(t, err)
+}
+
+func TestManager_Login_MaxAttempts(t *testing.T) {
+	m := testManager(memoryStore{"bob": testSecret})
+	token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+
+	for i := 1; i < MaxAttempts; i++ {
+		_, err := m.FinishLogin(token, "000000")
+		Equal(t, ErrWrongCode, err)
+	}
+	userInfo, err := m.FinishLogin(token, "000000")
+	Equal(t, ErrLoginExpired, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	_, exist := m.PendingUser(token)
+	False(t, exist)
+
+	userInfo, err = m.FinishLogin(token, code(testSecret, 0))
+	Equal(t, ErrLoginExpired, err)
+	Equal(t, "", userInfo.Sub)
+}
+
+func TestManager_Login_MaxAttempts_Parallel(t *testing.T) {
+	calls := 3 * MaxAttempts
+	store := barrierStore{memoryStore{"bob": testSecret}, &sync.WaitGroup{}}
+	store.wg.Add(calls)
+	m := testManager(store)
+	token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			_, err := m.FinishLogin(token, "000000")
+			errs <- err
+		}()
+	}
+	wrongCodes := 0
+	for i := 0; i < calls; i++ {
+		if err := <-errs; err == ErrWrongCode {
+			wrongCodes++
+		} else {
+			Equal(t, ErrLoginExpired, err)
+		}
+	}
+	Equal(t, MaxAttempts-1, wrongCodes)
+
+	_, exist := m.PendingUser(token)
+	False(t, exist)
+}
+
+func TestManager_Login_Expired(t *testing.T) {
+	m := testManager(memoryStore{"bob": testSecret})
+	token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+
+	m.now = func() time.Time { return testTime.Add(DefaultTimeout + time.Second) }
+	_, err := m.FinishLogin(token, code(testSecret, 10))
+	Equal(t, ErrLoginExpired, err)
+}
+
+func TestManager_Login_StoreError(t *testing.T) {
+	m := testManager(errorStore{})
+
+	_, err := m.Enrolled("bob")
+	Error(t, err)
+
+	token, _ := m.BeginLogin(model.UserInfo{Sub: "bob"})
+	_, err = m.FinishLogin(token, code(testSecret, 0))
+	Error(t, err)
+}
+
+func TestManager_Enroll(t *testing.T) {
+	store := memoryStore{}
+	m := testManager(store)
+
+	key, err := m.NewKey("bob")
+	NoError(t, err)
+	Equal(t, "loginsrv", key.Issuer())
+	Equal(t, "bob", key.AccountName())
+	Equal(t, 32, len(key.Secret()))
+	True(t, strings.HasPrefix(key.String(), "otpauth://totp/loginsrv:bob?"))
+
+	enrolled, err := m.Enroll("bob", key.Secret(), "000000")
+	NoError(t, err)
+	False(t, enrolled)
+	Empty(t, store)
+
+	enrolled, err = m.Enroll("bob", "not base32!", code(testSecret, 0))
+	NoError(t, err)
+	False(t, enrolled)
+
+	enrolled, err = m.Enroll("bob", key.Secret(), code(key.Secret(), 0))
+	NoError(t, err)
+	True(t, enrolled)
+	Equal(t, key.Secret(), store["bob"])
+
+	// the secret can not be replaced
+	other, _ := m.NewKey("bob")
+	enrolled, err = m.Enroll("bob", other.Secret(), code(other.Secret(), 1))
+	Equal(t, ErrAlreadyEnrolled, err)
+	False(t, enrolled)
+	Equal(t, key.Secret(), store["bob"])
+
+	_, err = testManager(errorStore{}).Enroll("bob", key.Secret(), code(key.Secret(), 0))
+	Error(t, err)
+}
+
+func TestQRCode(t *testing.T) {
+	key, err := testManager(memoryStore{}).Key("bob", testSecret)
+	NoError(t, err)
+
+	qrCode, err := QRCode(key, 200)
+	NoError(t, err)
+	True(t, strings.HasPrefix(qrCode, "data:image/png;base64,"))
+}
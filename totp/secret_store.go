@@ -0,0 +1,103 @@
+package totp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SecretStore holds the TOTP secrets of the enrolled users.
+type SecretStore interface {
+	// Secret returns the base32 encoded secret of the user, or false, if the user is not enrolled.
+	Secret(sub string) (string, bool, error)
+
+	// SetSecret stores the secret of the user.
+	SetSecret(sub, secret string) error
+}
+
+// FileStore is a SecretStore, which keeps the secrets in a json file
+// with the sub of the users as keys, e.g. {"bob": "JBSWY3DPEHPK3PXP"}.
+// The file is read on each lookup, so users can be reset by editing it.
+type FileStore struct {
+	filename string
+	mu       sync.Mutex
+}
+
+// NewFileStore creates a FileStore and checks, that an existing file can be read.
+// A missing file is created on the first enrolment.
+func NewFileStore(filename string) (*FileStore, error) {
+	s := &FileStore{filename: filename}
+	if _, err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Secret returns the secret of the user, or false, if the user is not enrolled.
+func (s *FileStore) Secret(sub string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return "", false, err
+	}
+	secret, exist := secrets[sub]
+	return secret, exist && secret != "", nil
+}
+
+// SetSecret stores the secret of the user.
+func (s *FileStore) SetSecret(sub, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secrets, err := s.read()
+	if err != nil {
+		return err
+	}
+	secrets[sub] = secret
+	return s.write(secrets)
+}
+
+func (s *FileStore) read() (map[string]string, error) {
+	secrets := map[string]string{}
+	b, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &secrets); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+// write replaces the file atomically by a temporary file in the same directory.
+func (s *FileStore) write(secrets map[string]string) error {
+	b, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.filename)
+}
@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv_totp")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "totp.json")
+
+	s, err := NewFileStore(filename)
+	NoError(t, err)
+
+	_, enrolled, err := s.Secret("bob")
+	NoError(t, err)
+	False(t, enrolled)
+
+	NoError(t, s.SetSecret("bob", testSecret))
+	NoError(t, s.SetSecret("alice", "KRSXG5CTMVRXEZLU"))
+
+	info, err := os.Stat(filename)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	secret, enrolled, err := s.Secret("bob")
+	NoError(t, err)
+	True(t, enrolled)
+	Equal(t, testSecret, secret)
+
+	// changes of the file are used without a restart
+	NoError(t, ioutil.WriteFile(filename, []byte(`{"alice": "KRSXG5CTMVRXEZLU", "bob": ""}`), 0600))
+	_, enrolled, err = s.Secret("bob")
+	NoError(t, err)
+	False(t, enrolled)
+
+	// no temporary files are left
+	files, _ := ioutil.ReadDir(dir)
+	Equal(t, 1, len(files))
+}
+
+func TestFileStore_Errors(t *testing.T) {
+	f, _ := ioutil.TempFile("", "loginsrv_totp")
+	f.WriteString("{no json")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err := NewFileStore(f.Name())
+	Error(t, err)
+
+	s := &FileStore{filename: f.Name()}
+	_, _, err = s.Secret("bob")
+	Error(t, err)
+
+	s, err = NewFileStore("/tmp/foo/bar/nothing/totp.json")
+	NoError(t, err)
+	Error(t, s.SetSecret("bob", testSecret))
+}
@@ -0,0 +1,139 @@
+package userfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/pwhash"
+)
+
+// ProviderName const
+const ProviderName = "userfile"
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "User file login backend opts: file=/path/to/users.yml[,upgrade_hash=bcrypt|argon2id]",
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates a user file backend
+func BackendFactory(opts map[string]string) (login.Backend, error) {
+	var file, upgradeAlgorithm string
+	for key, value := range opts {
+		switch key {
+		case "file":
+			file = value
+		case "upgrade_hash":
+			if err := pwhash.ValidateUpgradeAlgorithm(value); err != nil {
+				return nil, fmt.Errorf(`invalid parameter value "%s" in "%s" userfile provider: %v`, value, key, err)
+			}
+			upgradeAlgorithm = value
+		default:
+			return nil, fmt.Errorf("unknown parameter %q for userfile provider", key)
+		}
+	}
+	if file == "" {
+		return nil, errors.New(`missing parameter "file" for userfile provider`)
+	}
+
+	backend, err := NewBackend(file)
+	if err != nil {
+		return nil, err
+	}
+	backend.upgradeAlgorithm = upgradeAlgorithm
+	return backend, nil
+}
+
+// Backend is an authentication backend, which reads the users with their
+// password hashes, groups and custom claims from a YAML file in the format of the user_file.
+type Backend struct {
+	file *File
+
+	// upgradeAlgorithm is the algorithm, to which weak hashes are upgraded after a successful login.
+	// The upgrade is disabled, if it is empty.
+	upgradeAlgorithm string
+}
+
+// NewBackend creates a new Backend for the file, which is watched for changes.
+func NewBackend(filename string) (*Backend, error) {
+	file, err := NewFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{file: file}, nil
+}
+
+// Close stops watching the file.
+func (b *Backend) Close() error {
+	return b.file.Close()
+}
+
+// Authenticate the user
+func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return b.AuthenticateContext(context.Background(), login.LoginRequest{Username: username, Password: password})
+}
+
+// AuthenticateContext checks the credentials, as soon as a worker of the pwhash.DefaultPool is free.
+// If no worker became free within the queue timeout, it returns login.ErrOverloaded.
+func (b *Backend) AuthenticateContext(ctx context.Context, req login.LoginRequest) (bool, model.UserInfo, error) {
+	u, exist := b.file.User(req.Username)
+	if !exist {
+		return false, model.UserInfo{}, nil
+	}
+
+	password := req.Password
+	matched, err := pwhash.DefaultPool.CompareHash(ctx, u.PasswordHash, password)
+	if err == pwhash.ErrQueueTimeout {
+		return false, model.UserInfo{}, fmt.Errorf("userfile: %w", login.ErrOverloaded)
+	}
+	if err != nil || !matched {
+		return false, model.UserInfo{}, err
+	}
+	if b.upgradeAlgorithm != "" && pwhash.IsWeak(u.PasswordHash) {
+		b.upgradeHash(ctx, u, password)
+	}
+	return true, userInfo(u), nil
+}
+
+func userInfo(u User) model.UserInfo {
+	return model.UserInfo{
+		Origin: ProviderName,
+		Sub:    u.Sub,
+		Name:   u.Name,
+		Email:  u.Email,
+		Domain: u.Domain,
+		Groups: append([]string(nil), u.Groups...),
+		Claims: u.Claims,
+	}
+}
+
+// LookupUser returns the user info of a user with a password hash, without checking the password.
+func (b *Backend) LookupUser(username string) (bool, model.UserInfo, error) {
+	u, exist := b.file.User(username)
+	if !exist || u.PasswordHash == "" {
+		return false, model.UserInfo{}, nil
+	}
+	return true, userInfo(u), nil
+}
+
+// upgradeHash replaces the weak hash of the user by a hash of the upgrade algorithm.
+// Errors are logged only, because the login itself was successful.
+func (b *Backend) upgradeHash(ctx context.Context, u User, password string) {
+	log := logging.Logger.WithField("user", u.Sub)
+	hash, err := pwhash.DefaultPool.GenerateHash(ctx, b.upgradeAlgorithm, password)
+	if err == nil {
+		err = b.file.replaceHash(u.Sub, u.PasswordHash, hash)
+	}
+	if err != nil {
+		log.WithError(err).Error("could not upgrade weak password hash in user file")
+		return
+	}
+	log.Infof("upgraded password hash in user file from %v to %v", pwhash.HashAlgorithm(u.PasswordHash), b.upgradeAlgorithm)
+}
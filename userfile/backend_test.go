@@ -0,0 +1,248 @@
+package userfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/pwhash"
+)
+
+// password for all users is 'secret'
+const testUsers = `
+- sub: bob
+  password_hash: $2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6
+  name: Bob Builder
+  email: bob@example.org
+  groups:
+    - admins
+    - users
+  claims:
+    role: superAdmin
+    projects:
+      - name: example
+        visible: true
+
+- sub: alice
+  password_hash: $argon2id$v=19$m=16384,t=2,p=1$bG9naW5zcnZzYWx0MTIzNA$ij2fwH3K7lU8DXMxPJHDNLTKtRZOknVKqgfWdFnW/RY
+
+# only claims, e.g. for oauth logins
+- origin: github
+  sub: carol
+  claims:
+    role: admin
+`
+
+func writeUserFile(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "userfile")
+	NoError(t, err)
+	filename := filepath.Join(dir, "users.yml")
+	NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestSetup(t *testing.T) {
+	filename, cleanup := writeUserFile(t, testUsers)
+	defer cleanup()
+
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+	NotNil(t, p)
+
+	backend, err := p(map[string]string{"file": filename})
+	NoError(t, err)
+	defer backend.(*Backend).Close()
+	Equal(t, filename, backend.(*Backend).file.filename)
+}
+
+func TestSetup_Error(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	_, err := p(map[string]string{})
+	Error(t, err)
+
+	_, err = p(map[string]string{"file": "/tmp/users.yml", "foo": "bar"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"file": "/does/not/exist.yml"})
+	Error(t, err)
+}
+
+func TestNewBackend_InvalidFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"yaml":      "- sub: bob\n\tpassword_hash: x",
+		"algorithm": "- sub: bob\n  password_hash: secret",
+		"sub":       "- password_hash: $2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+	} {
+		t.Run(name, func(t *testing.T) {
+			filename, cleanup := writeUserFile(t, content)
+			defer cleanup()
+
+			_, err := NewBackend(filename)
+			Error(t, err)
+		})
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	filename, cleanup := writeUserFile(t, testUsers)
+	defer cleanup()
+
+	backend, err := NewBackend(filename)
+	NoError(t, err)
+	defer backend.Close()
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, "userfile", userInfo.Origin)
+	Equal(t, "Bob Builder", userInfo.Name)
+	Equal(t, "bob@example.org", userInfo.Email)
+	Equal(t, []string{"admins", "users"}, userInfo.Groups)
+	Equal(t, "superAdmin", userInfo.Claims["role"])
+
+	// nested claims can be serialized to the token
+	b, err := json.Marshal(userInfo)
+	NoError(t, err)
+	Contains(t, string(b), `"projects":[{"name":"example","visible":true}]`)
+
+	authenticated, userInfo, err = backend.Authenticate("alice", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "alice", userInfo.Sub)
+	Nil(t, userInfo.Claims)
+
+	authenticated, _, err = backend.Authenticate("bob", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// entries without password hash can not login
+	authenticated, _, err = backend.Authenticate("carol", "")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_LookupUser(t *testing.T) {
+	filename, cleanup := writeUserFile(t, testUsers)
+	defer cleanup()
+
+	backend, err := NewBackend(filename)
+	NoError(t, err)
+	defer backend.Close()
+
+	exist, userInfo, err := backend.LookupUser("bob")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, []string{"admins", "users"}, userInfo.Groups)
+	Equal(t, "superAdmin", userInfo.Claims["role"])
+
+	// entries without password hash and unknown users
+	for _, name := range []string{"carol", "unknown"} {
+		exist, _, err = backend.LookupUser(name)
+		NoError(t, err)
+		False(t, exist)
+	}
+}
+
+func TestBackend_Reload(t *testing.T) {
+	filename, cleanup := writeUserFile(t, testUsers)
+	defer cleanup()
+
+	backend, err := NewBackend(filename)
+	NoError(t, err)
+	defer backend.Close()
+
+	err = ioutil.WriteFile(filename, []byte(`
+- sub: dave
+  password_hash: $2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6
+`), 0644)
+	NoError(t, err)
+
+	Eventually(t, func() bool {
+		authenticated, _, _ := backend.Authenticate("dave", "secret")
+		return authenticated
+	}, 5*time.Second, 10*time.Millisecond)
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_ReloadKeepsUsersOnInvalidFile(t *testing.T) {
+	filename, cleanup := writeUserFile(t, testUsers)
+	defer cleanup()
+
+	backend, err := NewBackend(filename)
+	NoError(t, err)
+	defer backend.Close()
+
+	NoError(t, ioutil.WriteFile(filename, []byte("- sub: bob\n  password_hash: secret"), 0644))
+	time.Sleep(3 * reloadDelay)
+
+	authenticated, _, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+func TestBackend_UpgradeWeakHashes(t *testing.T) {
+	filename, cleanup := writeUserFile(t, `# users
+- sub: bob
+  password_hash: "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+  groups: [admins]
+- sub: alice
+  password_hash: $apr1$IDZSCL/o$N68zaFDDRivjour94OVeB.
+`)
+	defer cleanup()
+
+	p, _ := login.GetProvider(ProviderName)
+	b, err := p(map[string]string{"file": filename, "upgrade_hash": "argon2id"})
+	NoError(t, err)
+	backend := b.(*Backend)
+	defer backend.Close()
+
+	authenticated, _, err := backend.Authenticate("alice", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, userInfo, err := backend.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins"}, userInfo.Groups)
+
+	u, _ := backend.file.User("bob")
+	Equal(t, pwhash.AlgorithmArgon2id, pwhash.HashAlgorithm(u.PasswordHash))
+	u, _ = backend.file.User("alice")
+	Equal(t, pwhash.AlgorithmAPR1, pwhash.HashAlgorithm(u.PasswordHash))
+
+	content, err := ioutil.ReadFile(filename)
+	NoError(t, err)
+	Contains(t, string(content), "# users\n")
+	NotContains(t, string(content), "{SHA}")
+
+	// the rewritten file is still valid
+	f, err := NewFile(filename)
+	NoError(t, err)
+	defer f.Close()
+	u, _ = f.User("bob")
+	matched, err := pwhash.CompareHash(u.PasswordHash, "secret")
+	NoError(t, err)
+	True(t, matched)
+}
+
+func TestSetup_InvalidUpgradeHash(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	_, err := p(map[string]string{"file": "/tmp/users.yml", "upgrade_hash": "md5"})
+	Error(t, err)
+}
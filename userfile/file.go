@@ -0,0 +1,240 @@
+package userfile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/pwhash"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// reloadDelay is the time to wait for further changes of the file, before it is reloaded.
+// Editors often write a file in multiple steps, so this avoids parsing incomplete files.
+const reloadDelay = 100 * time.Millisecond
+
+// User is an entry of the user file.
+// The format is the one of the user_file, extended by the password hash and the name.
+// Entries without password hash only add claims, so they are ignored by the backend.
+type User struct {
+	Sub          string                 `yaml:"sub"`
+	PasswordHash string                 `yaml:"password_hash"`
+	Name         string                 `yaml:"name"`
+	Email        string                 `yaml:"email"`
+	Domain       string                 `yaml:"domain"`
+	Groups       []string               `yaml:"groups"`
+	Claims       map[string]interface{} `yaml:"claims"`
+}
+
+// File holds the users of a user file and reloads them, if the file changes.
+type File struct {
+	filename string
+	users    map[string]User
+	muUsers  sync.RWMutex
+	muChange sync.Mutex
+	watcher  *fsnotify.Watcher
+}
+
+// NewFile reads the user file and watches it for changes in the background.
+func NewFile(filename string) (*File, error) {
+	f := &File{filename: filename}
+	if err := f.parse(); err != nil {
+		return nil, err
+	}
+	if err := f.watch(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Close stops watching the file for changes.
+func (f *File) Close() error {
+	if f.watcher == nil {
+		return nil
+	}
+	return f.watcher.Close()
+}
+
+// User returns the entry of the user with a password hash.
+func (f *File) User(username string) (User, bool) {
+	f.muUsers.RLock()
+	defer f.muUsers.RUnlock()
+	u, exist := f.users[username]
+	return u, exist
+}
+
+// replaceHash replaces the password hash of the user in the file and in memory.
+// Only the text of the old hash is replaced, so the formatting and the comments of the file are kept.
+// The hashes are salted, so the old hash has to occur exactly once in the file.
+func (f *File) replaceHash(username, oldHash, newHash string) error {
+	f.muChange.Lock()
+	defer f.muChange.Unlock()
+
+	// the hash may have been changed in the meantime
+	if u, exist := f.User(username); !exist || u.PasswordHash != oldHash {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return err
+	}
+	if n := bytes.Count(content, []byte(oldHash)); n != 1 {
+		return fmt.Errorf("password_hash of user %q found %v times in user file %v", username, n, f.filename)
+	}
+	content = bytes.Replace(content, []byte(oldHash), []byte(newHash), 1)
+
+	if err := writeFileAtomic(f.filename, content); err != nil {
+		return err
+	}
+
+	f.muUsers.Lock()
+	u := f.users[username]
+	u.PasswordHash = newHash
+	f.users[username] = u
+	f.muUsers.Unlock()
+	return nil
+}
+
+// writeFileAtomic replaces the file by a renamed temporary file, keeping the file mode.
+func writeFileAtomic(filename string, content []byte) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// parse reads the file and replaces the users only, if it is valid.
+func (f *File) parse() error {
+	b, err := ioutil.ReadFile(f.filename)
+	if err != nil {
+		return fmt.Errorf("can't read user file %v: %v", f.filename, err)
+	}
+
+	var entries []User
+	if err := yaml.Unmarshal(b, &entries); err != nil {
+		return fmt.Errorf("can't parse user file %v: %v", f.filename, err)
+	}
+
+	users := map[string]User{}
+	for _, u := range entries {
+		if u.PasswordHash == "" {
+			continue
+		}
+		if u.Sub == "" {
+			return fmt.Errorf("entry with password_hash but without sub in user file %v", f.filename)
+		}
+		if pwhash.HashAlgorithm(u.PasswordHash) == "" {
+			return fmt.Errorf("unknown algorithm of password_hash for user %q in user file %v", u.Sub, f.filename)
+		}
+		if _, exist := users[u.Sub]; exist {
+			logging.Logger.Warnf("Found duplicate entry for user: (%v), using the first one", u.Sub)
+			continue
+		}
+		if u.Claims != nil {
+			u.Claims = jsonCompatible(u.Claims).(map[string]interface{})
+		}
+		users[u.Sub] = u
+	}
+
+	f.muUsers.Lock()
+	f.users = users
+	f.muUsers.Unlock()
+	return nil
+}
+
+// jsonCompatible converts the maps of the YAML parser, which have interface{} keys,
+// to maps with string keys, so that the claims can be serialized to JSON.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for k, e := range v {
+			m[k] = jsonCompatible(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = jsonCompatible(e)
+		}
+		return l
+	}
+	return value
+}
+
+// watch starts watching the directory of the file, so that a file which is replaced by a rename is tracked, too.
+func (f *File) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("can not watch user file: %v", err)
+	}
+	if err := watcher.Add(filepath.Dir(f.filename)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("can not watch user file %v: %v", f.filename, err)
+	}
+
+	f.watcher = watcher
+	go f.handleEvents(watcher)
+	return nil
+}
+
+func (f *File) handleEvents(watcher *fsnotify.Watcher) {
+	filename := filepath.Clean(f.filename)
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) == filename {
+				reload = time.After(reloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logging.Logger.WithError(err).Warn("error while watching user file")
+		case <-reload:
+			reload = nil
+			if err := f.parse(); err != nil {
+				logging.Logger.WithError(err).Error("could not reload user file, keeping the previous users")
+				continue
+			}
+			logging.Logger.Info("reloaded user file")
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// testAuthenticator is a software authenticator with an ES256 key, acting like a browser with a passkey.
+type testAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	signCount  uint32
+	rpID       string
+	origin     string
+	flags      byte
+	userHandle []byte
+}
+
+func newTestAuthenticator(rpID, origin string) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{
+		key:    key,
+		id:     id,
+		rpID:   rpID,
+		origin: origin,
+		flags:  flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *testAuthenticator) publicKey() []byte {
+	b, err := cbor.Marshal(map[int]interface{}{
+		1:  2,
+		3:  AlgES256,
+		-1: 1,
+		-2: padded(a.key.PublicKey.X.Bytes()),
+		-3: padded(a.key.PublicKey.Y.Bytes()),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (a *testAuthenticator) authData(flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	b := append([]byte{}, rpIDHash[:]...)
+	b = append(b, flags)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.signCount)
+	b = append(b, counter...)
+	if attested {
+		b = append(b, make([]byte, 16)...) // aaguid
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(a.id)))
+		b = append(b, length...)
+		b = append(b, a.id...)
+		b = append(b, a.publicKey()...)
+	}
+	return b
+}
+
+func (a *testAuthenticator) clientData(ceremony, challenge string) []byte {
+	b, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return b
+}
+
+// create returns the browser response for the registration options.
+func (a *testAuthenticator) create(challenge string) string {
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(a.flags|flagAttestedCredentialData, true),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return credentialJSON(a.id, map[string]string{
+		"clientDataJSON":    encodeBase64(a.clientData(ceremonyRegister, challenge)),
+		"attestationObject": encodeBase64(attestation),
+	})
+}
+
+// get returns the browser response for the login options.
+func (a *testAuthenticator) get(challenge string) string {
+	a.signCount++
+	authData := a.authData(a.flags, false)
+	clientData := a.clientData(ceremonyLogin, challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+
+	response := map[string]string{
+		"clientDataJSON":    encodeBase64(clientData),
+		"authenticatorData": encodeBase64(authData),
+		"signature":         encodeBase64(signature),
+	}
+	if a.userHandle != nil {
+		response["userHandle"] = encodeBase64(a.userHandle)
+	}
+	return credentialJSON(a.id, response)
+}
+
+func padded(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func credentialJSON(id []byte, response map[string]string) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"id":       encodeBase64(id),
+		"rawId":    encodeBase64(id),
+		"type":     "public-key",
+		"response": response,
+	})
+	return string(b)
+}
@@ -0,0 +1,100 @@
+package webauthn
+
+import (
+	"container/list"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// errTooManyChallenges is returned, if the maximum number of open challenges is reached.
+var errTooManyChallenges = errors.New("too many open webauthn challenges")
+
+// challengeSession is the state of a started registration or login.
+type challengeSession struct {
+	challenge string
+	ceremony  string
+	userInfo  model.UserInfo
+	expiresAt time.Time
+}
+
+// challenges keeps the issued challenges in memory, until they are used once or expire.
+// The number of open challenges is limited, so that unfinished ceremonies can not exhaust the memory.
+type challenges struct {
+	ttl time.Duration
+	max int
+	// sessions has the elements of the order list by challenge
+	sessions map[string]*list.Element
+	// order has the sessions in the order of their creation, which is also the order of their expiry
+	order *list.List
+	mu    sync.Mutex
+}
+
+func newChallenges(ttl time.Duration, max int) *challenges {
+	return &challenges{
+		ttl:      ttl,
+		max:      max,
+		sessions: map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// create returns a new random challenge for the ceremony.
+// It returns errTooManyChallenges, if the maximum number of not expired challenges is open.
+func (c *challenges) create(ceremony string, userInfo model.UserInfo) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	challenge := encodeBase64(b)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.removeExpired(now)
+	if len(c.sessions) >= c.max {
+		return "", errTooManyChallenges
+	}
+	c.sessions[challenge] = c.order.PushBack(challengeSession{
+		challenge: challenge,
+		ceremony:  ceremony,
+		userInfo:  userInfo,
+		expiresAt: now.Add(c.ttl),
+	})
+	return challenge, nil
+}
+
+// removeExpired removes the expired sessions from the front of the order.
+// Only the expired ones are visited, so the costs are spread over the creations.
+func (c *challenges) removeExpired(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		session := e.Value.(challengeSession)
+		if !now.After(session.expiresAt) {
+			return
+		}
+		c.order.Remove(e)
+		delete(c.sessions, session.challenge)
+	}
+}
+
+// use removes the challenge and returns its session, if it exists for the ceremony and is not expired.
+func (c *challenges) use(challenge, ceremony string) (challengeSession, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, exist := c.sessions[challenge]
+	if !exist {
+		return challengeSession{}, false
+	}
+	c.order.Remove(e)
+	delete(c.sessions, challenge)
+	session := e.Value.(challengeSession)
+	if session.ceremony != ceremony || time.Now().After(session.expiresAt) {
+		return challengeSession{}, false
+	}
+	return session, true
+}
@@ -0,0 +1,164 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Credential is a registered public key credential (passkey) of a user.
+type Credential struct {
+	// ID of the credential, as chosen by the authenticator
+	ID []byte `json:"id"`
+
+	// PublicKey in the COSE format
+	PublicKey []byte `json:"public_key"`
+
+	// SignCount is the signature counter of the last login
+	SignCount uint32 `json:"sign_count"`
+
+	// Sub of the user
+	Sub string `json:"sub"`
+
+	// Origin of the user at the registration, i.e. the backend which authenticated the user.
+	// It is used to resolve the current user info on a login.
+	Origin string `json:"origin"`
+
+	// Created is the time of the registration
+	Created time.Time `json:"created"`
+}
+
+// CredentialStore holds the registered credentials.
+type CredentialStore interface {
+	// Add stores a new credential.
+	Add(c Credential) error
+
+	// Get returns the credential with the id, or false, if it does not exist.
+	Get(id []byte) (Credential, bool, error)
+
+	// ByUser returns the credentials of the user with the sub.
+	ByUser(sub string) ([]Credential, error)
+
+	// UpdateSignCount stores the signature counter of the last login.
+	UpdateSignCount(id []byte, signCount uint32) error
+}
+
+// FileStore is a CredentialStore, which keeps the credentials in a json file.
+// The file is read at the start and rewritten on each change.
+type FileStore struct {
+	filename    string
+	credentials []Credential
+	mu          sync.Mutex
+}
+
+// NewFileStore creates a FileStore. A missing file is created on the first registration.
+func NewFileStore(filename string) (*FileStore, error) {
+	s := &FileStore{filename: filename}
+
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, &s.credentials); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add stores a new credential.
+func (s *FileStore) Add(c Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := append(s.copy(), c)
+	if err := s.write(credentials); err != nil {
+		return err
+	}
+	s.credentials = credentials
+	return nil
+}
+
+// Get returns the credential with the id, or false, if it does not exist.
+func (s *FileStore) Get(id []byte) (Credential, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.credentials {
+		if bytes.Equal(c.ID, id) {
+			return c, true, nil
+		}
+	}
+	return Credential{}, false, nil
+}
+
+// ByUser returns the credentials of the user with the sub.
+func (s *FileStore) ByUser(sub string) ([]Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var credentials []Credential
+	for _, c := range s.credentials {
+		if c.Sub == sub {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
+
+// UpdateSignCount stores the signature counter of the last login.
+func (s *FileStore) UpdateSignCount(id []byte, signCount uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := s.copy()
+	for i := range credentials {
+		if bytes.Equal(credentials[i].ID, id) {
+			if credentials[i].SignCount == signCount {
+				return nil
+			}
+			credentials[i].SignCount = signCount
+			if err := s.write(credentials); err != nil {
+				return err
+			}
+			s.credentials = credentials
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) copy() []Credential {
+	return append([]Credential{}, s.credentials...)
+}
+
+// write replaces the file atomically by a temporary file in the same directory.
+func (s *FileStore) write(credentials []Credential) error {
+	b, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.filename)
+}
@@ -0,0 +1,72 @@
+package webauthn
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loginsrv_webauthn")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "credentials.json")
+
+	s, err := NewFileStore(filename)
+	NoError(t, err)
+
+	_, exist, err := s.Get([]byte{1})
+	NoError(t, err)
+	False(t, exist)
+
+	created := time.Now().Truncate(time.Second)
+	NoError(t, s.Add(Credential{ID: []byte{1}, PublicKey: []byte{2}, Sub: "bob", Origin: "htpasswd", Created: created}))
+	NoError(t, s.Add(Credential{ID: []byte{3}, PublicKey: []byte{4}, Sub: "alice", Origin: "simple", Created: created}))
+	NoError(t, s.UpdateSignCount([]byte{1}, 42))
+
+	info, err := os.Stat(filename)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a new store reads the credentials from the file
+	s, err = NewFileStore(filename)
+	NoError(t, err)
+
+	c, exist, err := s.Get([]byte{1})
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, uint32(42), c.SignCount)
+	Equal(t, []byte{2}, c.PublicKey)
+	Equal(t, "bob", c.Sub)
+	Equal(t, "htpasswd", c.Origin)
+	True(t, created.Equal(c.Created))
+
+	credentials, err := s.ByUser("alice")
+	NoError(t, err)
+	Equal(t, 1, len(credentials))
+	Equal(t, []byte{3}, credentials[0].ID)
+
+	// no temporary files are left
+	files, _ := ioutil.ReadDir(dir)
+	Equal(t, 1, len(files))
+}
+
+func TestFileStore_Errors(t *testing.T) {
+	f, _ := ioutil.TempFile("", "loginsrv_webauthn")
+	f.WriteString("{no json")
+	f.Close()
+	defer os.Remove(f.Name())
+
+	_, err := NewFileStore(f.Name())
+	Error(t, err)
+
+	s, err := NewFileStore("/tmp/foo/bar/nothing/credentials.json")
+	NoError(t, err)
+	Error(t, s.Add(Credential{ID: []byte{1}}))
+	_, exist, _ := s.Get([]byte{1})
+	False(t, exist)
+}
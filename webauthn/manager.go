@@ -0,0 +1,470 @@
+package webauthn
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// Origin is the origin of the user info for passkey logins
+const Origin = "webauthn"
+
+// The paths of the endpoints, relative to the webauthn path
+const (
+	PathRegisterBegin  = "/register/begin"
+	PathRegisterFinish = "/register/finish"
+	PathLoginBegin     = "/login/begin"
+	PathLoginFinish    = "/login/finish"
+)
+
+const (
+	ceremonyRegister = "webauthn.create"
+	ceremonyLogin    = "webauthn.get"
+)
+
+// DefaultTimeout is the time a user has, to finish a registration or login
+const DefaultTimeout = 5 * time.Minute
+
+// DefaultMaxChallenges is the default number of registrations and logins, which can be open at the same time
+const DefaultMaxChallenges = 10000
+
+// challengesRetryAfter is the Retry-After in seconds, if the maximum number of challenges is open
+const challengesRetryAfter = 1
+
+// Config for the webauthn Manager
+type Config struct {
+	// RPID is the id of the relying party, i.e. the domain of loginsrv or a parent domain of it
+	RPID string
+
+	// RPName is the name of the relying party, shown by the authenticators
+	RPName string
+
+	// Origins are the allowed origins of the browser requests, e.g. https://login.example.com
+	Origins []string
+
+	// Store for the registered credentials
+	Store CredentialStore
+
+	// Timeout for a registration or login
+	Timeout time.Duration
+
+	// RequireUserVerification requires the verification of the user by the authenticator, e.g. by a pin or fingerprint
+	RequireUserVerification bool
+
+	// MaxChallenges is the maximum number of open registrations and logins.
+	// Further ceremonies are answered with 503, until some are finished or expired.
+	MaxChallenges int
+}
+
+// Manager serves the webauthn registration and login endpoints.
+type Manager struct {
+	config     Config
+	challenges *challenges
+}
+
+// NewManager creates a Manager and verifies the configuration.
+func NewManager(config Config) (*Manager, error) {
+	if config.RPID == "" {
+		return nil, errors.New("missing relying party id for webauthn")
+	}
+	if config.Store == nil {
+		return nil, errors.New("missing credential store for webauthn")
+	}
+	if len(config.Origins) == 0 {
+		config.Origins = []string{"https://" + config.RPID}
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxChallenges <= 0 {
+		config.MaxChallenges = DefaultMaxChallenges
+	}
+	return &Manager{
+		config:     config,
+		challenges: newChallenges(config.Timeout, config.MaxChallenges),
+	}, nil
+}
+
+// Handle serves the webauthn endpoint of the path, which is relative to the webauthn path.
+// The session is the user info of the current token, or nil if there is no valid token.
+//
+// The ceremony steps are answered directly and responded is true. If responded is false and err is set,
+// the caller has to answer with an internal error.
+// On a finished login, authenticated is true and the user info has only the sub and the origin of the registering login.
+// The caller has to resolve the current user info from the backend and issue the token for it.
+// A VerificationError means, that the login was not accepted.
+func (m *Manager) Handle(w http.ResponseWriter, r *http.Request, path string, session *model.UserInfo) (
+	responded bool,
+	authenticated bool,
+	userInfo model.UserInfo,
+	err error) {
+
+	if r.Method != "POST" {
+		writeJSONError(w, 405, "method not allowed")
+		return true, false, model.UserInfo{}, nil
+	}
+
+	switch path {
+	case PathRegisterBegin:
+		if session == nil {
+			writeJSONError(w, 401, "login required for the registration")
+			return true, false, model.UserInfo{}, nil
+		}
+		err = m.beginRegistration(w, *session)
+		return err == nil, false, model.UserInfo{}, err
+	case PathRegisterFinish:
+		if session == nil {
+			writeJSONError(w, 401, "login required for the registration")
+			return true, false, model.UserInfo{}, nil
+		}
+		err = m.finishRegistration(w, r, *session)
+		return err == nil, false, model.UserInfo{}, err
+	case PathLoginBegin:
+		err = m.beginLogin(w)
+		return err == nil, false, model.UserInfo{}, err
+	case PathLoginFinish:
+		userInfo, err := m.finishLogin(r)
+		if err != nil {
+			return false, false, model.UserInfo{}, err
+		}
+		return false, true, userInfo, nil
+	}
+
+	writeJSONError(w, 404, "not found")
+	return true, false, model.UserInfo{}, nil
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type creationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     map[string]string      `json:"rp"`
+	User                   map[string]string      `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	AuthenticatorSelection map[string]interface{} `json:"authenticatorSelection"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+}
+
+type requestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	UserVerification string                 `json:"userVerification"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+}
+
+// publicKeyCredential is the response of the browser, with all binary values base64url encoded.
+type publicKeyCredential struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+func (m *Manager) beginRegistration(w http.ResponseWriter, session model.UserInfo) error {
+	existing, err := m.config.Store.ByUser(session.Sub)
+	if err != nil {
+		return err
+	}
+
+	challenge, err := m.challenges.create(ceremonyRegister, session)
+	if err == errTooManyChallenges {
+		writeTooManyChallenges(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	displayName := session.Name
+	if displayName == "" {
+		displayName = session.Sub
+	}
+	options := creationOptions{
+		Challenge: challenge,
+		RP:        map[string]string{"id": m.config.RPID, "name": m.config.RPName},
+		User: map[string]string{
+			"id":          encodeBase64(userHandle(session.Sub)),
+			"name":        session.Sub,
+			"displayName": displayName,
+		},
+		Timeout:     m.config.Timeout.Nanoseconds() / int64(time.Millisecond),
+		Attestation: "none",
+		AuthenticatorSelection: map[string]interface{}{
+			"residentKey":        "required",
+			"requireResidentKey": true,
+			"userVerification":   m.userVerification(),
+		},
+		ExcludeCredentials: []credentialDescriptor{},
+	}
+	for _, alg := range supportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, credentialParameter{"public-key", alg})
+	}
+	for _, c := range existing {
+		options.ExcludeCredentials = append(options.ExcludeCredentials, credentialDescriptor{"public-key", encodeBase64(c.ID)})
+	}
+
+	writeJSON(w, 200, map[string]interface{}{"publicKey": options})
+	return nil
+}
+
+func (m *Manager) finishRegistration(w http.ResponseWriter, r *http.Request, session model.UserInfo) error {
+	credential, err := m.verifyRegistration(r, session)
+	if verr, ok := err.(*VerificationError); ok {
+		logging.Application(r.Header).WithField("username", session.Sub).WithError(verr).Info("passkey registration rejected")
+		writeJSONError(w, 400, verr.Reason)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.config.Store.Add(credential); err != nil {
+		return err
+	}
+	logging.Application(r.Header).WithField("username", session.Sub).Info("registered passkey")
+	writeJSON(w, 201, map[string]string{"status": "registered"})
+	return nil
+}
+
+func (m *Manager) verifyRegistration(r *http.Request, session model.UserInfo) (Credential, error) {
+	pkc, raw, err := decodeCredential(r, "clientDataJSON", "attestationObject")
+	if err != nil {
+		return Credential{}, err
+	}
+
+	cd, err := parseClientData(raw["clientDataJSON"], ceremonyRegister, m.config.Origins)
+	if err != nil {
+		return Credential{}, err
+	}
+	challenge, ok := m.challenges.use(cd.Challenge, ceremonyRegister)
+	if !ok || challenge.userInfo.Sub != session.Sub {
+		return Credential{}, verificationError("unknown or expired challenge")
+	}
+
+	ao, err := parseAttestationObject(raw["attestationObject"])
+	if err != nil {
+		return Credential{}, err
+	}
+	ad, err := parseAuthenticatorData(ao.AuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if err := ad.verify(m.config.RPID, m.config.RequireUserVerification); err != nil {
+		return Credential{}, err
+	}
+	if ad.credentialID == nil {
+		return Credential{}, verificationError("no attested credential data")
+	}
+	if rawID, err := decodeBase64(pkc.RawID); err != nil || subtle.ConstantTimeCompare(rawID, ad.credentialID) != 1 {
+		return Credential{}, verificationError("credential id does not match")
+	}
+	if _, _, err := parsePublicKey(ad.publicKey); err != nil {
+		return Credential{}, err
+	}
+
+	_, exist, err := m.config.Store.Get(ad.credentialID)
+	if err != nil {
+		return Credential{}, err
+	}
+	if exist {
+		return Credential{}, verificationError("credential is already registered")
+	}
+
+	origin, err := m.registrationOrigin(session)
+	if err != nil {
+		return Credential{}, err
+	}
+	return Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		SignCount: ad.signCount,
+		Sub:       session.Sub,
+		Origin:    origin,
+		Created:   time.Now(),
+	}, nil
+}
+
+// registrationOrigin returns the origin of the user for a new credential.
+// A session of a passkey login has the origin of the user's existing credentials.
+func (m *Manager) registrationOrigin(session model.UserInfo) (string, error) {
+	if session.Origin != Origin {
+		return session.Origin, nil
+	}
+	existing, err := m.config.Store.ByUser(session.Sub)
+	if err != nil || len(existing) == 0 {
+		return "", err
+	}
+	return existing[0].Origin, nil
+}
+
+// beginLogin returns the options for a login with a discoverable passkey.
+// The credentials of a username are not offered, because this would reveal, which users have passkeys.
+func (m *Manager) beginLogin(w http.ResponseWriter) error {
+	options := requestOptions{
+		RPID:             m.config.RPID,
+		Timeout:          m.config.Timeout.Nanoseconds() / int64(time.Millisecond),
+		UserVerification: m.userVerification(),
+		AllowCredentials: []credentialDescriptor{},
+	}
+
+	challenge, err := m.challenges.create(ceremonyLogin, model.UserInfo{})
+	if err == errTooManyChallenges {
+		writeTooManyChallenges(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	options.Challenge = challenge
+
+	writeJSON(w, 200, map[string]interface{}{"publicKey": options})
+	return nil
+}
+
+func (m *Manager) finishLogin(r *http.Request) (model.UserInfo, error) {
+	pkc, raw, err := decodeCredential(r, "clientDataJSON", "authenticatorData", "signature")
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+
+	cd, err := parseClientData(raw["clientDataJSON"], ceremonyLogin, m.config.Origins)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if _, ok := m.challenges.use(cd.Challenge, ceremonyLogin); !ok {
+		return model.UserInfo{}, verificationError("unknown or expired challenge")
+	}
+
+	id, err := decodeBase64(pkc.RawID)
+	if err != nil {
+		return model.UserInfo{}, verificationError("invalid credential id")
+	}
+	credential, exist, err := m.config.Store.Get(id)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if !exist {
+		return model.UserInfo{}, verificationError("unknown credential")
+	}
+	if pkc.Response.UserHandle != "" {
+		handle, err := decodeBase64(pkc.Response.UserHandle)
+		if err != nil || subtle.ConstantTimeCompare(handle, userHandle(credential.Sub)) != 1 {
+			return model.UserInfo{}, verificationError("user handle does not match")
+		}
+	}
+
+	ad, err := parseAuthenticatorData(raw["authenticatorData"])
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if err := ad.verify(m.config.RPID, m.config.RequireUserVerification); err != nil {
+		return model.UserInfo{}, err
+	}
+	if err := verifySignature(credential.PublicKey, raw["authenticatorData"], raw["clientDataJSON"], raw["signature"]); err != nil {
+		return model.UserInfo{}, err
+	}
+
+	// a counter, which does not increase, points to a cloned authenticator
+	if (ad.signCount != 0 || credential.SignCount != 0) && ad.signCount <= credential.SignCount {
+		return model.UserInfo{}, verificationError("signature counter did not increase")
+	}
+	if err := m.config.Store.UpdateSignCount(credential.ID, ad.signCount); err != nil {
+		return model.UserInfo{}, err
+	}
+
+	return model.UserInfo{Sub: credential.Sub, Origin: credential.Origin}, nil
+}
+
+func (m *Manager) userVerification() string {
+	if m.config.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// decodeCredential reads the credential of the browser from the json body or the form field credential
+// and decodes the named response fields.
+func decodeCredential(r *http.Request, fields ...string) (publicKeyCredential, map[string][]byte, error) {
+	// the login form posts the credential as form field, so that the browser follows the redirect
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		body = strings.NewReader(r.FormValue("credential"))
+	}
+
+	pkc := publicKeyCredential{}
+	if err := json.NewDecoder(body).Decode(&pkc); err != nil {
+		return pkc, nil, verificationError("invalid credential: %v", err)
+	}
+	if pkc.Type != "public-key" {
+		return pkc, nil, verificationError("unsupported credential type %q", pkc.Type)
+	}
+
+	values := map[string]string{
+		"clientDataJSON":    pkc.Response.ClientDataJSON,
+		"attestationObject": pkc.Response.AttestationObject,
+		"authenticatorData": pkc.Response.AuthenticatorData,
+		"signature":         pkc.Response.Signature,
+	}
+	raw := map[string][]byte{}
+	for _, field := range fields {
+		b, err := decodeBase64(values[field])
+		if err != nil || len(b) == 0 {
+			return pkc, nil, verificationError("missing or invalid %v", field)
+		}
+		raw[field] = b
+	}
+	return pkc, raw, nil
+}
+
+// userHandle is the id of the user for the authenticators.
+// The hash of the sub is used, so that the user handle has a fixed length and does not contain the username.
+func userHandle(sub string) []byte {
+	h := sha256.Sum256([]byte(sub))
+	return h[:]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // ignore error of encoding
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeTooManyChallenges(w http.ResponseWriter) {
+	logging.Logger.Warn("maximum number of open webauthn challenges reached")
+	w.Header().Set("Retry-After", strconv.Itoa(challengesRetryAfter))
+	writeJSONError(w, 503, "too many open registrations or logins, try again later")
+}
@@ -0,0 +1,348 @@
+package webauthn
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://login.example.com"
+)
+
+var bob = model.UserInfo{Sub: "bob", Name: "Bob", Origin: "simple", Groups: []string{"admins"}, Expiry: 4711, Refreshes: 1}
+
+func testManager(t *testing.T) (*Manager, func()) {
+	dir, err := ioutil.TempDir("", "loginsrv_webauthn")
+	NoError(t, err)
+	store, err := NewFileStore(filepath.Join(dir, "credentials.json"))
+	NoError(t, err)
+
+	m, err := NewManager(Config{
+		RPID:    testRPID,
+		RPName:  "Example",
+		Origins: []string{testOrigin},
+		Store:   store,
+	})
+	NoError(t, err)
+	return m, func() { os.RemoveAll(dir) }
+}
+
+// call does a request to the manager and returns the recorder together with the results of Handle
+func call(m *Manager, path, body string, session *model.UserInfo) (*httptest.ResponseRecorder, bool, bool, model.UserInfo, error) {
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/login/webauthn"+path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	responded, authenticated, userInfo, err := m.Handle(recorder, r, path, session)
+	return recorder, responded, authenticated, userInfo, err
+}
+
+func challengeOf(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	options := struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &options))
+	NotEmpty(t, options.PublicKey.Challenge)
+	return options.PublicKey.Challenge
+}
+
+func register(t *testing.T, m *Manager, a *testAuthenticator) {
+	recorder, responded, _, _, err := call(m, PathRegisterBegin, "", &bob)
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, 200, recorder.Code)
+
+	recorder, responded, _, _, err = call(m, PathRegisterFinish, a.create(challengeOf(t, recorder)), &bob)
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, 201, recorder.Code, recorder.Body.String())
+}
+
+func login(m *Manager, a *testAuthenticator, body string) (bool, model.UserInfo, error) {
+	recorder, _, _, _, _ := call(m, PathLoginBegin, body, nil)
+	options := struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}{}
+	json.Unmarshal(recorder.Body.Bytes(), &options)
+
+	_, _, authenticated, userInfo, err := call(m, PathLoginFinish, a.get(options.PublicKey.Challenge), nil)
+	return authenticated, userInfo, err
+}
+
+func TestManager_NewManager(t *testing.T) {
+	_, err := NewManager(Config{Store: &FileStore{}})
+	Error(t, err)
+
+	_, err = NewManager(Config{RPID: testRPID})
+	Error(t, err)
+
+	m, err := NewManager(Config{RPID: testRPID, Store: &FileStore{}})
+	NoError(t, err)
+	Equal(t, []string{"https://example.com"}, m.config.Origins)
+	Equal(t, testRPID, m.config.RPName)
+	Equal(t, DefaultTimeout, m.config.Timeout)
+	Equal(t, DefaultMaxChallenges, m.config.MaxChallenges)
+}
+
+func TestManager_RegisterAndLogin(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	a := newTestAuthenticator(testRPID, testOrigin)
+
+	register(t, m, a)
+
+	credentials, err := m.config.Store.ByUser("bob")
+	NoError(t, err)
+	Equal(t, 1, len(credentials))
+	Equal(t, a.id, credentials[0].ID)
+	Equal(t, "bob", credentials[0].Sub)
+	Equal(t, "simple", credentials[0].Origin)
+
+	// the registration options exclude the registered credential
+	recorder, _, _, _, _ := call(m, PathRegisterBegin, "", &bob)
+	Contains(t, recorder.Body.String(), encodeBase64(a.id))
+
+	// login with discoverable credential
+	a.userHandle = userHandle("bob")
+	authenticated, userInfo, err := login(m, a, "")
+	NoError(t, err)
+	True(t, authenticated)
+	// only the sub and origin are stored, the user info is resolved by the caller
+	Equal(t, model.UserInfo{Sub: "bob", Origin: "simple"}, userInfo)
+
+	credential, _, _ := m.config.Store.Get(a.id)
+	Equal(t, uint32(1), credential.SignCount)
+
+	// a session of a passkey login registers with the origin of the existing credentials
+	other := newTestAuthenticator(testRPID, testOrigin)
+	passkeySession := model.UserInfo{Sub: "bob", Origin: Origin}
+	recorder, _, _, _, _ = call(m, PathRegisterBegin, "", &passkeySession)
+	recorder, _, _, _, _ = call(m, PathRegisterFinish, other.create(challengeOf(t, recorder)), &passkeySession)
+	Equal(t, 201, recorder.Code)
+	credential, _, _ = m.config.Store.Get(other.id)
+	Equal(t, "simple", credential.Origin)
+}
+
+func TestManager_LoginByForm(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	a := newTestAuthenticator(testRPID, testOrigin)
+	register(t, m, a)
+
+	recorder, _, _, _, _ := call(m, PathLoginBegin, "", nil)
+	form := url.Values{"credential": {a.get(challengeOf(t, recorder))}}
+	r := httptest.NewRequest("POST", "/login/webauthn/login/finish", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	responded, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r, PathLoginFinish, nil)
+	NoError(t, err)
+	False(t, responded)
+	True(t, authenticated)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestManager_LoginBegin_AllowCredentials(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	a := newTestAuthenticator(testRPID, testOrigin)
+	register(t, m, a)
+
+	// the credentials of a user are never revealed
+	recorder, _, _, _, err := call(m, PathLoginBegin, `{"username": "bob"}`, nil)
+	NoError(t, err)
+	Equal(t, 200, recorder.Code)
+	NotContains(t, recorder.Body.String(), encodeBase64(a.id))
+	Contains(t, recorder.Body.String(), `"allowCredentials":[]`)
+	Contains(t, recorder.Body.String(), `"rpId":"example.com"`)
+}
+
+func TestManager_RegistrationNeedsSession(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+
+	for _, path := range []string{PathRegisterBegin, PathRegisterFinish} {
+		recorder, responded, _, _, err := call(m, path, "", nil)
+		NoError(t, err)
+		True(t, responded)
+		Equal(t, 401, recorder.Code)
+	}
+}
+
+func TestManager_RegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *testAuthenticator)
+	}{
+		{"wrong origin", func(a *testAuthenticator) { a.origin = "https://evil.example.org" }},
+		{"wrong rp id", func(a *testAuthenticator) { a.rpID = "evil.example.org" }},
+		{"user not present", func(a *testAuthenticator) { a.flags = 0 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, cleanup := testManager(t)
+			defer cleanup()
+			a := newTestAuthenticator(testRPID, testOrigin)
+			test.modify(a)
+
+			recorder, _, _, _, _ := call(m, PathRegisterBegin, "", &bob)
+			recorder, responded, _, _, err := call(m, PathRegisterFinish, a.create(challengeOf(t, recorder)), &bob)
+			NoError(t, err)
+			True(t, responded)
+			Equal(t, 400, recorder.Code)
+
+			credentials, _ := m.config.Store.ByUser("bob")
+			Empty(t, credentials)
+		})
+	}
+
+	m, cleanup := testManager(t)
+	defer cleanup()
+	a := newTestAuthenticator(testRPID, testOrigin)
+
+	// unknown challenge
+	recorder, _, _, _, _ := call(m, PathRegisterFinish, a.create("unknown"), &bob)
+	Equal(t, 400, recorder.Code)
+
+	// challenge of another user
+	alice := model.UserInfo{Sub: "alice"}
+	recorder, _, _, _, _ = call(m, PathRegisterBegin, "", &alice)
+	recorder, _, _, _, _ = call(m, PathRegisterFinish, a.create(challengeOf(t, recorder)), &bob)
+	Equal(t, 400, recorder.Code)
+
+	// the same credential can not be registered twice
+	register(t, m, a)
+	recorder, _, _, _, _ = call(m, PathRegisterBegin, "", &bob)
+	recorder, _, _, _, _ = call(m, PathRegisterFinish, a.create(challengeOf(t, recorder)), &bob)
+	Equal(t, 400, recorder.Code)
+}
+
+func TestManager_LoginRejected(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	a := newTestAuthenticator(testRPID, testOrigin)
+	register(t, m, a)
+
+	expectRejected := func(t *testing.T, authenticated bool, err error) {
+		False(t, authenticated)
+		_, isVerificationError := err.(*VerificationError)
+		True(t, isVerificationError, "%v", err)
+	}
+
+	// unknown credential
+	other := newTestAuthenticator(testRPID, testOrigin)
+	authenticated, _, err := login(m, other, "")
+	expectRejected(t, authenticated, err)
+
+	// a key, which does not match the registered one
+	other.id = a.id
+	authenticated, _, err = login(m, other, "")
+	expectRejected(t, authenticated, err)
+
+	// wrong user handle
+	a.userHandle = userHandle("alice")
+	authenticated, _, err = login(m, a, "")
+	expectRejected(t, authenticated, err)
+	a.userHandle = nil
+
+	// wrong origin
+	a.origin = "https://evil.example.org"
+	authenticated, _, err = login(m, a, "")
+	expectRejected(t, authenticated, err)
+	a.origin = testOrigin
+
+	// the challenge can be used only once
+	recorder, _, _, _, _ := call(m, PathLoginBegin, "", nil)
+	challenge := challengeOf(t, recorder)
+	_, _, authenticated, _, err = call(m, PathLoginFinish, a.get(challenge), nil)
+	NoError(t, err)
+	True(t, authenticated)
+	_, _, authenticated, _, err = call(m, PathLoginFinish, a.get(challenge), nil)
+	expectRejected(t, authenticated, err)
+
+	// a signature counter, which does not increase
+	a.signCount = 0
+	authenticated, _, err = login(m, a, "")
+	expectRejected(t, authenticated, err)
+
+	// invalid json
+	_, _, authenticated, _, err = call(m, PathLoginFinish, "{", nil)
+	expectRejected(t, authenticated, err)
+}
+
+func TestManager_ChallengeExpiry(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	m.challenges = newChallenges(time.Millisecond, DefaultMaxChallenges)
+	a := newTestAuthenticator(testRPID, testOrigin)
+
+	recorder, _, _, _, _ := call(m, PathRegisterBegin, "", &bob)
+	time.Sleep(5 * time.Millisecond)
+	recorder, _, _, _, _ = call(m, PathRegisterFinish, a.create(challengeOf(t, recorder)), &bob)
+	Equal(t, 400, recorder.Code)
+}
+
+func TestManager_MaxChallenges(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+	m.challenges = newChallenges(50*time.Millisecond, 2)
+	a := newTestAuthenticator(testRPID, testOrigin)
+
+	first, _, _, _, _ := call(m, PathLoginBegin, "", nil)
+	Equal(t, 200, first.Code)
+	recorder, _, _, _, _ := call(m, PathRegisterBegin, "", &bob)
+	Equal(t, 200, recorder.Code)
+
+	recorder, responded, _, _, err := call(m, PathLoginBegin, "", nil)
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, 503, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	recorder, _, _, _, _ = call(m, PathRegisterBegin, "", &bob)
+	Equal(t, 503, recorder.Code)
+
+	// a used challenge frees its place
+	call(m, PathLoginFinish, a.get(challengeOf(t, first)), nil)
+	recorder, _, _, _, _ = call(m, PathLoginBegin, "", nil)
+	Equal(t, 200, recorder.Code)
+	recorder, _, _, _, _ = call(m, PathLoginBegin, "", nil)
+	Equal(t, 503, recorder.Code)
+
+	// as well as the expired ones
+	time.Sleep(60 * time.Millisecond)
+	recorder, _, _, _, _ = call(m, PathLoginBegin, "", nil)
+	Equal(t, 200, recorder.Code)
+	recorder, _, _, _, _ = call(m, PathLoginBegin, "", nil)
+	Equal(t, 200, recorder.Code)
+	Equal(t, 2, m.challenges.order.Len())
+}
+
+func TestManager_NotFoundAndMethod(t *testing.T) {
+	m, cleanup := testManager(t)
+	defer cleanup()
+
+	recorder, responded, _, _, err := call(m, "/foo", "", nil)
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, 404, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	responded, _, _, err = m.Handle(recorder, httptest.NewRequest("GET", "/login/webauthn/login/begin", nil), PathLoginBegin, nil)
+	NoError(t, err)
+	True(t, responded)
+	Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,244 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// The COSE algorithms of the supported credential public keys
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// supportedAlgorithms are offered in the order of preference on registration
+var supportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// The flags of the authenticator data
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// VerificationError is returned, if the data of the client does not pass the verification.
+// Other errors are internal ones, e.g. of the credential store.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "webauthn verification failed: " + e.Reason
+}
+
+func verificationError(format string, a ...interface{}) error {
+	return &VerificationError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// clientData is the collected client data, signed by the authenticator.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func parseClientData(raw []byte, expectedType string, origins []string) (clientData, error) {
+	cd := clientData{}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return cd, verificationError("client data is no valid json: %v", err)
+	}
+	if cd.Type != expectedType {
+		return cd, verificationError("client data type is %q, expected %q", cd.Type, expectedType)
+	}
+	for _, origin := range origins {
+		if cd.Origin == origin {
+			return cd, nil
+		}
+	}
+	return cd, verificationError("origin %q is not allowed", cd.Origin)
+}
+
+// authenticatorData is the parsed data of the authenticator.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	ad := authenticatorData{}
+	if len(raw) < 37 {
+		return ad, verificationError("authenticator data too short")
+	}
+	ad.rpIDHash = raw[:32]
+	ad.flags = raw[32]
+	ad.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	if ad.flags&flagAttestedCredentialData == 0 {
+		return ad, nil
+	}
+
+	// aaguid (16 bytes), length of the credential id (2 bytes), credential id, public key
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return ad, verificationError("attested credential data too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return ad, verificationError("credential id exceeds the authenticator data")
+	}
+	ad.credentialID = rest[:idLength]
+
+	// the public key may be followed by extensions, so only the first cbor item is read
+	var publicKey cbor.RawMessage
+	dec := cbor.NewDecoder(bytes.NewReader(rest[idLength:]))
+	if err := dec.Decode(&publicKey); err != nil {
+		return ad, verificationError("invalid credential public key: %v", err)
+	}
+	ad.publicKey = []byte(publicKey)
+	return ad, nil
+}
+
+// verify checks the relying party and the user presence.
+func (ad authenticatorData) verify(rpID string, requireUserVerification bool) error {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, rpIDHash[:]) != 1 {
+		return verificationError("relying party id does not match")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return verificationError("user was not present")
+	}
+	if requireUserVerification && ad.flags&flagUserVerified == 0 {
+		return verificationError("user was not verified")
+	}
+	return nil
+}
+
+// attestationObject is the result of a registration.
+// The attestation statement is not verified, because the registration asks for no attestation.
+type attestationObject struct {
+	Fmt      string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+func parseAttestationObject(raw []byte) (attestationObject, error) {
+	ao := attestationObject{}
+	if err := cbor.Unmarshal(raw, &ao); err != nil {
+		return ao, verificationError("invalid attestation object: %v", err)
+	}
+	return ao, nil
+}
+
+// parsePublicKey parses a public key in the COSE format.
+func parsePublicKey(raw []byte) (alg int, key crypto.PublicKey, err error) {
+	m := map[int]interface{}{}
+	if err := cbor.Unmarshal(raw, &m); err != nil {
+		return 0, nil, verificationError("invalid credential public key: %v", err)
+	}
+
+	kty, _ := coseInt(m[1])
+	alg, _ = coseInt(m[3])
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := coseInt(m[-1])
+		x, _ := m[-2].([]byte)
+		y, _ := m[-3].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, verificationError("unsupported EC2 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return 0, nil, verificationError("EC2 key is not on the curve")
+		}
+		return alg, pub, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := coseInt(m[-1])
+		x, _ := m[-2].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, verificationError("unsupported OKP key")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := m[-1].([]byte)
+		e, _ := m[-2].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, verificationError("unsupported RSA key")
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return 0, nil, verificationError("unsupported key type %v with algorithm %v", kty, alg)
+}
+
+func coseInt(v interface{}) (int, bool) {
+	switch i := v.(type) {
+	case int64:
+		return int(i), true
+	case uint64:
+		return int(i), true
+	}
+	return 0, false
+}
+
+// verifySignature verifies the signature of an assertion over the authenticator data and the hash of the client data.
+func verifySignature(publicKey, authData, clientDataJSON, signature []byte) error {
+	alg, key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+	digest := sha256.Sum256(signed)
+
+	valid := false
+	switch alg {
+	case AlgES256:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(signature, &sig); err == nil && len(rest) == 0 {
+			valid = ecdsa.Verify(key.(*ecdsa.PublicKey), digest[:], sig.R, sig.S)
+		}
+	case AlgEdDSA:
+		valid = ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	case AlgRS256:
+		valid = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !valid {
+		return verificationError("invalid signature")
+	}
+	return nil
+}
+
+// decodeBase64 decodes the base64url values of the browser, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	if b, err := base64.URLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return nil, errors.New("invalid base64url encoding")
+}
+
+func encodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
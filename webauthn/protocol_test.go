@@ -0,0 +1,88 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignature_EdDSA(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	NoError(t, err)
+	coseKey, _ := cbor.Marshal(map[int]interface{}{1: 1, 3: AlgEdDSA, -1: 6, -2: []byte(pub)})
+
+	authData, clientData := []byte("authenticator data"), []byte("client data")
+	clientDataHash := sha256.Sum256(clientData)
+	signature := ed25519.Sign(priv, append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	NoError(t, verifySignature(coseKey, authData, clientData, signature))
+	Error(t, verifySignature(coseKey, authData, []byte("other client data"), signature))
+}
+
+func TestVerifySignature_RS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	NoError(t, err)
+	coseKey, _ := cbor.Marshal(map[int]interface{}{1: 3, 3: AlgRS256, -1: key.N.Bytes(), -2: big.NewInt(int64(key.E)).Bytes()})
+
+	authData, clientData := []byte("authenticator data"), []byte("client data")
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	NoError(t, err)
+
+	NoError(t, verifySignature(coseKey, authData, clientData, signature))
+	Error(t, verifySignature(coseKey, []byte("other authenticator data"), clientData, signature))
+}
+
+func TestParsePublicKey_Unsupported(t *testing.T) {
+	for _, key := range []map[int]interface{}{
+		{1: 2, 3: -35, -1: 2, -2: make([]byte, 48), -3: make([]byte, 48)},      // ES384
+		{1: 2, 3: AlgES256, -1: 1, -2: make([]byte, 32), -3: make([]byte, 32)}, // not on the curve
+		{1: 3, 3: AlgRS256, -1: make([]byte, 64), -2: []byte{1, 0, 1}},         // too short
+		{1: 1, 3: AlgEdDSA, -1: 7, -2: make([]byte, 32)},                       // Ed448
+	} {
+		b, _ := cbor.Marshal(key)
+		_, _, err := parsePublicKey(b)
+		Error(t, err, "%v", key)
+	}
+
+	_, _, err := parsePublicKey([]byte{0xff})
+	Error(t, err)
+}
+
+func TestParseAuthenticatorData_Errors(t *testing.T) {
+	_, err := parseAuthenticatorData(make([]byte, 36))
+	Error(t, err)
+
+	// attested credential data flag without data
+	data := make([]byte, 37)
+	data[32] = flagAttestedCredentialData
+	_, err = parseAuthenticatorData(data)
+	Error(t, err)
+
+	// credential id longer than the data
+	data = append(data, make([]byte, 16)...)
+	data = append(data, 0xff, 0xff)
+	_, err = parseAuthenticatorData(data)
+	Error(t, err)
+}
+
+func TestDecodeBase64(t *testing.T) {
+	b, err := decodeBase64("AQID")
+	NoError(t, err)
+	Equal(t, []byte{1, 2, 3}, b)
+
+	b, err = decodeBase64("AQ==")
+	NoError(t, err)
+	Equal(t, []byte{1}, b)
+
+	_, err = decodeBase64("!!")
+	Error(t, err)
+}